@@ -1,15 +1,19 @@
 package main
 
 // A small echo server binary used for manual testing and examples. It
-// delegates to internal/server.Serve to exercise tcplite framing and
-// codec handling.
+// delegates to internal/server.ServeConfig to exercise tcplite framing and
+// codec handling, and answers HTTP GET /healthz on the same port. The
+// connection limits keep stuck or abusive clients from piling up.
 
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/anthony/gopher-pipe/internal/server"
+	"github.com/anthony/gopher-pipe/internal/tcplite"
 )
 
 func main() {
@@ -17,8 +21,21 @@ func main() {
 	if len(os.Args) > 1 {
 		addr = os.Args[1]
 	}
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
 	fmt.Println("echo server listening on", addr)
-	if err := server.Serve(addr); err != nil {
+	cfg := server.Config{
+		HTTPHandler: http.DefaultServeMux,
+		Limits: tcplite.Limits{
+			IdleTimeout:    5 * time.Minute,
+			HeaderTimeout:  10 * time.Second,
+			PayloadTimeout: 30 * time.Second,
+			Budget:         tcplite.NewBudget(64 << 20),
+		},
+		MaxConnsPerIP: 64,
+	}
+	if err := server.ServeConfig(addr, cfg); err != nil {
 		log.Fatalf("server exited: %v", err)
 	}
 }
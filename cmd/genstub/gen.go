@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/anthony/gopher-pipe/internal/contract"
+)
+
+const (
+	gopherpipePath = "github.com/anthony/gopher-pipe/gopherpipe"
+	gptestPath     = "github.com/anthony/gopher-pipe/gopherpipe/gptest"
+)
+
+// generator accumulates the body of a generated file and the imports it
+// needs. Types are printed relative to the contract package, recording an
+// import for every other package they mention.
+type generator struct {
+	pkg     *contract.Package
+	buf     bytes.Buffer
+	imports map[string]string // path -> package name
+}
+
+func newGenerator(pkg *contract.Package) *generator {
+	return &generator{pkg: pkg, imports: map[string]string{"context": "context", gopherpipePath: "gopherpipe"}}
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// typeString prints t as it must be spelled inside the generated file.
+func (g *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		if p.Path() == g.pkg.Path {
+			return ""
+		}
+		g.imports[p.Path()] = p.Name()
+		return p.Name()
+	})
+}
+
+// file assembles the header, imports and body and gofmts the result.
+func (g *generator) file() ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by genstub. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", g.pkg.Name)
+	// standard library imports first, then everything else
+	var std, other []string
+	for p := range g.imports {
+		if strings.Contains(strings.SplitN(p, "/", 2)[0], ".") {
+			other = append(other, p)
+		} else {
+			std = append(std, p)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	fmt.Fprintf(&out, "import (\n")
+	for i, group := range [][]string{std, other} {
+		if i > 0 && len(std) > 0 && len(group) > 0 {
+			fmt.Fprintf(&out, "\n")
+		}
+		for _, p := range group {
+			if name := g.imports[p]; name != p[strings.LastIndex(p, "/")+1:] {
+				fmt.Fprintf(&out, "\t%s %q\n", name, p)
+			} else {
+				fmt.Fprintf(&out, "\t%q\n", p)
+			}
+		}
+	}
+	fmt.Fprintf(&out, ")\n")
+	out.Write(g.buf.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v\n%s", err, out.Bytes())
+	}
+	return src, nil
+}
+
+// clientName derives the client type name from a service name:
+// ChatService becomes ChatClient.
+func clientName(service string) string {
+	return strings.TrimSuffix(service, "Service") + "Client"
+}
+
+// reservedNames are identifiers the generated method bodies use; contract
+// parameters with these names are renamed.
+var reservedNames = map[string]bool{
+	"cc": true, "ctx": true, "s": true, "err": true, "context": true, "gopherpipe": true,
+	"m": true, "gptest": true,
+}
+
+// paramNames returns the identifiers used for m's non-context parameters.
+func paramNames(m *contract.Method) []string {
+	names := make([]string, len(m.Params))
+	for i, p := range m.Params {
+		n := p.Name
+		if n == "" || n == "_" || reservedNames[n] || (n[0] == 'r' && strings.Trim(n[1:], "0123456789") == "") {
+			n = fmt.Sprintf("a%d", i)
+		}
+		names[i] = n
+	}
+	return names
+}
+
+// generateClients emits a typed client for every service in pkg.
+func generateClients(pkg *contract.Package) ([]byte, error) {
+	g := newGenerator(pkg)
+	for _, svc := range pkg.Services {
+		g.client(svc)
+	}
+	return g.file()
+}
+
+// client emits the client type, its constructors and one method per
+// contract method.
+func (g *generator) client(svc *contract.Service) {
+	name := clientName(svc.Name)
+	g.printf("\n// %s is the generated GopherPipe client for %s.\n", name, svc.Name)
+	g.printf("type %s struct {\n\tc *gopherpipe.Client\n}\n\n", name)
+	g.printf("var _ %s = (*%s)(nil)\n\n", svc.Name, name)
+	g.printf("// New%s dials addr and returns a %s client.\n", name, svc.Name)
+	g.printf("func New%s(addr string) (*%s, error) {\n", name, name)
+	g.printf("\tc, err := gopherpipe.Dial(addr)\n\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+	g.printf("\treturn &%s{c: c}, nil\n}\n\n", name)
+	g.printf("// New%sFrom returns a %s client that issues calls on c.\n", name, svc.Name)
+	g.printf("func New%sFrom(c *gopherpipe.Client) *%s {\n\treturn &%s{c: c}\n}\n\n", name, name, name)
+	g.printf("// Close closes the underlying connection.\n")
+	g.printf("func (cc *%s) Close() error {\n\treturn cc.c.Close()\n}\n", name)
+	var idempotent, readOnly []string
+	for _, m := range svc.Methods {
+		switch {
+		case m.ReadOnly:
+			readOnly = append(readOnly, fmt.Sprintf("%q", m.Name))
+		case m.Idempotent:
+			idempotent = append(idempotent, fmt.Sprintf("%q", m.Name))
+		}
+	}
+	if len(idempotent) > 0 || len(readOnly) > 0 {
+		g.printf("\nfunc init() {\n")
+		if len(idempotent) > 0 {
+			g.printf("\t// methods marked %s, which retry policies apply to\n", contract.IdempotentDirective)
+			g.printf("\tgopherpipe.RegisterIdempotent(%q, %s)\n", svc.Name, strings.Join(idempotent, ", "))
+		}
+		if len(readOnly) > 0 {
+			g.printf("\t// methods marked %s, which hedging and retry policies apply to\n", contract.ReadOnlyDirective)
+			g.printf("\tgopherpipe.RegisterReadOnly(%q, %s)\n", svc.Name, strings.Join(readOnly, ", "))
+		}
+		g.printf("}\n")
+	}
+	for _, m := range svc.Methods {
+		g.clientMethod(svc, name, m)
+	}
+}
+
+// clientMethod emits one client method mirroring the contract signature.
+func (g *generator) clientMethod(svc *contract.Service, client string, m *contract.Method) {
+	names := paramNames(m)
+	var params, args []string
+	if m.HasContext {
+		params = append(params, "ctx context.Context")
+	}
+	for i, p := range m.Params {
+		params = append(params, names[i]+" "+g.typeString(p.Type))
+		args = append(args, names[i])
+	}
+	var results []string
+	for _, r := range m.Results {
+		results = append(results, g.typeString(r))
+	}
+	results = append(results, "error")
+	ctx := "context.Background()"
+	if m.HasContext {
+		ctx = "ctx"
+	}
+	argList := "nil"
+	if len(args) > 0 {
+		argList = "[]interface{}{" + strings.Join(args, ", ") + "}"
+	}
+
+	g.printf("\n// %s calls %s.%s.\n", m.Name, svc.Name, m.Name)
+	resultList := strings.Join(results, ", ")
+	if len(results) > 1 {
+		resultList = "(" + resultList + ")"
+	}
+	g.printf("func (cc *%s) %s(%s) %s {\n", client, m.Name, strings.Join(params, ", "), resultList)
+	switch m.RPCType {
+	case contract.ServerStream, contract.BiDi:
+		if !m.HasContext {
+			g.printf("\tctx := context.Background()\n")
+		}
+		g.printf("\ts, err := cc.c.NewStream(ctx, %q, %q, %s)\n", svc.Name, m.Name, argList)
+		g.printf("\tif err != nil {\n\t\treturn nil, err\n\t}\n")
+		recv := fmt.Sprintf("gopherpipe.RecvChan[%s](ctx, s)", g.typeString(m.OutElem()))
+		if isNamed(m.Results[0]) {
+			recv = fmt.Sprintf("%s(%s)", results[0], recv)
+		}
+		g.printf("\treturn %s, nil\n", recv)
+	default:
+		if len(m.Results) == 0 {
+			g.printf("\treturn cc.c.Call(%s, %q, %q, %s)\n", ctx, svc.Name, m.Name, argList)
+			break
+		}
+		var outs, ptrs []string
+		for i, r := range results[:len(results)-1] {
+			g.printf("\tvar r%d %s\n", i, r)
+			outs = append(outs, fmt.Sprintf("r%d", i))
+			ptrs = append(ptrs, fmt.Sprintf("&r%d", i))
+		}
+		g.printf("\terr := cc.c.Call(%s, %q, %q, %s, %s)\n", ctx, svc.Name, m.Name, argList, strings.Join(ptrs, ", "))
+		g.printf("\treturn %s, err\n", strings.Join(outs, ", "))
+	}
+	g.printf("}\n")
+}
+
+// rpcTypeNames maps contract RPC types to gopherpipe constant names.
+var rpcTypeNames = map[string]string{
+	contract.Unary:        "gopherpipe.Unary",
+	contract.ClientStream: "gopherpipe.ClientStream",
+	contract.ServerStream: "gopherpipe.ServerStream",
+	contract.BiDi:         "gopherpipe.BiDi",
+}
+
+// handlersName derives the unexported adapter type name from a service
+// name: ChatService becomes chatServiceHandlers.
+func handlersName(service string) string {
+	return strings.ToLower(service[:1]) + service[1:] + "Handlers"
+}
+
+// generateServers emits reflection-free server adapters and a
+// Register<Service>Server function for every service in pkg.
+func generateServers(pkg *contract.Package) ([]byte, error) {
+	g := newGenerator(pkg)
+	for _, svc := range pkg.Services {
+		g.server(svc)
+	}
+	return g.file()
+}
+
+// server emits the handler adapter of svc and its registration function.
+func (g *generator) server(svc *contract.Service) {
+	name := handlersName(svc.Name)
+	g.printf("\n// %s adapts a %s to gopherpipe method handlers.\n", name, svc.Name)
+	g.printf("type %s struct {\n\timpl %s\n}\n", name, svc.Name)
+	for _, m := range svc.Methods {
+		g.handler(name, m)
+	}
+	g.printf("\n// Register%sServer registers impl on srv using generated\n", svc.Name)
+	g.printf("// handlers, so calls are dispatched without reflection.\n")
+	g.printf("func Register%sServer(srv *gopherpipe.Server, impl %s) error {\n", svc.Name, svc.Name)
+	g.printf("\th := %s{impl: impl}\n", name)
+	g.printf("\treturn srv.RegisterServiceDesc(gopherpipe.ServiceDesc{\n\t\tName: %q,\n", svc.Name)
+	g.printf("\t\tMethods: []gopherpipe.MethodDesc{\n")
+	for _, m := range svc.Methods {
+		g.printf("\t\t\t{\n\t\t\t\tName: %q,\n\t\t\t\tRPCType: %s,\n\t\t\t\tHandler: h.%s,\n", m.Name, rpcTypeNames[m.RPCType], m.Name)
+		var args, results []types.Type
+		for _, p := range m.Args() {
+			args = append(args, p.Type)
+		}
+		results = m.Results
+		if out := m.OutElem(); out != nil {
+			results = []types.Type{out}
+		}
+		g.typeList("ArgTypes", args)
+		g.typeList("ResultTypes", results)
+		if in := m.InElem(); in != nil {
+			g.printf("\t\t\t\tInType: gopherpipe.TypeOf[%s](),\n", g.typeString(in))
+		}
+		g.printf("\t\t\t},\n")
+	}
+	g.printf("\t\t},\n\t})\n}\n")
+}
+
+// typeList emits a []reflect.Type field of a generated MethodDesc.
+func (g *generator) typeList(field string, ts []types.Type) {
+	if len(ts) == 0 {
+		return
+	}
+	var elems []string
+	for _, t := range ts {
+		elems = append(elems, fmt.Sprintf("gopherpipe.TypeOf[%s]()", g.typeString(t)))
+	}
+	g.imports["reflect"] = "reflect"
+	g.printf("\t\t\t\t%s: []reflect.Type{%s},\n", field, strings.Join(elems, ", "))
+}
+
+// handler emits the adapter method serving one contract method: decode
+// the argument tuple into concrete types, call the implementation and
+// send its results.
+func (g *generator) handler(recv string, m *contract.Method) {
+	g.printf("\nfunc (h %s) %s(ctx context.Context, call *gopherpipe.ServerCall) error {\n", recv, m.Name)
+	var args, ptrs []string
+	if m.HasContext {
+		args = append(args, "ctx")
+	}
+	for i, p := range m.Params {
+		a := fmt.Sprintf("a%d", i)
+		args = append(args, a)
+		if i == m.InIndex {
+			continue
+		}
+		g.printf("\tvar %s %s\n", a, g.typeString(p.Type))
+		ptrs = append(ptrs, "&"+a)
+	}
+	if len(ptrs) > 0 {
+		g.printf("\tif err := call.DecodeArgs(%s); err != nil {\n\t\treturn err\n\t}\n", strings.Join(ptrs, ", "))
+	}
+	if m.InIndex >= 0 {
+		in := fmt.Sprintf("gopherpipe.RecvStream[%s](ctx, call)", g.typeString(m.InElem()))
+		if t := m.Params[m.InIndex].Type; isNamed(t) {
+			in = fmt.Sprintf("%s(%s)", g.typeString(t), in)
+		}
+		g.printf("\ta%d := %s\n", m.InIndex, in)
+	}
+	call := fmt.Sprintf("h.impl.%s(%s)", m.Name, strings.Join(args, ", "))
+	if len(m.Results) == 0 {
+		g.printf("\treturn %s\n}\n", call)
+		return
+	}
+	var outs []string
+	for i := range m.Results {
+		outs = append(outs, fmt.Sprintf("r%d", i))
+	}
+	g.printf("\t%s, err := %s\n\tif err != nil {\n\t\treturn err\n\t}\n", strings.Join(outs, ", "), call)
+	if out := m.OutElem(); out != nil {
+		g.printf("\treturn gopherpipe.SendStream[%s](ctx, call, r0)\n}\n", g.typeString(out))
+		return
+	}
+	g.printf("\treturn call.SendResults(%s)\n}\n", strings.Join(outs, ", "))
+}
+
+func isNamed(t types.Type) bool {
+	_, ok := t.(*types.Named)
+	return ok
+}
+
+// mockName derives the mock type name from a service name: ChatService
+// becomes MockChatService.
+func mockName(service string) string {
+	return "Mock" + service
+}
+
+// generateMocks emits a programmable mock and a loopback client
+// constructor for every service in pkg. The loopback constructor uses the
+// generated client and server adapters, so it must be generated alongside
+// them.
+func generateMocks(pkg *contract.Package) ([]byte, error) {
+	g := newGenerator(pkg)
+	g.imports[gptestPath] = "gptest"
+	usesContext := false
+	for _, svc := range pkg.Services {
+		g.mock(svc)
+		g.loopback(svc)
+		for _, m := range svc.Methods {
+			usesContext = usesContext || m.HasContext
+		}
+	}
+	if !usesContext {
+		delete(g.imports, "context")
+	}
+	return g.file()
+}
+
+// signature returns the parameter and result lists of m as spelled in the
+// contract, using the parameter identifiers from paramNames.
+func (g *generator) signature(m *contract.Method) (params, results string) {
+	names := paramNames(m)
+	var ps, rs []string
+	if m.HasContext {
+		ps = append(ps, "ctx context.Context")
+	}
+	for i, p := range m.Params {
+		ps = append(ps, names[i]+" "+g.typeString(p.Type))
+	}
+	for _, r := range m.Results {
+		rs = append(rs, g.typeString(r))
+	}
+	rs = append(rs, "error")
+	results = strings.Join(rs, ", ")
+	if len(rs) > 1 {
+		results = "(" + results + ")"
+	}
+	return strings.Join(ps, ", "), results
+}
+
+// mock emits the mock type of svc and one recording method per contract
+// method.
+func (g *generator) mock(svc *contract.Service) {
+	name := mockName(svc.Name)
+	g.printf("\n// %s is a programmable %s for tests. Each method records\n", name, svc.Name)
+	g.printf("// its call and delegates to the matching Func field; methods without a\n")
+	g.printf("// stub fail with Unimplemented.\n")
+	g.printf("type %s struct {\n\tgptest.Recorder\n\n", name)
+	for _, m := range svc.Methods {
+		params, results := g.signature(m)
+		g.printf("\t%sFunc func(%s) %s\n", m.Name, params, results)
+	}
+	g.printf("}\n\nvar _ %s = (*%s)(nil)\n", svc.Name, name)
+	for _, m := range svc.Methods {
+		names := paramNames(m)
+		params, results := g.signature(m)
+		var args []string
+		if m.HasContext {
+			args = append(args, "ctx")
+		}
+		args = append(args, names...)
+		record := append([]string{fmt.Sprintf("%q", m.Name)}, names...)
+
+		g.printf("\n// %s records the call and invokes %sFunc.\n", m.Name, m.Name)
+		g.printf("func (m *%s) %s(%s) %s {\n", name, m.Name, params, results)
+		g.printf("\tm.Record(%s)\n", strings.Join(record, ", "))
+		g.printf("\tif m.%sFunc == nil {\n", m.Name)
+		var zeros []string
+		for i, r := range m.Results {
+			g.printf("\t\tvar r%d %s\n", i, g.typeString(r))
+			zeros = append(zeros, fmt.Sprintf("r%d", i))
+		}
+		zeros = append(zeros, fmt.Sprintf("gopherpipe.Errorf(gopherpipe.Unimplemented, %q)", name+"."+m.Name+" is not stubbed"))
+		g.printf("\t\treturn %s\n\t}\n", strings.Join(zeros, ", "))
+		g.printf("\treturn m.%sFunc(%s)\n}\n", m.Name, strings.Join(args, ", "))
+	}
+}
+
+// loopback emits a constructor returning the generated client wired to an
+// implementation through an in-memory connection.
+func (g *generator) loopback(svc *contract.Service) {
+	client := clientName(svc.Name)
+	ctor := "New" + strings.TrimSuffix(client, "Client") + "LoopbackClient"
+	g.printf("\n// %s returns a %s client calling impl over an in-memory\n", ctor, svc.Name)
+	g.printf("// connection. Every call goes through the full codec path, so\n")
+	g.printf("// serialization bugs surface in unit tests.\n")
+	g.printf("func %s(impl %s) (*%s, error) {\n", ctor, svc.Name, client)
+	g.printf("\tsrv := gopherpipe.NewServer(\"\")\n")
+	g.printf("\tif err := Register%sServer(srv, impl); err != nil {\n\t\treturn nil, err\n\t}\n", svc.Name)
+	g.printf("\treturn New%sFrom(gopherpipe.DialLoopback(srv)), nil\n}\n", client)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/internal/contract"
+	"github.com/anthony/gopher-pipe/internal/contract/contracttest"
+)
+
+// TestGeneratedChatUpToDate regenerates the example chat client, server
+// adapters and mocks and compares them with the committed files, so the two
+// can no longer drift.
+func TestGeneratedChatUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "example", "chat")
+	pkg, err := contract.Load(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	for file, generate := range map[string]func(*contract.Package) ([]byte, error){
+		"client_gen.go": generateClients,
+		"server_gen.go": generateServers,
+		"mock_gen.go":   generateMocks,
+	} {
+		got, err := generate(pkg)
+		if err != nil {
+			t.Fatalf("generate %s: %v", file, err)
+		}
+		want, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("example/chat/%s is stale; run go generate ./example/chat", file)
+		}
+		again, err := generate(pkg)
+		if err != nil || !bytes.Equal(got, again) {
+			t.Fatalf("%s output is not deterministic: %v", file, err)
+		}
+	}
+}
+
+// TestLoadRejectsUnsupportedSignatures verifies contract errors name the
+// offending methods.
+func TestLoadRejectsUnsupportedSignatures(t *testing.T) {
+	dir := contracttest.WriteModule(t, map[string]string{"bad.go": `package bad
+
+//gopherpipe:service
+type BadService interface {
+	NoError(x int) int
+	Callback(f func()) error
+}
+`})
+	_, err := contract.Load(dir)
+	if err == nil || !strings.Contains(err.Error(), "NoError") || !strings.Contains(err.Error(), "Callback") {
+		t.Fatalf("expected both methods reported, got %v", err)
+	}
+}
+
+// TestMethodDirectives verifies idempotent and read-only methods are
+// registered by the generated client.
+func TestMethodDirectives(t *testing.T) {
+	dir := contracttest.WriteModule(t, map[string]string{"kv.go": `package kv
+
+//gopherpipe:service
+type KVService interface {
+	//gopherpipe:readonly
+	Get(key string) (string, error)
+	//gopherpipe:idempotent
+	Put(key, value string) error
+	Append(key, value string) error
+}
+`})
+	pkg, err := contract.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range pkg.Services[0].Methods {
+		readOnly, idempotent := m.Name == "Get", m.Name != "Append"
+		if m.ReadOnly != readOnly || m.Idempotent != idempotent {
+			t.Errorf("%s: read-only %v, idempotent %v", m.Name, m.ReadOnly, m.Idempotent)
+		}
+	}
+	out, err := generateClients(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`gopherpipe.RegisterIdempotent("KVService", "Put")`, `gopherpipe.RegisterReadOnly("KVService", "Get")`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("generated client lacks %s:\n%s", want, out)
+		}
+	}
+}
@@ -1,62 +1,102 @@
-// Command genstub is a tiny toy generator used for examples. It writes a
-// simple pre-defined client stub into example/chat/client_gen.go so example
-// projects can compile without a full code-generator toolchain.
+// Command genstub generates typed GopherPipe clients and server adapters
+// from Go service contracts. It type-checks the package in -dir, finds
+// every interface marked with a //gopherpipe:service directive and writes
+// a client for each one to -out. Unless -server=false, it also writes a
+// Register<Service>Server function to -server-out that registers an
+// implementation through generated, reflection-free handlers. With -mock
+// it writes a programmable mock and a loopback client constructor per
+// service to -mock-out for use in tests. Methods marked with a
+// //gopherpipe:idempotent or //gopherpipe:readonly directive are
+// registered as such by the client file, so retry and hedging policies
+// apply to them. Output is gofmt'ed and deterministic, so it can be
+// committed and checked in CI.
+// Typical use is a go:generate line next to the contract:
+//
+//	//go:generate go run github.com/anthony/gopher-pipe/cmd/genstub
 package main
 
 import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/anthony/gopher-pipe/internal/contract"
 )
 
-// main runs the generator and writes a pre-canned client stub. The tool is
-// intentionally simple and only used for build/test examples in this repo.
+// main parses flags, generates the stubs and writes them to disk.
 func main() {
-	out := flag.String("out", "example/chat/client_gen.go", "path to generated file")
+	dir := flag.String("dir", ".", "directory of the package declaring the service interfaces")
+	out := flag.String("out", "", "path to generated client file (default <dir>/client_gen.go)")
+	server := flag.Bool("server", true, "also generate server adapters")
+	serverOut := flag.String("server-out", "", "path to generated server file (default <dir>/server_gen.go)")
+	mock := flag.Bool("mock", false, "also generate mocks and loopback clients (requires the server adapters)")
+	mockOut := flag.String("mock-out", "", "path to generated mock file (default <dir>/mock_gen.go)")
 	flag.Parse()
-	if err := os.MkdirAll("example/chat", 0755); err != nil {
-		fmt.Println("mkdir error:", err)
-		os.Exit(1)
+	if *out == "" {
+		*out = filepath.Join(*dir, "client_gen.go")
 	}
-	f, err := os.Create(*out)
-	if err != nil {
-		fmt.Println("create error:", err)
+	if !*server {
+		*serverOut = ""
+	} else if *serverOut == "" {
+		*serverOut = filepath.Join(*dir, "server_gen.go")
+	}
+	if *mock && !*server {
+		fmt.Fprintln(os.Stderr, "genstub: -mock requires the server adapters; drop -server=false")
+		os.Exit(2)
+	}
+	if !*mock {
+		*mockOut = ""
+	} else if *mockOut == "" {
+		*mockOut = filepath.Join(*dir, "mock_gen.go")
+	}
+	if err := run(*dir, *out, *serverOut, *mockOut); err != nil {
+		fmt.Fprintln(os.Stderr, "genstub:", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	f.WriteString(`package chat
-
-import (
-	"github.com/anthony/gopher-pipe/gopherpipe"
-	"github.com/anthony/gopher-pipe/internal/codec"
-)
-
-// ChatClient is a generated client stub (toy generator)
-type ChatClient struct {
-	c *gopherpipe.Client
+	fmt.Println("wrote", *out)
+	for _, p := range []string{*serverOut, *mockOut} {
+		if p != "" {
+			fmt.Println("wrote", p)
+		}
+	}
 }
 
-func NewChatClient(addr string) (*ChatClient, error) {
-	c, err := gopherpipe.Dial(addr)
+// run loads the contracts in dir and writes the generated clients to out.
+// The server adapters and mocks are written to serverOut and mockOut
+// unless those are empty.
+func run(dir, out, serverOut, mockOut string) error {
+	pkg, err := contract.Load(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &ChatClient{c: c}, nil
-}
-
-func (cc *ChatClient) Close() error {
-	return cc.c.Close()
-}
-
-func (cc *ChatClient) Login(user string) (bool, error) {
-	// Create a gob payload of the argument
-	// We reuse codec package for simple encode/decode
-	var out bool
-	if err := cc.c.CallUnary("ChatService", "Login", user, &out); err != nil {
-		return false, err
-	}
-	return out, nil
-}
-`)
-	fmt.Println("wrote", *out)
+	if len(pkg.Services) == 0 {
+		return fmt.Errorf("no interfaces marked %s in %s", contract.ServiceDirective, dir)
+	}
+	src, err := generateClients(pkg)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		path     string
+		generate func(*contract.Package) ([]byte, error)
+	}{
+		{serverOut, generateServers},
+		{mockOut, generateMocks},
+	} {
+		if f.path == "" {
+			continue
+		}
+		src, err := f.generate(pkg)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(f.path, src, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
 }
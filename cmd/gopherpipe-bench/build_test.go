@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestBuildBench checks that the go tool resolves cmd/gopherpipe-bench by
+// its import path, as the README's go run command needs.
+func TestBuildBench(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", "list", "github.com/anthony/gopher-pipe/cmd/gopherpipe-bench")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBits splits every power of two into 1<<subBits histogram buckets,
+// which bounds the error of a reported percentile to about 3%.
+const subBits = 4
+
+// histogram counts latencies in log-linear buckets, so its size is fixed
+// whatever the number of calls. min, max and the mean are exact.
+type histogram struct {
+	counts   [(64 - subBits + 1) << subBits]int64
+	total    int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+// bucketOf returns the bucket counting v nanoseconds.
+func bucketOf(v uint64) int {
+	if v < 1<<subBits {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBits - 1
+	return (shift+1)<<subBits + int(v>>uint(shift))&(1<<subBits-1)
+}
+
+// bucketRange returns the smallest value counted by bucket i and the
+// number of values it covers.
+func bucketRange(i int) (low, width uint64) {
+	if i < 1<<subBits {
+		return uint64(i), 1
+	}
+	shift := uint(i>>subBits - 1)
+	return (1<<subBits + uint64(i)&(1<<subBits-1)) << shift, 1 << shift
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.counts[bucketOf(uint64(d))]++
+	h.total++
+	h.sum += d
+}
+
+// merge adds the samples of o to h.
+func (h *histogram) merge(o *histogram) {
+	if o.total == 0 {
+		return
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	h.sum += o.sum
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// percentile returns the latency below which a fraction q of the samples
+// fall, e.g. q = 0.99 for p99.
+func (h *histogram) percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		if seen += n; seen >= rank {
+			return h.clamp(bucketMid(i))
+		}
+	}
+	return h.max
+}
+
+// bucketMid returns the middle of bucket i.
+func bucketMid(i int) time.Duration {
+	low, width := bucketRange(i)
+	return time.Duration(low + width/2)
+}
+
+// clamp limits d to the observed range, so the estimate for a bucket
+// holding the extremes is never outside it.
+func (h *histogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// bar is one line of the printed histogram.
+type bar struct {
+	low   time.Duration
+	count int64
+}
+
+// bars regroups the samples into n buckets of equal width between the
+// smallest and the largest latency.
+func (h *histogram) bars(n int) []bar {
+	if h.total == 0 {
+		return nil
+	}
+	span := h.max - h.min
+	if span < time.Duration(n) {
+		n = 1
+	}
+	out := make([]bar, n)
+	for i := range out {
+		out[i].low = h.min + span*time.Duration(i)/time.Duration(n)
+	}
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		j := 0
+		if span > 0 {
+			j = int(int64(h.clamp(bucketMid(i))-h.min) * int64(n) / int64(span))
+		}
+		if j >= n {
+			j = n - 1
+		}
+		out[j].count += c
+	}
+	return out
+}
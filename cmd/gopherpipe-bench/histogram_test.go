@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBucketsAreContiguous checks every value falls in the bucket whose
+// range contains it.
+func TestBucketsAreContiguous(t *testing.T) {
+	for _, v := range []uint64{0, 1, 15, 16, 17, 31, 32, 33, 1000, 123456789, 1<<63 + 5, ^uint64(0)} {
+		low, width := bucketRange(bucketOf(v))
+		if v < low || v-low >= width {
+			t.Errorf("value %d in bucket [%d, +%d)", v, low, width)
+		}
+	}
+	for i := 1; i < len(histogram{}.counts); i++ {
+		prevLow, prevWidth := bucketRange(i - 1)
+		if low, _ := bucketRange(i); low != prevLow+prevWidth {
+			t.Fatalf("bucket %d starts at %d, previous ends at %d", i, low, prevLow+prevWidth)
+		}
+	}
+}
+
+// TestPercentiles checks percentile estimates stay within the bucket
+// error and that merging equals recording everything in one histogram.
+func TestPercentiles(t *testing.T) {
+	var a, b histogram
+	for i := 1; i <= 10000; i++ {
+		d := time.Duration(i) * time.Microsecond
+		if i%2 == 0 {
+			a.record(d)
+		} else {
+			b.record(d)
+		}
+	}
+	a.merge(&b)
+	if a.total != 10000 || a.min != time.Microsecond || a.max != 10*time.Millisecond {
+		t.Fatalf("total %d, min %v, max %v", a.total, a.min, a.max)
+	}
+	if mean := a.mean(); mean != 5000500*time.Nanosecond {
+		t.Errorf("mean = %v", mean)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 5 * time.Millisecond}, {0.9, 9 * time.Millisecond}, {0.99, 9900 * time.Microsecond}, {0.999, 9990 * time.Microsecond}, {1, 10 * time.Millisecond}} {
+		got := a.percentile(tc.q)
+		if diff := got - tc.want; diff < -tc.want/32 || diff > tc.want/32 {
+			t.Errorf("percentile(%v) = %v, want %v ±3%%", tc.q, got, tc.want)
+		}
+	}
+	var sum int64
+	for _, bar := range a.bars(10) {
+		sum += bar.count
+	}
+	if sum != a.total {
+		t.Errorf("bars hold %d samples, want %d", sum, a.total)
+	}
+}
@@ -0,0 +1,409 @@
+// Command gopherpipe-bench load-tests a GopherPipe method end to end and
+// reports its latency distribution, throughput, errors and wire usage.
+//
+//	gopherpipe-bench -d '"alice"' -c 50 -conns 4 -duration 30s localhost:9200 ChatService/Login
+//	gopherpipe-bench -n 10000 -qps 2000 localhost:9200 ChatService/Login
+//
+// -c workers call the method in a loop, sharing -conns connections round
+// robin. The run stops after -n calls or once -duration has passed,
+// whichever comes first, and -qps paces the calls across all workers.
+// Arguments are JSON as for gpcurl and are decoded once, so the numbers
+// measure the RPC path rather than JSON. A call to a server-streaming
+// method lasts until its stream ends. Method types come from the server's
+// reflection service unless -schema names a file written by
+// `gopherpipe schema`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/dynamic"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// headers collects repeated -H flags.
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes gopherpipe-bench with args and returns the exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gopherpipe-bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("d", "", "JSON arguments, @file to read them from a file")
+	concurrency := fs.Int("c", 10, "number of concurrent workers")
+	conns := fs.Int("conns", 1, "number of connections shared by the workers")
+	total := fs.Int64("n", 0, "stop after this many calls (default no limit)")
+	duration := fs.Duration("duration", 0, "stop after this long (default 10s unless -n is set)")
+	qps := fs.Float64("qps", 0, "calls per second across all workers (default unpaced)")
+	timeout := fs.Duration("timeout", 20*time.Second, "deadline of each call, 0 for none")
+	schemaFile := fs.String("schema", "", "schema file to use instead of the reflection service")
+	var hdrs headers
+	fs.Var(&hdrs, "H", "call metadata as 'key: value' (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gopherpipe-bench [flags] addr Service/Method")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 || *concurrency < 1 || *conns < 1 || *total < 0 || *qps < 0 {
+		fs.Usage()
+		return 2
+	}
+	if *conns > *concurrency {
+		fmt.Fprintln(stderr, "gopherpipe-bench: -conns must not exceed -c")
+		return 2
+	}
+	if *total == 0 && *duration == 0 {
+		*duration = 10 * time.Second
+	}
+	ctx := context.Background()
+	for _, h := range hdrs {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			fmt.Fprintf(stderr, "gopherpipe-bench: bad header %q, want 'key: value'\n", h)
+			return 2
+		}
+		ctx = gopherpipe.AppendToOutgoingContext(ctx, strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	b := &bench{total: *total, timeout: *timeout}
+	if *qps > 0 {
+		b.interval = time.Duration(float64(time.Second) / *qps)
+	}
+	if err := b.setup(ctx, fs.Arg(0), fs.Arg(1), *conns, *schemaFile, *data); err != nil {
+		if st := gopherpipe.StatusOf(err); st.Code != gopherpipe.Unknown {
+			fmt.Fprintf(stderr, "ERROR:\n  Code: %s\n  Message: %s\n", st.Code, st.Message)
+		} else {
+			fmt.Fprintln(stderr, "gopherpipe-bench:", err)
+		}
+		return 1
+	}
+	defer b.close()
+	res := b.run(ctx, *concurrency, *duration)
+	res.print(stdout, b, *concurrency)
+	return 0
+}
+
+// bench is one load test of a method.
+type bench struct {
+	addr     string
+	method   *dynamic.Method
+	args     []interface{}
+	clients  []*gopherpipe.Client
+	wire     wireCounter
+	total    int64         // number of calls, 0 for no limit
+	interval time.Duration // time between paced calls, 0 for unpaced
+	timeout  time.Duration
+
+	start time.Time
+	next  int64 // number of calls claimed by workers; accessed atomically
+}
+
+// setup dials the connections, resolves the method and decodes its
+// arguments.
+func (b *bench) setup(ctx context.Context, addr, full string, conns int, schemaFile, data string) error {
+	service, method, err := gopherpipe.SplitMethod(full)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(data, "@") {
+		body, err := os.ReadFile(data[1:])
+		if err != nil {
+			return err
+		}
+		data = string(body)
+	}
+	b.addr = addr
+	for i := 0; i < conns; i++ {
+		c, err := gopherpipe.Dial(addr, gopherpipe.WithDialer(b.wire.dial))
+		if err != nil {
+			b.close()
+			return err
+		}
+		b.clients = append(b.clients, c)
+	}
+	var s *schema.Schema
+	if schemaFile != "" {
+		s, err = schema.ReadFile(schemaFile)
+	} else {
+		s, err = gopherpipe.DescribeServices(ctx, b.clients[0], service)
+	}
+	if err == nil {
+		b.method, err = dynamic.Resolve(s, nil, service, method)
+	}
+	if err == nil && b.method.RPCType != schema.Unary && b.method.RPCType != schema.ServerStream {
+		err = gopherpipe.Errorf(gopherpipe.Unimplemented, "gopherpipe-bench cannot call %s methods", b.method.RPCType)
+	}
+	if err == nil {
+		b.args, err = b.method.ArgsFromJSON([]byte(data))
+	}
+	if err != nil {
+		b.close()
+		return err
+	}
+	// Setup traffic is not part of the measurement.
+	b.wire.reset()
+	return nil
+}
+
+func (b *bench) close() {
+	for _, c := range b.clients {
+		c.Close()
+	}
+}
+
+// run calls the method from concurrency workers until the configured
+// number of calls or duration is reached and returns the merged results.
+func (b *bench) run(ctx context.Context, concurrency int, duration time.Duration) *result {
+	stop := make(chan struct{})
+	if duration > 0 {
+		t := time.AfterFunc(duration, func() { close(stop) })
+		defer t.Stop()
+	}
+	b.start = time.Now()
+	results := make([]*result, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		results[i] = newResult()
+		wg.Add(1)
+		go func(c *gopherpipe.Client, r *result) {
+			defer wg.Done()
+			b.work(ctx, stop, c, r)
+		}(b.clients[i%len(b.clients)], results[i])
+	}
+	wg.Wait()
+	res := newResult()
+	res.elapsed = time.Since(b.start)
+	for _, r := range results {
+		res.merge(r)
+	}
+	res.sent, res.received = b.wire.load()
+	return res
+}
+
+// work makes calls on c until the run is over. Calls in flight when the
+// duration ends run to completion and are counted.
+func (b *bench) work(ctx context.Context, stop <-chan struct{}, c *gopherpipe.Client, r *result) {
+	for {
+		n := atomic.AddInt64(&b.next, 1) - 1
+		if b.total > 0 && n >= b.total {
+			return
+		}
+		if b.interval > 0 {
+			t := time.NewTimer(time.Until(b.start.Add(time.Duration(n) * b.interval)))
+			select {
+			case <-t.C:
+			case <-stop:
+				t.Stop()
+				return
+			}
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		start := time.Now()
+		msgs, err := b.call(ctx, c)
+		r.record(time.Since(start), msgs, err)
+	}
+}
+
+// call makes one call and returns the number of stream messages received.
+func (b *bench) call(ctx context.Context, c *gopherpipe.Client) (int64, error) {
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	m := b.method
+	if m.RPCType == schema.Unary {
+		ptrs := make([]interface{}, len(m.Results))
+		for i, t := range m.Results {
+			ptrs[i] = reflect.New(t).Interface()
+		}
+		return 0, c.Call(ctx, m.Service, m.Name, b.args, ptrs...)
+	}
+	s, err := c.NewStream(ctx, m.Service, m.Name, b.args)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	var msgs int64
+	for {
+		if err := s.Recv(reflect.New(m.StreamOut).Interface()); err != nil {
+			if err == io.EOF {
+				return msgs, nil
+			}
+			return msgs, err
+		}
+		msgs++
+	}
+}
+
+// result holds the measurements of one worker, or of the whole run once
+// merged.
+type result struct {
+	hist     histogram
+	codes    map[gopherpipe.Code]int64
+	messages int64
+	elapsed  time.Duration
+	sent     int64
+	received int64
+}
+
+func newResult() *result {
+	return &result{codes: make(map[gopherpipe.Code]int64)}
+}
+
+func (r *result) record(d time.Duration, msgs int64, err error) {
+	r.hist.record(d)
+	r.codes[gopherpipe.StatusOf(err).Code]++
+	r.messages += msgs
+}
+
+func (r *result) merge(o *result) {
+	r.hist.merge(&o.hist)
+	for code, n := range o.codes {
+		r.codes[code] += n
+	}
+	r.messages += o.messages
+}
+
+// print writes the report.
+func (r *result) print(w io.Writer, b *bench, concurrency int) {
+	m := b.method
+	calls := r.hist.total
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "Target:       %s/%s (%s) at %s\n", m.Service, m.Name, m.RPCType, b.addr)
+	fmt.Fprintf(w, "Workers:      %d on %d connections\n", concurrency, len(b.clients))
+	fmt.Fprintf(w, "Elapsed:      %v\n", round(r.elapsed))
+	fmt.Fprintf(w, "Calls:        %d\n", calls)
+	fmt.Fprintf(w, "Throughput:   %.1f calls/s\n", float64(calls)/secs)
+	if m.RPCType == schema.ServerStream {
+		fmt.Fprintf(w, "Messages:     %d (%.1f/s)\n", r.messages, float64(r.messages)/secs)
+	}
+	if calls == 0 {
+		return
+	}
+
+	h := &r.hist
+	fmt.Fprintln(w, "\nLatency:")
+	fmt.Fprintf(w, "  min   %v\n  mean  %v\n", round(h.min), round(h.mean()))
+	for _, p := range []struct {
+		name string
+		q    float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}, {"p999", 0.999}} {
+		fmt.Fprintf(w, "  %-5s %v\n", p.name, round(h.percentile(p.q)))
+	}
+	fmt.Fprintf(w, "  max   %v\n", round(h.max))
+
+	fmt.Fprintln(w, "\nHistogram:")
+	bars := h.bars(10)
+	var most int64
+	for _, b := range bars {
+		if b.count > most {
+			most = b.count
+		}
+	}
+	for _, b := range bars {
+		fmt.Fprintf(w, "  %10v [%d]\t%s\n", round(b.low), b.count, strings.Repeat("∎", int(b.count*40/most)))
+	}
+
+	fmt.Fprintln(w, "\nStatus codes:")
+	codes := make([]gopherpipe.Code, 0, len(r.codes))
+	for code := range r.codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %-20s %d\n", code, r.codes[code])
+	}
+
+	fmt.Fprintln(w, "\nBytes on wire:")
+	fmt.Fprintf(w, "  sent      %s (%d B/call)\n", formatBytes(r.sent), r.sent/calls)
+	fmt.Fprintf(w, "  received  %s (%d B/call)\n", formatBytes(r.received), r.received/calls)
+}
+
+// round rounds d to three significant digits.
+func round(d time.Duration) time.Duration {
+	unit := time.Duration(1)
+	for d/unit >= 1000 {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
+
+// formatBytes renders n with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// wireCounter counts the bytes carried by the connections it dials,
+// TCP_LITE frame headers included.
+type wireCounter struct {
+	sent, received int64 // accessed atomically
+}
+
+func (wc *wireCounter) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, wc: wc}, nil
+}
+
+func (wc *wireCounter) load() (sent, received int64) {
+	return atomic.LoadInt64(&wc.sent), atomic.LoadInt64(&wc.received)
+}
+
+func (wc *wireCounter) reset() {
+	atomic.StoreInt64(&wc.sent, 0)
+	atomic.StoreInt64(&wc.received, 0)
+}
+
+// countingConn is a net.Conn that adds its traffic to a wireCounter.
+type countingConn struct {
+	net.Conn
+	wc *wireCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.wc.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.wc.sent, int64(n))
+	return n, err
+}
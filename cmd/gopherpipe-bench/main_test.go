@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+type target struct{}
+
+func (target) Echo(s string) (string, error) { return s, nil }
+
+// Flaky fails calls with odd arguments.
+func (target) Flaky(n int) error {
+	if n%2 == 1 {
+		return gopherpipe.Errorf(gopherpipe.ResourceExhausted, "odd")
+	}
+	return nil
+}
+
+func (target) Count(ctx context.Context, n int) (<-chan int, error) {
+	ch := make(chan int, n)
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	return ch, nil
+}
+
+// startTarget serves the target service with reflection on a local port.
+func startTarget(t *testing.T) string {
+	t.Helper()
+	srv := gopherpipe.NewServer("")
+	if err := srv.Register("Target", target{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReflection(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+func runBench(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// TestBench covers unary and streaming runs, pacing, the status code
+// breakdown and argument errors.
+func TestBench(t *testing.T) {
+	addr := startTarget(t)
+	cases := []struct {
+		args     []string
+		code     int
+		contains []string
+	}{
+		{[]string{"-n", "200", "-c", "8", "-conns", "3", "-d", `"hello"`, addr, "Target/Echo"}, 0,
+			[]string{"Workers:      8 on 3 connections", "Calls:        200\n", "p999", "OK                   200\n"}},
+		{[]string{"-n", "50", "-d", "1", addr, "Target/Flaky"}, 0,
+			[]string{"RESOURCE_EXHAUSTED   50\n"}},
+		{[]string{"-n", "10", "-d", "5", addr, "Target/Count"}, 0,
+			[]string{"(server_stream)", "Messages:     50 "}},
+		{[]string{"-duration", "100ms", "-d", `"x"`, addr, "Target/Echo"}, 0,
+			[]string{"Throughput:"}},
+		{[]string{"-n", "1", "-d", `"x"`, addr, "Target/Nope"}, 1, []string{"Code: NOT_FOUND"}},
+		{[]string{"-n", "1", "-d", `1`, addr, "Target/Echo"}, 1, []string{"Code: INVALID_ARGUMENT"}},
+		{[]string{"-c", "1", "-conns", "2", addr, "Target/Echo"}, 2, []string{"-conns must not exceed -c"}},
+	}
+	for _, tc := range cases {
+		code, out, errOut := runBench(t, tc.args...)
+		ok := code == tc.code
+		for _, s := range tc.contains {
+			ok = ok && strings.Contains(out+errOut, s)
+		}
+		if !ok {
+			t.Errorf("gopherpipe-bench %q = %d\nstdout:\n%s\nstderr:\n%s\nwant %d and %q", tc.args, code, out, errOut, tc.code, tc.contains)
+		}
+	}
+}
+
+// TestBenchPacingAndWire checks -qps spaces the calls out and the report
+// counts the bytes the calls put on the wire.
+func TestBenchPacingAndWire(t *testing.T) {
+	addr := startTarget(t)
+	start := time.Now()
+	code, out, errOut := runBench(t, "-n", "20", "-qps", "200", "-c", "4", "-d", `"`+strings.Repeat("x", 1000)+`"`, addr, "Target/Echo")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("20 calls at 200 qps took %v", elapsed)
+	}
+	m := regexp.MustCompile(`sent .* \((\d+) B/call\)`).FindStringSubmatch(out)
+	if m == nil || len(m[1]) < 4 {
+		t.Errorf("want over 1000 bytes sent per call:\n%s", out)
+	}
+}
@@ -0,0 +1,53 @@
+// Command gopherpipe-gateway serves a GopherPipe backend over HTTP/JSON.
+//
+//	gopherpipe-gateway -backend localhost:9200 -listen :8080
+//
+// POST /{service}/{method} with a JSON body calls the backend and answers
+// with JSON; server-streaming methods answer with NDJSON, or Server-Sent
+// Events when the client accepts text/event-stream. Method types come
+// from the backend's reflection service unless -schema names a file
+// written by `gopherpipe schema`. The Authorization header, and those
+// listed in -forward-headers, travel to the backend as call metadata.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/gateway"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "HTTP listen address")
+	backend := flag.String("backend", "localhost:9200", "GopherPipe backend address")
+	schemaFile := flag.String("schema", "", "schema file to use instead of the backend's reflection service")
+	forward := flag.String("forward-headers", "", "comma-separated request headers to forward as metadata, besides Authorization")
+	flag.Parse()
+
+	var opts []gateway.Option
+	if *forward != "" {
+		opts = append(opts, gateway.WithForwardedHeaders(strings.Split(*forward, ",")...))
+	}
+	if *schemaFile != "" {
+		s, err := schema.ReadFile(*schemaFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gopherpipe-gateway:", err)
+			os.Exit(1)
+		}
+		opts = append(opts, gateway.WithSchema(s))
+	}
+	c, err := gopherpipe.Dial(*backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gopherpipe-gateway:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+	fmt.Printf("gateway listening on %s, backend %s\n", *listen, *backend)
+	log.Fatal(http.ListenAndServe(*listen, gateway.New(c, opts...)))
+}
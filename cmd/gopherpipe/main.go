@@ -0,0 +1,106 @@
+// Command gopherpipe is the GopherPipe contract tool.
+//
+//	gopherpipe schema [-dir .] [-o file]
+//	gopherpipe compat old.json new.json
+//
+// schema writes the JSON schema of the //gopherpipe:service interfaces in
+// a package: every method in wire terms and the full type graph. compat
+// compares two schema files and lists the differences; it exits with
+// status 1 if any of them would break deployed peers, so it can gate
+// merges in CI.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+const usage = `usage:
+	gopherpipe schema [-dir dir] [-o file]
+	gopherpipe compat old.json new.json
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "schema":
+		err = runSchema(args, os.Stdout)
+	case "compat":
+		var breaking bool
+		breaking, err = runCompat(args, os.Stdout)
+		if err == nil && breaking {
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "gopherpipe: unknown command %q\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gopherpipe:", err)
+		os.Exit(2)
+	}
+}
+
+// runSchema implements the schema command.
+func runSchema(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory of the package declaring the service interfaces")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := schema.Load(*dir)
+	if err != nil {
+		return err
+	}
+	if len(s.Services) == 0 {
+		return fmt.Errorf("no interfaces marked //gopherpipe:service in %s", *dir)
+	}
+	if *out == "" {
+		return s.Write(stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runCompat implements the compat command and reports whether a breaking
+// change was found.
+func runCompat(args []string, stdout io.Writer) (bool, error) {
+	if len(args) != 2 {
+		return false, fmt.Errorf("compat takes two schema files\n%s", usage)
+	}
+	old, err := schema.ReadFile(args[0])
+	if err != nil {
+		return false, err
+	}
+	new, err := schema.ReadFile(args[1])
+	if err != nil {
+		return false, err
+	}
+	changes := schema.Compare(old, new)
+	for _, c := range changes {
+		fmt.Fprintln(stdout, c)
+	}
+	breaking := schema.HasBreaking(changes)
+	if breaking {
+		fmt.Fprintln(stdout, "incompatible: breaking changes found")
+	} else {
+		fmt.Fprintln(stdout, "compatible")
+	}
+	return breaking, nil
+}
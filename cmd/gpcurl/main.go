@@ -0,0 +1,249 @@
+// Command gpcurl makes ad-hoc calls to GopherPipe servers, like curl for
+// HTTP. Method and type information comes from the server's reflection
+// service, or from a schema file written by `gopherpipe schema`.
+//
+//	gpcurl [flags] addr list                    list services
+//	gpcurl [flags] addr list Service            list a service's methods
+//	gpcurl [flags] addr describe Service/Method show a method signature
+//	gpcurl [flags] addr describe TypeName       show a type definition
+//	gpcurl [flags] -d JSON addr Service/Method  invoke a method
+//
+// Arguments are JSON: the value itself for one argument, an array
+// otherwise; -d @file reads them from a file and -d @- from stdin. The
+// decoded response is printed as indented JSON, one document per message
+// for server streams. -H adds call metadata and -max-time sets the
+// deadline. A failed call prints its status and exits with status 1.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/dynamic"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// headers collects repeated -H flags.
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes gpcurl with args and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gpcurl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("d", "", "JSON arguments, @file or @- for stdin")
+	maxTime := fs.Duration("max-time", 0, "call deadline, e.g. 2s (default none)")
+	schemaFile := fs.String("schema", "", "schema file to use instead of the reflection service")
+	var hdrs headers
+	fs.Var(&hdrs, "H", "call metadata as 'key: value' (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: gpcurl [flags] addr (list [Service] | describe Name | Service/Method)")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	ctx := context.Background()
+	for _, h := range hdrs {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			fmt.Fprintf(stderr, "gpcurl: bad header %q, want 'key: value'\n", h)
+			return 2
+		}
+		ctx = gopherpipe.AppendToOutgoingContext(ctx, strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	if *maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxTime)
+		defer cancel()
+	}
+
+	c, err := gopherpipe.Dial(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, "gpcurl:", err)
+		return 1
+	}
+	defer c.Close()
+	cl := &cli{c: c, schemaFile: *schemaFile, stdout: stdout}
+
+	switch cmd, rest := fs.Arg(1), fs.Args()[2:]; {
+	case cmd == "list" && len(rest) <= 1:
+		err = cl.list(ctx, rest)
+	case cmd == "describe" && len(rest) == 1:
+		err = cl.describe(ctx, rest[0])
+	case strings.Contains(cmd, "/") && len(rest) == 0:
+		var body []byte
+		if body, err = readData(*data, stdin); err == nil {
+			err = cl.invoke(ctx, cmd, body)
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		if st := gopherpipe.StatusOf(err); st.Code != gopherpipe.Unknown {
+			fmt.Fprintf(stderr, "ERROR:\n  Code: %s\n  Message: %s\n", st.Code, st.Message)
+		} else {
+			fmt.Fprintln(stderr, "gpcurl:", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// readData resolves the -d flag.
+func readData(data string, stdin io.Reader) ([]byte, error) {
+	switch {
+	case data == "@-":
+		return io.ReadAll(stdin)
+	case strings.HasPrefix(data, "@"):
+		return os.ReadFile(data[1:])
+	}
+	return []byte(data), nil
+}
+
+// cli runs commands against one server.
+type cli struct {
+	c          *gopherpipe.Client
+	schemaFile string
+	stdout     io.Writer
+}
+
+// schema returns the schema of the named services, or of all of them.
+func (cl *cli) schema(ctx context.Context, names ...string) (*schema.Schema, error) {
+	if cl.schemaFile != "" {
+		return schema.ReadFile(cl.schemaFile)
+	}
+	return gopherpipe.DescribeServices(ctx, cl.c, names...)
+}
+
+func (cl *cli) list(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		var names []string
+		if cl.schemaFile != "" {
+			s, err := cl.schema(ctx)
+			if err != nil {
+				return err
+			}
+			for _, svc := range s.Services {
+				names = append(names, svc.Name)
+			}
+		} else {
+			var err error
+			if names, err = gopherpipe.ListServices(ctx, cl.c); err != nil {
+				return err
+			}
+		}
+		for _, n := range names {
+			fmt.Fprintln(cl.stdout, n)
+		}
+		return nil
+	}
+	s, err := cl.schema(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	svc := s.Service(args[0])
+	if svc == nil {
+		return gopherpipe.Errorf(gopherpipe.NotFound, "unknown service %q", args[0])
+	}
+	for _, m := range svc.Methods {
+		fmt.Fprintf(cl.stdout, "%s/%s\n", svc.Name, m.Name)
+	}
+	return nil
+}
+
+// describe prints a method signature or a type definition.
+func (cl *cli) describe(ctx context.Context, name string) error {
+	if service, method, err := gopherpipe.SplitMethod(name); err == nil {
+		s, err := cl.schema(ctx, service)
+		if err != nil {
+			return err
+		}
+		svc := s.Service(service)
+		if svc == nil || svc.Method(method) == nil {
+			return gopherpipe.Errorf(gopherpipe.NotFound, "unknown method %s", name)
+		}
+		m := svc.Method(method)
+		fmt.Fprintf(cl.stdout, "%s is a %s method:\n  %s\n", name, m.RPCType, m.Signature())
+		return nil
+	}
+	s, err := cl.schema(ctx)
+	if err != nil {
+		return err
+	}
+	var matches []string
+	for full := range s.Types {
+		if full == name || strings.HasSuffix(full, "."+name) || strings.HasSuffix(full, "/"+name) {
+			matches = append(matches, full)
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) == 0 {
+		return gopherpipe.Errorf(gopherpipe.NotFound, "unknown type %q", name)
+	}
+	for _, full := range matches {
+		fmt.Fprintf(cl.stdout, "type %s struct {\n", full)
+		for _, f := range s.Types[full].Fields {
+			fmt.Fprintf(cl.stdout, "\t%s %s\n", f.Name, f.Type)
+		}
+		fmt.Fprintln(cl.stdout, "}")
+	}
+	return nil
+}
+
+// invoke calls Service/Method and prints the response.
+func (cl *cli) invoke(ctx context.Context, full string, body []byte) error {
+	service, method, err := gopherpipe.SplitMethod(full)
+	if err != nil {
+		return err
+	}
+	s, err := cl.schema(ctx, service)
+	if err != nil {
+		return err
+	}
+	m, err := dynamic.Resolve(s, nil, service, method)
+	if err != nil {
+		return err
+	}
+	switch m.RPCType {
+	case schema.Unary:
+		out, err := m.Invoke(ctx, cl.c, body)
+		if err != nil {
+			return err
+		}
+		return cl.print(out)
+	case schema.ServerStream:
+		return m.InvokeStream(ctx, cl.c, body, cl.print)
+	}
+	return gopherpipe.Errorf(gopherpipe.Unimplemented, "gpcurl cannot call %s methods", m.RPCType)
+}
+
+// print writes a JSON document indented.
+func (cl *cli) print(doc []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, doc, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := cl.stdout.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+type Item struct {
+	Name  string
+	Price float64
+}
+
+type shop struct{}
+
+func (shop) Buy(ctx context.Context, item Item, qty int) (float64, error) {
+	if qty <= 0 {
+		return 0, gopherpipe.Errorf(gopherpipe.InvalidArgument, "qty must be positive")
+	}
+	return item.Price * float64(qty), nil
+}
+
+// Whoami echoes the caller's "user" metadata.
+func (shop) Whoami(ctx context.Context) (string, error) {
+	return gopherpipe.IncomingMetadata(ctx).Get("user"), nil
+}
+
+func (shop) Stall(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (shop) Catalog(ctx context.Context, n int) (<-chan Item, error) {
+	ch := make(chan Item, n)
+	for i := 0; i < n; i++ {
+		ch <- Item{Name: strings.Repeat("x", i+1), Price: float64(i)}
+	}
+	close(ch)
+	return ch, nil
+}
+
+// startShop serves the shop service with reflection on a local port.
+func startShop(t *testing.T) string {
+	t.Helper()
+	srv := gopherpipe.NewServer("")
+	if err := srv.Register("Shop", shop{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReflection(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+func gpcurl(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(""), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// TestGpcurl covers listing, describing, unary and streaming invocation,
+// metadata, deadlines and error reporting.
+func TestGpcurl(t *testing.T) {
+	addr := startShop(t)
+	cases := []struct {
+		args     []string
+		code     int
+		contains string
+	}{
+		{[]string{addr, "list"}, 0, "Shop\ngopherpipe.Reflection\n"},
+		{[]string{addr, "list", "Shop"}, 0, "Shop/Buy\nShop/Catalog\n"},
+		{[]string{addr, "describe", "Shop/Buy"}, 0, "Buy(github.com/anthony/gopher-pipe/cmd/gpcurl.Item, int) (float64, error)"},
+		{[]string{addr, "describe", "Item"}, 0, "\tPrice float64\n"},
+		{[]string{"-d", `[{"Name":"pen","Price":1.5}, 4]`, addr, "Shop/Buy"}, 0, "6\n"},
+		{[]string{"-d", "2", addr, "Shop/Catalog"}, 0, "{\n  \"Name\": \"xx\",\n  \"Price\": 1\n}\n"},
+		{[]string{"-H", "User: ada", addr, "Shop/Whoami"}, 0, "\"ada\"\n"},
+		{[]string{"-d", `[{"Name":"pen"}, 0]`, addr, "Shop/Buy"}, 1, "Code: INVALID_ARGUMENT"},
+		{[]string{"-max-time", "50ms", addr, "Shop/Stall"}, 1, "Code: DEADLINE_EXCEEDED"},
+		{[]string{addr, "Shop/Nope"}, 1, "Code: NOT_FOUND"},
+	}
+	for _, tc := range cases {
+		start := time.Now()
+		code, out, errOut := gpcurl(t, tc.args...)
+		if code != tc.code || !strings.Contains(out+errOut, tc.contains) {
+			t.Errorf("gpcurl %q = %d\nstdout:\n%s\nstderr:\n%s\nwant %d and %q", tc.args, code, out, errOut, tc.code, tc.contains)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("gpcurl %q took %v", tc.args, time.Since(start))
+		}
+	}
+}
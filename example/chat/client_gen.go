@@ -1,18 +1,21 @@
-package chat
+// Code generated by genstub. DO NOT EDIT.
 
-// Generated client helper used by the example chat CLI and tests. This file
-// is intentionally small and acts as a toy client stub produced by the
-// repository's code generator in the examples.
+package chat
 
 import (
+	"context"
+
 	"github.com/anthony/gopher-pipe/gopherpipe"
 )
 
-// ChatClient is a generated client stub (toy generator)
+// ChatClient is the generated GopherPipe client for ChatService.
 type ChatClient struct {
 	c *gopherpipe.Client
 }
 
+var _ ChatService = (*ChatClient)(nil)
+
+// NewChatClient dials addr and returns a ChatService client.
 func NewChatClient(addr string) (*ChatClient, error) {
 	c, err := gopherpipe.Dial(addr)
 	if err != nil {
@@ -21,16 +24,47 @@ func NewChatClient(addr string) (*ChatClient, error) {
 	return &ChatClient{c: c}, nil
 }
 
+// NewChatClientFrom returns a ChatService client that issues calls on c.
+func NewChatClientFrom(c *gopherpipe.Client) *ChatClient {
+	return &ChatClient{c: c}
+}
+
+// Close closes the underlying connection.
 func (cc *ChatClient) Close() error {
 	return cc.c.Close()
 }
 
+func init() {
+	// methods marked //gopherpipe:idempotent, which retry policies apply to
+	gopherpipe.RegisterIdempotent("ChatService", "Login")
+}
+
+// JoinRoom calls ChatService.JoinRoom.
+func (cc *ChatClient) JoinRoom(ctx context.Context, roomID string, incoming <-chan string) (<-chan Message, error) {
+	s, err := cc.c.NewStream(ctx, "ChatService", "JoinRoom", []interface{}{roomID, incoming})
+	if err != nil {
+		return nil, err
+	}
+	return gopherpipe.RecvChan[Message](ctx, s), nil
+}
+
+// Login calls ChatService.Login.
 func (cc *ChatClient) Login(user string) (bool, error) {
-	// Login calls the remote ChatService.Login method via the small
-	// gopherpipe client. The result is decoded into a bool.
-	var out bool
-	if err := cc.c.CallUnary("ChatService", "Login", user, &out); err != nil {
-		return false, err
+	var r0 bool
+	err := cc.c.Call(context.Background(), "ChatService", "Login", []interface{}{user}, &r0)
+	return r0, err
+}
+
+// Send calls ChatService.Send.
+func (cc *ChatClient) Send(ctx context.Context, room string, msg Message) error {
+	return cc.c.Call(ctx, "ChatService", "Send", []interface{}{room, msg})
+}
+
+// Watch calls ChatService.Watch.
+func (cc *ChatClient) Watch(ctx context.Context, room string) (<-chan Message, error) {
+	s, err := cc.c.NewStream(ctx, "ChatService", "Watch", []interface{}{room})
+	if err != nil {
+		return nil, err
 	}
-	return out, nil
+	return gopherpipe.RecvChan[Message](ctx, s), nil
 }
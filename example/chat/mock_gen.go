@@ -0,0 +1,74 @@
+// Code generated by genstub. DO NOT EDIT.
+
+package chat
+
+import (
+	"context"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/gptest"
+)
+
+// MockChatService is a programmable ChatService for tests. Each method records
+// its call and delegates to the matching Func field; methods without a
+// stub fail with Unimplemented.
+type MockChatService struct {
+	gptest.Recorder
+
+	JoinRoomFunc func(ctx context.Context, roomID string, incoming <-chan string) (<-chan Message, error)
+	LoginFunc    func(user string) (bool, error)
+	SendFunc     func(ctx context.Context, room string, msg Message) error
+	WatchFunc    func(ctx context.Context, room string) (<-chan Message, error)
+}
+
+var _ ChatService = (*MockChatService)(nil)
+
+// JoinRoom records the call and invokes JoinRoomFunc.
+func (m *MockChatService) JoinRoom(ctx context.Context, roomID string, incoming <-chan string) (<-chan Message, error) {
+	m.Record("JoinRoom", roomID, incoming)
+	if m.JoinRoomFunc == nil {
+		var r0 <-chan Message
+		return r0, gopherpipe.Errorf(gopherpipe.Unimplemented, "MockChatService.JoinRoom is not stubbed")
+	}
+	return m.JoinRoomFunc(ctx, roomID, incoming)
+}
+
+// Login records the call and invokes LoginFunc.
+func (m *MockChatService) Login(user string) (bool, error) {
+	m.Record("Login", user)
+	if m.LoginFunc == nil {
+		var r0 bool
+		return r0, gopherpipe.Errorf(gopherpipe.Unimplemented, "MockChatService.Login is not stubbed")
+	}
+	return m.LoginFunc(user)
+}
+
+// Send records the call and invokes SendFunc.
+func (m *MockChatService) Send(ctx context.Context, room string, msg Message) error {
+	m.Record("Send", room, msg)
+	if m.SendFunc == nil {
+		return gopherpipe.Errorf(gopherpipe.Unimplemented, "MockChatService.Send is not stubbed")
+	}
+	return m.SendFunc(ctx, room, msg)
+}
+
+// Watch records the call and invokes WatchFunc.
+func (m *MockChatService) Watch(ctx context.Context, room string) (<-chan Message, error) {
+	m.Record("Watch", room)
+	if m.WatchFunc == nil {
+		var r0 <-chan Message
+		return r0, gopherpipe.Errorf(gopherpipe.Unimplemented, "MockChatService.Watch is not stubbed")
+	}
+	return m.WatchFunc(ctx, room)
+}
+
+// NewChatLoopbackClient returns a ChatService client calling impl over an in-memory
+// connection. Every call goes through the full codec path, so
+// serialization bugs surface in unit tests.
+func NewChatLoopbackClient(impl ChatService) (*ChatClient, error) {
+	srv := gopherpipe.NewServer("")
+	if err := RegisterChatServiceServer(srv, impl); err != nil {
+		return nil, err
+	}
+	return NewChatClientFrom(gopherpipe.DialLoopback(srv)), nil
+}
@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/gptest"
+)
+
+// TestMockThroughLoopback drives the generated mock through the loopback
+// client, so every argument and result crosses the codec.
+func TestMockThroughLoopback(t *testing.T) {
+	mock := &MockChatService{
+		LoginFunc: func(user string) (bool, error) { return user == "alice", nil },
+		WatchFunc: func(ctx context.Context, room string) (<-chan Message, error) {
+			return gptest.Stream(Message{Sender: "bob", Text: "hi " + room}), nil
+		},
+	}
+	mock.Expect("Login", 2)
+	mock.Expect("Watch", 1)
+	mock.Expect("Send", 1)
+
+	c, err := NewChatLoopbackClient(mock)
+	if err != nil {
+		t.Fatalf("loopback: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if ok, err := c.Login("alice"); err != nil || !ok {
+		t.Fatalf("Login(alice) = %v, %v", ok, err)
+	}
+	if ok, _ := c.Login("mallory"); ok {
+		t.Fatalf("Login(mallory) succeeded")
+	}
+	ch, err := c.Watch(ctx, "lobby")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if got := gptest.Collect(ch); len(got) != 1 || got[0].Text != "hi lobby" {
+		t.Fatalf("Watch messages = %+v", got)
+	}
+	err = c.Send(ctx, "lobby", Message{Sender: "alice", Text: "yo"})
+	if gopherpipe.CodeOf(err) != gopherpipe.Unimplemented {
+		t.Fatalf("unstubbed Send: got %v, want Unimplemented", err)
+	}
+
+	mock.Verify(t)
+	sent := mock.CallsTo("Send")
+	if len(sent) != 1 || sent[0].Args[1].(Message).Text != "yo" {
+		t.Fatalf("recorded Send calls = %+v", sent)
+	}
+}
@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// TestReflectionMatchesSource verifies the schema served by the reflection
+// service for the generated registration matches the one exported from
+// the contract source.
+func TestReflectionMatchesSource(t *testing.T) {
+	srv := gopherpipe.NewServer("")
+	if err := RegisterChatServiceServer(srv, &MockChatService{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReflection(); err != nil {
+		t.Fatal(err)
+	}
+	c := gopherpipe.DialLoopback(srv)
+	defer c.Close()
+
+	served, err := gopherpipe.DescribeServices(context.Background(), c, "ChatService")
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	source, err := schema.Load(".")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if changes := schema.Compare(source, served); len(changes) != 0 {
+		t.Fatalf("served schema differs from source: %v", changes)
+	}
+}
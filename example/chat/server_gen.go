@@ -0,0 +1,100 @@
+// Code generated by genstub. DO NOT EDIT.
+
+package chat
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+// chatServiceHandlers adapts a ChatService to gopherpipe method handlers.
+type chatServiceHandlers struct {
+	impl ChatService
+}
+
+func (h chatServiceHandlers) JoinRoom(ctx context.Context, call *gopherpipe.ServerCall) error {
+	var a0 string
+	if err := call.DecodeArgs(&a0); err != nil {
+		return err
+	}
+	a1 := gopherpipe.RecvStream[string](ctx, call)
+	r0, err := h.impl.JoinRoom(ctx, a0, a1)
+	if err != nil {
+		return err
+	}
+	return gopherpipe.SendStream[Message](ctx, call, r0)
+}
+
+func (h chatServiceHandlers) Login(ctx context.Context, call *gopherpipe.ServerCall) error {
+	var a0 string
+	if err := call.DecodeArgs(&a0); err != nil {
+		return err
+	}
+	r0, err := h.impl.Login(a0)
+	if err != nil {
+		return err
+	}
+	return call.SendResults(r0)
+}
+
+func (h chatServiceHandlers) Send(ctx context.Context, call *gopherpipe.ServerCall) error {
+	var a0 string
+	var a1 Message
+	if err := call.DecodeArgs(&a0, &a1); err != nil {
+		return err
+	}
+	return h.impl.Send(ctx, a0, a1)
+}
+
+func (h chatServiceHandlers) Watch(ctx context.Context, call *gopherpipe.ServerCall) error {
+	var a0 string
+	if err := call.DecodeArgs(&a0); err != nil {
+		return err
+	}
+	r0, err := h.impl.Watch(ctx, a0)
+	if err != nil {
+		return err
+	}
+	return gopherpipe.SendStream[Message](ctx, call, r0)
+}
+
+// RegisterChatServiceServer registers impl on srv using generated
+// handlers, so calls are dispatched without reflection.
+func RegisterChatServiceServer(srv *gopherpipe.Server, impl ChatService) error {
+	h := chatServiceHandlers{impl: impl}
+	return srv.RegisterServiceDesc(gopherpipe.ServiceDesc{
+		Name: "ChatService",
+		Methods: []gopherpipe.MethodDesc{
+			{
+				Name:        "JoinRoom",
+				RPCType:     gopherpipe.BiDi,
+				Handler:     h.JoinRoom,
+				ArgTypes:    []reflect.Type{gopherpipe.TypeOf[string]()},
+				ResultTypes: []reflect.Type{gopherpipe.TypeOf[Message]()},
+				InType:      gopherpipe.TypeOf[string](),
+			},
+			{
+				Name:        "Login",
+				RPCType:     gopherpipe.Unary,
+				Handler:     h.Login,
+				ArgTypes:    []reflect.Type{gopherpipe.TypeOf[string]()},
+				ResultTypes: []reflect.Type{gopherpipe.TypeOf[bool]()},
+			},
+			{
+				Name:     "Send",
+				RPCType:  gopherpipe.Unary,
+				Handler:  h.Send,
+				ArgTypes: []reflect.Type{gopherpipe.TypeOf[string](), gopherpipe.TypeOf[Message]()},
+			},
+			{
+				Name:        "Watch",
+				RPCType:     gopherpipe.ServerStream,
+				Handler:     h.Watch,
+				ArgTypes:    []reflect.Type{gopherpipe.TypeOf[string]()},
+				ResultTypes: []reflect.Type{gopherpipe.TypeOf[Message]()},
+			},
+		},
+	})
+}
@@ -2,8 +2,27 @@ package chat
 
 // Package chat contains a tiny example interface used by the codegen
 // demonstration. The interface is intentionally minimal for test and demo
-// purposes.
-// ChatService is a small example interface for the codegen demo.
+// purposes; client_gen.go is produced from it by cmd/genstub.
+
+//go:generate go run github.com/anthony/gopher-pipe/cmd/genstub -mock
+
+import "context"
+
+// Message is a single chat line delivered to the members of a room.
+type Message struct {
+	Sender string
+	Text   string
+}
+
+// ChatService is a small example interface for the codegen demo. It covers
+// each call shape: unary, server streaming and bidirectional streaming.
+// Login is idempotent, so clients may retry it.
+//
+//gopherpipe:service
 type ChatService interface {
+	//gopherpipe:idempotent
 	Login(user string) (bool, error)
+	Send(ctx context.Context, room string, msg Message) error
+	Watch(ctx context.Context, room string) (<-chan Message, error)
+	JoinRoom(ctx context.Context, roomID string, incoming <-chan string) (<-chan Message, error)
 }
@@ -1,12 +1,13 @@
 package main
 
-// Example chat client demonstrating use of the generated ChatClient stub
-// and a simple login flow against the example chat server.
+// Example chat client demonstrating use of the generated ChatClient stub:
+// a simple login followed by a bidirectional JoinRoom stream against the
+// example chat server.
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/anthony/gopher-pipe/example/chat"
 )
@@ -22,6 +23,18 @@ func main() {
 		log.Fatalln(err)
 	}
 	fmt.Println("Login ok:", ok)
-	// Keep brief wait for demo
-	time.Sleep(time.Millisecond * 100)
+
+	input := make(chan string)
+	output, err := cli.JoinRoom(context.Background(), "General", input)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	go func() {
+		input <- "Hello Gophers!"
+		input <- "Is this thing on?"
+		close(input)
+	}()
+	for msg := range output {
+		fmt.Printf("[%s]: %s\n", msg.Sender, msg.Text)
+	}
 }
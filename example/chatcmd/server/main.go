@@ -1,27 +1,90 @@
 package main
 
 // Simple example chat server using the gopherpipe.Server prototype. This
-// binary demonstrates how server registration and reflection-based
-// invocation can be used with a concrete implementation.
+// binary demonstrates how a concrete implementation is registered through
+// the Register<Service>Server function genstub generates from the contract.
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 
+	"github.com/anthony/gopher-pipe/example/chat"
 	"github.com/anthony/gopher-pipe/gopherpipe"
 )
 
 // impl of chat.ChatService
-type chatImpl struct{}
+type chatImpl struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan chat.Message]struct{}
+}
 
 func (c *chatImpl) Login(user string) (bool, error) {
 	log.Printf("Login called for: %s", user)
 	return true, nil
 }
 
+// Send delivers msg to everyone watching room. Slow watchers miss
+// messages rather than blocking the sender.
+func (c *chatImpl) Send(ctx context.Context, room string, msg chat.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.watchers[room] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+// Watch streams messages sent to room until the caller goes away.
+func (c *chatImpl) Watch(ctx context.Context, room string) (<-chan chat.Message, error) {
+	ch := make(chan chat.Message, 16)
+	c.mu.Lock()
+	if c.watchers[room] == nil {
+		c.watchers[room] = make(map[chan chat.Message]struct{})
+	}
+	c.watchers[room][ch] = struct{}{}
+	c.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.watchers[room], ch)
+		c.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// JoinRoom echoes every line the caller sends back as a system message.
+func (c *chatImpl) JoinRoom(ctx context.Context, roomID string, incoming <-chan string) (<-chan chat.Message, error) {
+	outgoing := make(chan chat.Message)
+	go func() {
+		defer close(outgoing)
+		for text := range incoming {
+			select {
+			case outgoing <- chat.Message{Sender: "System", Text: "Echo: " + text}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return outgoing, nil
+}
+
 func main() {
 	srv := gopherpipe.NewServer(":9200")
-	srv.Register("ChatService", &chatImpl{})
+	impl := &chatImpl{watchers: make(map[string]map[chan chat.Message]struct{})}
+	if err := chat.RegisterChatServiceServer(srv, impl); err != nil {
+		log.Fatalln(err)
+	}
+	// reflection lets gopherpipe-gateway and other generic clients find
+	// the ChatService types at run time
+	if err := srv.EnableReflection(); err != nil {
+		log.Fatalln(err)
+	}
 	fmt.Println("Chat service listening :9200")
 	if err := srv.Serve(); err != nil {
 		panic(err)
@@ -0,0 +1,261 @@
+package gopherpipe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthInfo describes a call to PerCallCredentials, which attach
+// credentials to it, and to an Authenticator, which checks them.
+type AuthInfo struct {
+	Service string
+	Method  string
+	// Metadata is the call's metadata: on the client the outgoing
+	// metadata before credentials are added, on the server everything the
+	// caller sent.
+	Metadata Metadata
+	// Body is the encoded argument tuple, for credentials that sign the
+	// request.
+	Body []byte
+}
+
+// Principal is the authenticated identity of a caller.
+type Principal struct {
+	// Name identifies the caller: a user or service name, a key ID or a
+	// certificate identity.
+	Name string
+	// Scheme is the way the caller authenticated, e.g. "bearer", "hmac"
+	// or "mtls".
+	Scheme string
+	// Roles are the groups the caller belongs to, for authorization
+	// policies; authenticators that know them fill them in.
+	Roles []string
+}
+
+// PerCallCredentials returns the metadata that authenticates a call. It
+// runs for every call a client starts; see WithPerCallCredentials.
+type PerCallCredentials func(ctx context.Context, info *AuthInfo) (Metadata, error)
+
+// Authenticator identifies the caller of a call, before its handler runs,
+// from the credentials in its metadata or the peer in ctx (see
+// PeerFromContext). Errors that are a *Status are returned to the caller
+// as they are, so an authenticator can report Unavailable when its
+// backend is down; any other error fails the call with Unauthenticated.
+type Authenticator func(ctx context.Context, info *AuthInfo) (*Principal, error)
+
+// Authorizer decides whether a call may run, after authentication and
+// before its handler. p is nil on servers without an Authenticator. It
+// returns nil to allow the call; errors that are a *Status are returned
+// to the caller as they are and any other error denies the call with
+// PermissionDenied. See the authz package for file-based policies.
+type Authorizer func(ctx context.Context, info *AuthInfo, p *Principal) error
+
+// ErrNoCredentials is returned by the authenticators of this package when
+// a call carries no credentials of their scheme, so AnyAuthenticator can
+// try the next one.
+var ErrNoCredentials = Errorf(Unauthenticated, "no credentials")
+
+// WithAuthenticator makes the server authenticate every call with a and
+// reject those it fails with Unauthenticated. Handlers read the principal
+// with PrincipalFromContext.
+func WithAuthenticator(a Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticator = a
+	}
+}
+
+// WithPerCallCredentials attaches the metadata returned by creds to every
+// call of the client. Bearer tokens and signatures travel in the clear
+// unless the connection uses TLS (see WithClientTLSConfig).
+func WithPerCallCredentials(creds PerCallCredentials) DialOption {
+	return func(dc *dialConfig) {
+		dc.creds = creds
+	}
+}
+
+// WithAuthorizer makes the server check every call with a before running
+// its handler.
+func WithAuthorizer(a Authorizer) ServerOption {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the authenticated caller of the call
+// running with ctx. It is only set on servers with an Authenticator.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// authenticate runs the server's Authenticator for call and returns the
+// call context carrying the principal.
+func (s *Server) authenticate(ctx context.Context, call *ServerCall) (context.Context, error) {
+	if s.authenticator == nil {
+		return ctx, nil
+	}
+	env := call.env
+	p, err := s.authenticator(ctx, &AuthInfo{Service: env.ServiceName, Method: env.MethodName, Metadata: env.Metadata, Body: env.Body})
+	if err != nil {
+		var st *Status
+		if errors.As(err, &st) {
+			return ctx, st
+		}
+		return ctx, Errorf(Unauthenticated, "%v", err)
+	}
+	if p == nil {
+		return ctx, Errorf(Unauthenticated, "no principal")
+	}
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	call.ctx = ctx
+	return ctx, nil
+}
+
+// authorize runs the server's Authorizer for a call authenticated with
+// ctx.
+func (s *Server) authorize(ctx context.Context, call *ServerCall) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	env := call.env
+	p, _ := PrincipalFromContext(ctx)
+	err := s.authorizer(ctx, &AuthInfo{Service: env.ServiceName, Method: env.MethodName, Metadata: env.Metadata, Body: env.Body}, p)
+	if err == nil {
+		return nil
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st
+	}
+	return Errorf(PermissionDenied, "%v", err)
+}
+
+// AnyAuthenticator tries each authenticator in turn and accepts the call
+// as soon as one does. Authenticators that find no credentials of their
+// scheme (ErrNoCredentials) are skipped; any other failure rejects the
+// call.
+func AnyAuthenticator(auths ...Authenticator) Authenticator {
+	return func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		for _, a := range auths {
+			p, err := a(ctx, info)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return p, err
+		}
+		return nil, ErrNoCredentials
+	}
+}
+
+// authorizationKey is the metadata key of bearer tokens.
+const authorizationKey = "authorization"
+
+// BearerToken sends token as an "authorization: Bearer <token>" entry.
+func BearerToken(token string) PerCallCredentials {
+	md := Pairs(authorizationKey, "Bearer "+token)
+	return func(context.Context, *AuthInfo) (Metadata, error) {
+		return md, nil
+	}
+}
+
+// BearerAuthenticator accepts calls carrying a bearer token that verify
+// maps to a principal.
+func BearerAuthenticator(verify func(ctx context.Context, token string) (*Principal, error)) Authenticator {
+	return func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		v := info.Metadata.Get(authorizationKey)
+		if len(v) < 7 || !strings.EqualFold(v[:7], "bearer ") {
+			return nil, ErrNoCredentials
+		}
+		p, err := verify(ctx, v[7:])
+		if p != nil && p.Scheme == "" {
+			p.Scheme = "bearer"
+		}
+		return p, err
+	}
+}
+
+// Metadata keys of HMAC-signed calls.
+const (
+	hmacKeyIDKey     = "gp-hmac-key-id"
+	hmacTimestampKey = "gp-hmac-timestamp"
+	hmacSignatureKey = "gp-hmac-signature"
+)
+
+// HMACCredentials signs every call with key, identified by keyID. The
+// HMAC-SHA256 signature covers the service and method names, a timestamp
+// and the encoded arguments, so a captured call cannot be altered or
+// replayed to another method, and only within the server's clock skew.
+func HMACCredentials(keyID string, key []byte) PerCallCredentials {
+	return func(_ context.Context, info *AuthInfo) (Metadata, error) {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		sig := hmacSign(key, info, ts)
+		return Pairs(hmacKeyIDKey, keyID, hmacTimestampKey, ts, hmacSignatureKey, sig), nil
+	}
+}
+
+// HMACAuthenticator accepts calls signed by HMACCredentials with a key
+// known to keys and a timestamp less than maxSkew away from the server's
+// clock. The principal is named after the key ID.
+func HMACAuthenticator(keys func(keyID string) ([]byte, bool), maxSkew time.Duration) Authenticator {
+	return func(_ context.Context, info *AuthInfo) (*Principal, error) {
+		md := info.Metadata
+		keyID, ts, sig := md.Get(hmacKeyIDKey), md.Get(hmacTimestampKey), md.Get(hmacSignatureKey)
+		if keyID == "" && sig == "" {
+			return nil, ErrNoCredentials
+		}
+		key, ok := keys(keyID)
+		if !ok {
+			return nil, Errorf(Unauthenticated, "unknown key %q", keyID)
+		}
+		secs, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, Errorf(Unauthenticated, "bad signature timestamp %q", ts)
+		}
+		if skew := time.Since(time.Unix(secs, 0)); skew > maxSkew || skew < -maxSkew {
+			return nil, Errorf(Unauthenticated, "signature timestamp outside the allowed clock skew")
+		}
+		if !hmac.Equal([]byte(sig), []byte(hmacSign(key, info, ts))) {
+			return nil, Errorf(Unauthenticated, "bad signature")
+		}
+		return &Principal{Name: keyID, Scheme: "hmac"}, nil
+	}
+}
+
+// hmacSign returns the base64 signature of a call at timestamp ts.
+func hmacSign(key []byte, info *AuthInfo, ts string) string {
+	body := sha256.Sum256(info.Body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(info.Service + "/" + info.Method + "\n" + ts + "\n" + hex.EncodeToString(body[:])))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MTLSAuthenticator accepts calls on connections whose client certificate
+// was verified by a mutual TLS server (see WithTLSConfig). The principal
+// is named after the certificate's first URI SAN, such as a SPIFFE ID,
+// or else its subject common name.
+func MTLSAuthenticator() Authenticator {
+	return func(ctx context.Context, _ *AuthInfo) (*Principal, error) {
+		p, ok := PeerFromContext(ctx)
+		if !ok || p.TLS == nil || len(p.TLS.VerifiedChains) == 0 {
+			return nil, ErrNoCredentials
+		}
+		cert := p.Certificate()
+		name := cert.Subject.CommonName
+		if len(cert.URIs) > 0 {
+			name = cert.URIs[0].String()
+		}
+		if name == "" {
+			return nil, Errorf(Unauthenticated, "client certificate has no identity")
+		}
+		return &Principal{Name: name, Scheme: "mtls"}, nil
+	}
+}
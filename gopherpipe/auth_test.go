@@ -0,0 +1,165 @@
+package gopherpipe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type me struct{ calls int }
+
+// Me reports the caller's principal.
+func (m *me) Me(ctx context.Context, note string) (string, string, error) {
+	m.calls++
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return "", "", Errorf(Internal, "no principal")
+	}
+	return p.Name, p.Scheme, nil
+}
+
+// serveAuth serves a me service on srv and returns its address.
+func serveAuth(t *testing.T, srv *Server) (string, *me) {
+	t.Helper()
+	impl := &me{}
+	if err := srv.Register("Me", impl); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.ServeListener(ln) }()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), impl
+}
+
+// callMe dials addr with opts and calls Me, returning the principal.
+func callMe(t *testing.T, addr string, opts ...DialOption) (string, string, error) {
+	t.Helper()
+	c, err := Dial(addr, opts...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	var name, scheme string
+	err = c.Call(context.Background(), "Me", "Me", []interface{}{"hello"}, &name, &scheme)
+	return name, scheme, err
+}
+
+// TestAuthenticators covers bearer and HMAC credentials, their failures
+// and that rejected calls never reach the handler.
+func TestAuthenticators(t *testing.T) {
+	keys := map[string][]byte{"svc-a": []byte("secret-a")}
+	srv := NewServer("", WithAuthenticator(AnyAuthenticator(
+		BearerAuthenticator(func(ctx context.Context, token string) (*Principal, error) {
+			if token != "tok-1" {
+				return nil, errors.New("invalid token")
+			}
+			return &Principal{Name: "ada"}, nil
+		}),
+		HMACAuthenticator(func(id string) ([]byte, bool) {
+			k, ok := keys[id]
+			return k, ok
+		}, time.Minute),
+	)))
+	addr, impl := serveAuth(t, srv)
+
+	// stale signs the call as HMACCredentials would, an hour ago.
+	stale := func(ctx context.Context, info *AuthInfo) (Metadata, error) {
+		ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		return Pairs(hmacKeyIDKey, "svc-a", hmacTimestampKey, ts, hmacSignatureKey, hmacSign(keys["svc-a"], info, ts)), nil
+	}
+	// tampered signs other arguments than the ones sent.
+	tampered := func(ctx context.Context, info *AuthInfo) (Metadata, error) {
+		other := *info
+		other.Body = append([]byte(nil), info.Body...)
+		other.Body[len(other.Body)-1] ^= 1
+		return HMACCredentials("svc-a", keys["svc-a"])(ctx, &other)
+	}
+	cases := []struct {
+		name         string
+		creds        PerCallCredentials
+		code         Code
+		user, scheme string
+	}{
+		{"bearer", BearerToken("tok-1"), OK, "ada", "bearer"},
+		{"hmac", HMACCredentials("svc-a", keys["svc-a"]), OK, "svc-a", "hmac"},
+		{"none", nil, Unauthenticated, "", ""},
+		{"bad token", BearerToken("tok-2"), Unauthenticated, "", ""},
+		{"unknown key", HMACCredentials("svc-b", keys["svc-a"]), Unauthenticated, "", ""},
+		{"wrong key", HMACCredentials("svc-a", []byte("guess")), Unauthenticated, "", ""},
+		{"stale", stale, Unauthenticated, "", ""},
+		{"tampered", tampered, Unauthenticated, "", ""},
+	}
+	for _, tc := range cases {
+		var opts []DialOption
+		if tc.creds != nil {
+			opts = append(opts, WithPerCallCredentials(tc.creds))
+		}
+		user, scheme, err := callMe(t, addr, opts...)
+		if CodeOf(err) != tc.code || user != tc.user || scheme != tc.scheme {
+			t.Errorf("%s: got %q %q %v, want %v %q %q", tc.name, user, scheme, err, tc.code, tc.user, tc.scheme)
+		}
+	}
+	if impl.calls != 2 {
+		t.Errorf("handler ran %d times, want 2", impl.calls)
+	}
+}
+
+// TestAuthenticatorStatus verifies a *Status from an authenticator is
+// passed through while other errors become Unauthenticated.
+func TestAuthenticatorStatus(t *testing.T) {
+	srv := NewServer("", WithAuthenticator(func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		return nil, Errorf(Unavailable, "token service down")
+	}))
+	addr, _ := serveAuth(t, srv)
+	if _, _, err := callMe(t, addr); CodeOf(err) != Unavailable {
+		t.Fatalf("err = %v, want Unavailable", err)
+	}
+}
+
+// TestUnauthenticatedLookup verifies unauthenticated callers get the same
+// error for unknown services and methods as for real ones.
+func TestUnauthenticatedLookup(t *testing.T) {
+	srv := NewServer("", WithAuthenticator(BearerAuthenticator(func(ctx context.Context, token string) (*Principal, error) {
+		return &Principal{Name: token}, nil
+	})))
+	addr, _ := serveAuth(t, srv)
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, target := range [][2]string{{"Me", "Me"}, {"Me", "Nope"}, {"Nope", "Me"}} {
+		var name, scheme string
+		err := c.Call(context.Background(), target[0], target[1], []interface{}{"hello"}, &name, &scheme)
+		if CodeOf(err) != Unauthenticated {
+			t.Errorf("%s/%s: err = %v, want Unauthenticated", target[0], target[1], err)
+		}
+	}
+}
+
+// TestMTLSAuthenticator verifies the principal of a mutual TLS caller is
+// its certificate identity.
+func TestMTLSAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, _, _ := ca.issue(t, "server")
+	clientCert, _, _ := ca.issue(t, "billing")
+	srv := NewServer("",
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}, ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: ca.pool}),
+		WithAuthenticator(MTLSAuthenticator()))
+	addr, _ := serveAuth(t, srv)
+
+	user, scheme, err := callMe(t, addr, WithClientTLSConfig(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}}))
+	if err != nil || user != "billing" || scheme != "mtls" {
+		t.Fatalf("got %q %q %v", user, scheme, err)
+	}
+	if _, _, err := callMe(t, addr, WithClientTLSConfig(&tls.Config{RootCAs: ca.pool})); CodeOf(err) != Unauthenticated {
+		t.Fatalf("call without client certificate: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package authz enforces per-method authorization policies on a
+// gopherpipe.Server. A policy is a JSON list of rules granting principals
+// or roles access to methods; everything not granted is denied:
+//
+//	{
+//	  "rules": [
+//	    {"methods": ["ChatService/*"], "roles": ["chat-user"]},
+//	    {"methods": ["ChatService/Ban", "ChatService/Kick"], "principals": ["spiffe://example.org/moderator"]},
+//	    {"methods": ["gopherpipe.Health/*"], "unauthenticated": true}
+//	  ]
+//	}
+//
+// Methods are written "Service/Method"; "Service/*" covers every method of
+// a service and "*" every method. The principal "*" stands for any
+// authenticated caller and "unauthenticated" opens the methods to
+// everyone. Principals come from the server's Authenticator.
+//
+// An Enforcer serves a policy file to gopherpipe.WithAuthorizer, reloads
+// it when the file changes and audit-logs every denied call.
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+// Policy is a set of rules granting access to methods.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants the listed principals and roles access to Methods.
+type Rule struct {
+	Methods         []string `json:"methods"`
+	Principals      []string `json:"principals,omitempty"`
+	Roles           []string `json:"roles,omitempty"`
+	Unauthenticated bool     `json:"unauthenticated,omitempty"`
+}
+
+// Parse decodes and validates a JSON policy. Unknown fields are
+// rejected, so a misspelt key cannot silently widen or narrow access.
+func Parse(data []byte) (*Policy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("authz: decode policy: %v", err)
+	}
+	for i, r := range p.Rules {
+		if len(r.Methods) == 0 {
+			return nil, fmt.Errorf("authz: rule %d: no methods", i)
+		}
+		for _, m := range r.Methods {
+			if m == "*" {
+				continue
+			}
+			service, method, ok := strings.Cut(m, "/")
+			if !ok || service == "" || method == "" || strings.Contains(service, "*") || (method != "*" && strings.Contains(method, "*")) {
+				return nil, fmt.Errorf("authz: rule %d: bad method %q, want Service/Method, Service/* or *", i, m)
+			}
+		}
+		if len(r.Principals) == 0 && len(r.Roles) == 0 && !r.Unauthenticated {
+			return nil, fmt.Errorf("authz: rule %d: grants nobody", i)
+		}
+	}
+	return &p, nil
+}
+
+// ReadFile reads and validates the policy in file.
+func ReadFile(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return p, nil
+}
+
+// Allows reports whether p, which is nil for unauthenticated callers, may
+// call service/method.
+func (pol *Policy) Allows(service, method string, p *gopherpipe.Principal) bool {
+	for _, r := range pol.Rules {
+		if r.matchesMethod(service, method) && r.grants(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMethod(service, method string) bool {
+	for _, m := range r.Methods {
+		if m == "*" || m == service+"/*" || m == service+"/"+method {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) grants(p *gopherpipe.Principal) bool {
+	if r.Unauthenticated {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, name := range r.Principals {
+		if name == "*" || name == p.Name {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		for _, have := range p.Roles {
+			if role == have {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Denial is the audit record of a denied call.
+type Denial struct {
+	Time    time.Time
+	Service string
+	Method  string
+	// Principal is the authenticated caller, nil if unauthenticated.
+	Principal *gopherpipe.Principal
+	// Peer is the remote address of the caller's connection.
+	Peer net.Addr
+}
+
+func (d Denial) String() string {
+	who := "unauthenticated"
+	if p := d.Principal; p != nil {
+		who = fmt.Sprintf("principal=%q scheme=%s roles=%q", p.Name, p.Scheme, p.Roles)
+	}
+	return fmt.Sprintf("authz: DENY %s/%s %s peer=%v", d.Service, d.Method, who, d.Peer)
+}
+
+// Enforcer authorizes calls against a policy file. Pass its Authorize
+// method to gopherpipe.WithAuthorizer.
+type Enforcer struct {
+	file string
+	// CheckInterval is the minimum time between two checks of the policy
+	// file's modification time; it defaults to one second.
+	CheckInterval time.Duration
+	// Audit receives every denied call. It defaults to logging the denial
+	// with the standard logger.
+	Audit func(Denial)
+
+	mu        sync.Mutex
+	policy    *Policy
+	mod       time.Time
+	lastCheck time.Time
+}
+
+// NewEnforcer loads the policy in file, failing if it is invalid.
+func NewEnforcer(file string) (*Enforcer, error) {
+	e := &Enforcer{file: file, CheckInterval: time.Second, Audit: func(d Denial) { log.Print(d) }}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the policy file again. On error the previous policy stays
+// in force.
+func (e *Enforcer) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load()
+}
+
+func (e *Enforcer) load() error {
+	fi, err := os.Stat(e.file)
+	if err != nil {
+		return err
+	}
+	p, err := ReadFile(e.file)
+	if err != nil {
+		return err
+	}
+	e.policy, e.mod = p, fi.ModTime()
+	return nil
+}
+
+// current returns the policy, reloading it first if the file changed
+// since it was loaded.
+func (e *Enforcer) current() *Policy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now := time.Now(); now.Sub(e.lastCheck) >= e.CheckInterval {
+		e.lastCheck = now
+		if fi, err := os.Stat(e.file); err == nil && !fi.ModTime().Equal(e.mod) {
+			// An invalid edit keeps the previous policy; it is read
+			// again once the file changes.
+			if err := e.load(); err != nil {
+				e.mod = fi.ModTime()
+				log.Printf("authz: reload %s: %v", e.file, err)
+			}
+		}
+	}
+	return e.policy
+}
+
+// Authorize implements gopherpipe.Authorizer: it denies calls the policy
+// does not allow with PermissionDenied and reports them to Audit.
+func (e *Enforcer) Authorize(ctx context.Context, info *gopherpipe.AuthInfo, p *gopherpipe.Principal) error {
+	if e.current().Allows(info.Service, info.Method, p) {
+		return nil
+	}
+	d := Denial{Time: time.Now(), Service: info.Service, Method: info.Method, Principal: p}
+	if peer, ok := gopherpipe.PeerFromContext(ctx); ok {
+		d.Peer = peer.Addr
+	}
+	if e.Audit != nil {
+		e.Audit(d)
+	}
+	return gopherpipe.Errorf(gopherpipe.PermissionDenied, "not allowed to call %s/%s", info.Service, info.Method)
+}
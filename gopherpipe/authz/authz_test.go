@@ -0,0 +1,162 @@
+package authz
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+// TestParseRejects checks invalid policies are refused.
+func TestParseRejects(t *testing.T) {
+	cases := map[string]string{
+		`{"rules": [{"methods": ["A/B"], "principal": ["ada"]}]}`:     "unknown field",
+		`{"rules": [{"principals": ["ada"]}]}`:                        "no methods",
+		`{"rules": [{"methods": ["A"], "principals": ["ada"]}]}`:      "bad method",
+		`{"rules": [{"methods": ["A/Get*"], "principals": ["ada"]}]}`: "bad method",
+		`{"rules": [{"methods": ["*/B"], "principals": ["ada"]}]}`:    "bad method",
+		`{"rules": [{"methods": ["A/B"]}]}`:                           "grants nobody",
+	}
+	for policy, want := range cases {
+		if _, err := Parse([]byte(policy)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%s) = %v, want %q", policy, err, want)
+		}
+	}
+}
+
+// TestAllows covers method patterns, principals, roles and
+// unauthenticated access.
+func TestAllows(t *testing.T) {
+	p, err := Parse([]byte(`{"rules": [
+		{"methods": ["Chat/*"], "roles": ["chat"]},
+		{"methods": ["Chat/Ban"], "principals": ["mod"]},
+		{"methods": ["Health/Check"], "unauthenticated": true},
+		{"methods": ["Info/*"], "principals": ["*"]},
+		{"methods": ["*"], "principals": ["root"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &gopherpipe.Principal{Name: "ada", Roles: []string{"chat"}}
+	mod := &gopherpipe.Principal{Name: "mod"}
+	root := &gopherpipe.Principal{Name: "root"}
+	cases := []struct {
+		service, method string
+		p               *gopherpipe.Principal
+		want            bool
+	}{
+		{"Chat", "Send", user, true},
+		{"Chat", "Send", mod, false},
+		{"Chat", "Ban", mod, true},
+		{"Chat", "Ban", nil, false},
+		{"Health", "Check", nil, true},
+		{"Health", "Watch", nil, false},
+		{"Info", "Version", mod, true},
+		{"Info", "Version", nil, false},
+		{"Anything", "Else", root, true},
+		{"Anything", "Else", user, false},
+	}
+	for _, tc := range cases {
+		if got := p.Allows(tc.service, tc.method, tc.p); got != tc.want {
+			t.Errorf("Allows(%s/%s, %v) = %v, want %v", tc.service, tc.method, tc.p, got, tc.want)
+		}
+	}
+}
+
+type notes struct{}
+
+func (notes) Read() (string, error)   { return "notes", nil }
+func (notes) Write(text string) error { return nil }
+
+// TestEnforcer checks a server enforces the policy file, audits denials
+// and picks up edits to the file.
+func TestEnforcer(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	mtime := time.Now().Add(-time.Minute)
+	write := func(policy string) {
+		if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Coarse file system timestamps could hide the change.
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"rules": [{"methods": ["Notes/Read"], "roles": ["reader"]}, {"methods": ["Notes/*"], "principals": ["editor"]}]}`)
+	enf, err := NewEnforcer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enf.CheckInterval = 0
+	var mu sync.Mutex
+	var denials []Denial
+	enf.Audit = func(d Denial) {
+		mu.Lock()
+		denials = append(denials, d)
+		mu.Unlock()
+	}
+
+	srv := gopherpipe.NewServer("",
+		gopherpipe.WithAuthenticator(gopherpipe.BearerAuthenticator(func(ctx context.Context, token string) (*gopherpipe.Principal, error) {
+			return &gopherpipe.Principal{Name: token, Roles: []string{"reader"}}, nil
+		})),
+		gopherpipe.WithAuthorizer(enf.Authorize))
+	if err := srv.Register("Notes", notes{}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(ln)
+	defer ln.Close()
+	call := func(token, method string) error {
+		c, err := gopherpipe.Dial(ln.Addr().String(), gopherpipe.WithPerCallCredentials(gopherpipe.BearerToken(token)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if method == "Read" {
+			var s string
+			return c.Call(context.Background(), "Notes", "Read", nil, &s)
+		}
+		return c.Call(context.Background(), "Notes", "Write", []interface{}{"x"})
+	}
+
+	if err := call("ada", "Read"); err != nil {
+		t.Fatalf("reader Read: %v", err)
+	}
+	if err := call("ada", "Write"); gopherpipe.CodeOf(err) != gopherpipe.PermissionDenied {
+		t.Fatalf("reader Write: %v", err)
+	}
+	if err := call("editor", "Write"); err != nil {
+		t.Fatalf("editor Write: %v", err)
+	}
+	mu.Lock()
+	if len(denials) != 1 || denials[0].Method != "Write" || denials[0].Principal.Name != "ada" || denials[0].Peer == nil {
+		t.Fatalf("denials = %v", denials)
+	}
+	if s := denials[0].String(); !strings.Contains(s, `DENY Notes/Write principal="ada" scheme=bearer`) {
+		t.Errorf("denial = %s", s)
+	}
+	mu.Unlock()
+
+	write(`{"rules": [{"methods": ["Notes/*"], "roles": ["reader"]}]}`)
+	if err := call("ada", "Write"); err != nil {
+		t.Fatalf("reader Write after policy change: %v", err)
+	}
+	write(`{"rules": [`)
+	if err := call("ada", "Write"); err != nil {
+		t.Fatalf("invalid edit replaced the policy: %v", err)
+	}
+	if err := enf.Reload(); err == nil {
+		t.Fatal("Reload of invalid policy succeeded")
+	}
+}
@@ -0,0 +1,178 @@
+package gopherpipe
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Backend is one of the servers a client balances its calls across.
+type Backend interface {
+	// Addr is the address the backend was dialled at.
+	Addr() string
+	// Outstanding is the number of calls and streams in flight on it.
+	Outstanding() int
+}
+
+// PickInfo describes the call a Balancer picks a backend for.
+type PickInfo struct {
+	Service  string
+	Method   string
+	Metadata Metadata
+}
+
+// Balancer chooses the backend of each call among the ready and healthy
+// ones. Pick is called concurrently and ready is never empty; it lists
+// the backends in the order their addresses were given.
+type Balancer interface {
+	Pick(info *PickInfo, ready []Backend) Backend
+}
+
+// WithBalancer sets how calls are spread across backends; the default is
+// RoundRobin.
+func WithBalancer(b Balancer) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.balancer = b
+	}
+}
+
+// RoundRobin returns a Balancer sending calls to each backend in turn.
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (rr *roundRobin) Pick(info *PickInfo, ready []Backend) Backend {
+	n := atomic.AddUint64(&rr.next, 1) - 1
+	return ready[n%uint64(len(ready))]
+}
+
+// LeastRequests returns a Balancer sending each call to the backend with
+// the fewest calls in flight. Ties are broken at random.
+func LeastRequests() Balancer {
+	return leastRequests{}
+}
+
+type leastRequests struct{}
+
+func (leastRequests) Pick(info *PickInfo, ready []Backend) Backend {
+	start := rand.Intn(len(ready))
+	best := ready[start]
+	for i := 1; i < len(ready); i++ {
+		if b := ready[(start+i)%len(ready)]; b.Outstanding() < best.Outstanding() {
+			best = b
+		}
+	}
+	return best
+}
+
+// PowerOfTwoChoices returns a Balancer comparing two backends picked at
+// random and sending the call to the one with fewer calls in flight. It
+// spreads load nearly as well as LeastRequests without looking at every
+// backend.
+func PowerOfTwoChoices() Balancer {
+	return powerOfTwo{}
+}
+
+type powerOfTwo struct{}
+
+func (powerOfTwo) Pick(info *PickInfo, ready []Backend) Backend {
+	if len(ready) == 1 {
+		return ready[0]
+	}
+	i := rand.Intn(len(ready))
+	j := rand.Intn(len(ready) - 1)
+	if j >= i {
+		j++
+	}
+	if ready[j].Outstanding() < ready[i].Outstanding() {
+		return ready[j]
+	}
+	return ready[i]
+}
+
+// ringReplicas is the number of points each backend has on a consistent
+// hash ring. More points spread keys more evenly.
+const ringReplicas = 100
+
+// ConsistentHash returns a Balancer sending calls with the same value of
+// the outgoing metadata key to the same backend, for example to keep a
+// user's calls on the server caching their data. When a backend is added
+// or removed only the keys it owns move. Calls without the key go to a
+// random backend.
+func ConsistentHash(key string) Balancer {
+	return &consistentHash{key: key}
+}
+
+type consistentHash struct {
+	key string
+
+	mu    sync.Mutex
+	addrs string // the backends ring was built for
+	ring  []ringPoint
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend int
+}
+
+func (ch *consistentHash) Pick(info *PickInfo, ready []Backend) Backend {
+	value := info.Metadata.Get(ch.key)
+	if value == "" {
+		return ready[rand.Intn(len(ready))]
+	}
+	ring := ch.ringFor(ready)
+	h := hashString(value)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if i == len(ring) {
+		i = 0
+	}
+	return ready[ring[i].backend]
+}
+
+// ringFor returns the ring of ready, reusing the last one while the
+// backends stay the same.
+func (ch *consistentHash) ringFor(ready []Backend) []ringPoint {
+	addrs := make([]string, len(ready))
+	for i, b := range ready {
+		addrs[i] = b.Addr()
+	}
+	key := strings.Join(addrs, ",")
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if key == ch.addrs {
+		return ch.ring
+	}
+	ring := make([]ringPoint, 0, len(ready)*ringReplicas)
+	for i, addr := range addrs {
+		for r := 0; r < ringReplicas; r++ {
+			ring = append(ring, ringPoint{hash: hashString(addr + "#" + strconv.Itoa(r)), backend: i})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	ch.addrs, ch.ring = key, ring
+	return ring
+}
+
+// hashString hashes s for the ring. FNV alone maps similar strings such
+// as "addr#1" and "addr#2" close together, so its result is mixed with
+// the MurmurHash3 finaliser to spread them.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
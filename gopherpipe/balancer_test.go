@@ -0,0 +1,82 @@
+package gopherpipe
+
+import (
+	"fmt"
+	"testing"
+)
+
+type fakeBackend struct {
+	addr        string
+	outstanding int
+}
+
+func (b *fakeBackend) Addr() string     { return b.addr }
+func (b *fakeBackend) Outstanding() int { return b.outstanding }
+
+func fakeBackends(loads ...int) []Backend {
+	backends := make([]Backend, len(loads))
+	for i, n := range loads {
+		backends[i] = &fakeBackend{addr: fmt.Sprintf("10.0.0.%d:9000", i), outstanding: n}
+	}
+	return backends
+}
+
+// TestRoundRobin checks backends are picked in turn.
+func TestRoundRobin(t *testing.T) {
+	ready := fakeBackends(0, 0, 0)
+	rr := RoundRobin()
+	for i := 0; i < 6; i++ {
+		if b := rr.Pick(&PickInfo{}, ready); b != ready[i%3] {
+			t.Fatalf("pick %d = %s", i, b.Addr())
+		}
+	}
+}
+
+// TestLoadAwareBalancers checks LeastRequests picks the least loaded
+// backend and PowerOfTwoChoices never the most loaded one.
+func TestLoadAwareBalancers(t *testing.T) {
+	ready := fakeBackends(5, 1, 3, 9)
+	for i := 0; i < 100; i++ {
+		if b := LeastRequests().Pick(&PickInfo{}, ready); b != ready[1] {
+			t.Fatalf("LeastRequests picked %s", b.Addr())
+		}
+		if b := PowerOfTwoChoices().Pick(&PickInfo{}, ready); b == ready[3] {
+			t.Fatalf("PowerOfTwoChoices picked the most loaded backend")
+		}
+	}
+	if b := PowerOfTwoChoices().Pick(&PickInfo{}, ready[:1]); b != ready[0] {
+		t.Fatalf("PowerOfTwoChoices of one = %s", b.Addr())
+	}
+}
+
+// TestConsistentHash checks keys stick to a backend and only the keys of
+// a removed backend move.
+func TestConsistentHash(t *testing.T) {
+	ready := fakeBackends(0, 0, 0, 0)
+	ch := ConsistentHash("user")
+	owner := make(map[string]Backend)
+	counts := make(map[Backend]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		b := ch.Pick(&PickInfo{Metadata: Pairs("user", key)}, ready)
+		if again := ch.Pick(&PickInfo{Metadata: Pairs("user", key)}, ready); again != b {
+			t.Fatalf("%s picked %s then %s", key, b.Addr(), again.Addr())
+		}
+		owner[key] = b
+		counts[b]++
+	}
+	for _, b := range ready {
+		if counts[b] < 100 {
+			t.Errorf("%s owns %d of 1000 keys", b.Addr(), counts[b])
+		}
+	}
+
+	removed := ready[2]
+	fewer := append(append([]Backend{}, ready[:2]...), ready[3:]...)
+	for key, b := range owner {
+		got := ch.Pick(&PickInfo{Metadata: Pairs("user", key)}, fewer)
+		if b != removed && got != b {
+			t.Fatalf("%s moved from %s to %s", key, b.Addr(), got.Addr())
+		}
+	}
+}
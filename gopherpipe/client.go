@@ -5,38 +5,188 @@
 package gopherpipe
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
-	"fmt"
+	"errors"
+	"io"
 	"net"
+	"reflect"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/anthony/gopher-pipe/internal/codec"
-	"github.com/anthony/gopher-pipe/internal/tcplite"
 )
 
 // Client is a tiny RPC client used by the example client stubs in this repo.
-// It keeps a single TCP connection and a request counter used for Call IDs.
+// It keeps a TCP connection per backend and a request counter used for
+// Call IDs. Calls are multiplexed over a connection: a background reader
+// routes each reply to the pending call with the matching CallID, so unary
+// calls and streams may run concurrently. When a connection is lost the
+// client reconnects on the next call, backing off between failed
+// attempts; State and WatchState report its progress. A client of several
+// backends (see DialPool) spreads its calls across them with a Balancer.
 type Client struct {
-	conn    net.Conn
-	counter uint64
+	cfg          dialConfig
+	dial         func(addr string) func(ctx context.Context) (net.Conn, error)
+	balancer     Balancer
+	counter      uint64
+	stopResolver context.CancelFunc // stops address updates, if any
+	retryBudget  *retryBudget
+
+	mu       sync.Mutex
+	backends []*backend
+	state    ConnState
+	changed  chan struct{} // closed and replaced whenever a backend changes
+	watchers map[chan ConnState]struct{}
+}
+
+// DialOption configures optional Client behaviour in Dial.
+type DialOption func(*dialConfig)
+
+// dialConfig collects the DialOptions of one Dial.
+type dialConfig struct {
+	dialer    func(ctx context.Context, network, addr string) (net.Conn, error)
+	tlsConfig *tls.Config
+	creds     PerCallCredentials
+	keepalive KeepaliveParams
+	backoff   BackoffConfig
+	balancer  Balancer
+	resolver  Resolver
+
+	waitForReady bool
+	healthCheck  *string // the service checked, if health checks are on
+
+	retryPolicies   map[string]*RetryPolicy   // by "service/method" or "service/"
+	hedgingPolicies map[string]*HedgingPolicy // likewise
+	methodFlags     map[string]methodFlag     // by "service/method"
+	retryBudget     RetryBudget
+}
+
+// WithDialer replaces net.Dialer as the way Dial opens its connection, for
+// example to wrap the connection, route it through a proxy or count the
+// bytes it carries.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.dialer = dial
+	}
 }
 
-// Dial connects to a TCP address and returns a Client ready to send RPCs.
+// Dial connects to a target and returns a Client ready to send RPCs.
 // For the prototype we perform minimal negotiation and register example
-// types with gob for encoding/decoding.
-func Dial(addr string) (*Client, error) {
-	c, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
+// types with gob for encoding/decoding. The target is a TCP address or a
+// URI such as dns:///chat.internal:9000 whose scheme names the Resolver
+// of its backend addresses (see RegisterResolver); calls are balanced
+// across the backends and address changes are applied as the resolver
+// reports them. The first connection attempts are made before Dial
+// returns, which fails if none succeeds; later reconnections happen in
+// the background.
+func Dial(target string, opts ...DialOption) (*Client, error) {
+	t, ok := parseTarget(target)
+	if !ok {
+		return DialPool([]string{target}, opts...)
+	}
+	cfg := newDialConfig(opts)
+	return dialTarget(t, &cfg)
+}
+
+// newDialConfig applies opts to the defaults.
+func newDialConfig(opts []DialOption) dialConfig {
+	cfg := dialConfig{dialer: (&net.Dialer{}).DialContext}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
+	return cfg
+}
+
+// dialFunc returns the function connecting to addr.
+func (cfg *dialConfig) dialFunc(addr string) func(ctx context.Context) (net.Conn, error) {
+	return func(ctx context.Context) (net.Conn, error) {
+		conn, err := cfg.dialer(ctx, "tcp", addr)
+		if err != nil || cfg.tlsConfig == nil {
+			return conn, err
+		}
+		return clientHandshake(ctx, conn, addr, cfg.tlsConfig)
+	}
+}
+
+// newClient returns a client without backends. dial returns the function
+// connecting to an address.
+func newClient(dial func(addr string) func(ctx context.Context) (net.Conn, error), cfg *dialConfig) *Client {
 	// Minimal negotiation: skipping for prototype
 	// Register gob for Envelope
 	gob.Register(Envelope{})
-	return &Client{conn: c}, nil
+	c := &Client{
+		cfg:         *cfg,
+		dial:        dial,
+		balancer:    cfg.balancer,
+		retryBudget: newRetryBudget(cfg.retryBudget),
+		changed:     make(chan struct{}),
+		watchers:    make(map[chan ConnState]struct{}),
+	}
+	if c.balancer == nil {
+		c.balancer = RoundRobin()
+	}
+	return c
 }
 
+// Close closes the connections and stops reconnecting. Calls in flight
+// fail with Canceled.
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.mu.Lock()
+	if c.state == StateShutdown {
+		c.mu.Unlock()
+		return nil
+	}
+	backends := c.backends
+	c.setState(StateShutdown)
+	c.mu.Unlock()
+	if c.stopResolver != nil {
+		c.stopResolver()
+	}
+	for _, b := range backends {
+		b.sc.close()
+	}
+	return nil
+}
+
+// State returns the state of the client: READY while a backend is ready.
+func (c *Client) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// WatchState returns a channel that receives the current state and then
+// every change, until ctx is done or the client is closed. A slow reader
+// skips intermediate states but always sees the latest one.
+func (c *Client) WatchState(ctx context.Context) <-chan ConnState {
+	return c.watch(ctx)
+}
+
+// Connect starts reconnecting IDLE backends without waiting for a call.
+func (c *Client) Connect() {
+	c.mu.Lock()
+	backends := c.backends
+	c.mu.Unlock()
+	for _, b := range backends {
+		b.sc.connect()
+	}
+}
+
+// RTT returns the round-trip time to the first ready backend measured by
+// the last keepalive ping on its connection, or 0 if none has been
+// acknowledged yet.
+func (c *Client) RTT() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range c.backends {
+		if b.t != nil {
+			return b.t.ka.RTT()
+		}
+	}
+	return 0
 }
 
 // nextID returns an incremented counter used for unique call identifiers.
@@ -44,40 +194,263 @@ func (c *Client) nextID() uint64 {
 	return atomic.AddUint64(&c.counter, 1)
 }
 
-// CallUnary sends a unary call and waits for response
-// CallUnary performs a unary RPC: it encodes payload, sends a data frame to
-// the server, waits for a response and decodes it into out.
+// splitInput separates a channel argument, which feeds a client stream,
+// from the arguments sent in the request tuple. At most one channel
+// argument is allowed.
+func splitInput(args []interface{}) ([]interface{}, reflect.Value, error) {
+	var input reflect.Value
+	wire := args
+	for i, a := range args {
+		v := reflect.ValueOf(a)
+		if v.Kind() != reflect.Chan {
+			continue
+		}
+		if input.IsValid() {
+			return nil, input, Errorf(InvalidArgument, "at most one channel argument is supported")
+		}
+		if v.Type().ChanDir()&reflect.RecvDir == 0 {
+			return nil, input, Errorf(InvalidArgument, "stream argument %s must be receivable", v.Type())
+		}
+		input = v
+		wire = append(append([]interface{}{}, args[:i]...), args[i+1:]...)
+	}
+	return wire, input, nil
+}
+
+// CallUnary performs a unary RPC with a single argument and a single
+// result: it encodes payload, sends a data frame to the server, waits for a
+// response and decodes it into out.
 func (c *Client) CallUnary(service, method string, payload interface{}, out interface{}) error {
-	b, err := codec.Encode(payload)
+	return c.Call(context.Background(), service, method, []interface{}{payload}, out)
+}
+
+// Call performs a unary RPC with any number of arguments and results. args
+// are encoded as a tuple in declaration order and the reply is decoded into
+// results, which must be pointers matching the method's non-error results.
+// Methods that only return an error take no results. A channel argument
+// makes the call client-streaming: its values are streamed to the server
+// until the channel is closed. Unary calls of read-only methods are hedged
+// under the method's HedgingPolicy, if any, and those of idempotent
+// methods retried under its RetryPolicy.
+func (c *Client) Call(ctx context.Context, service, method string, args []interface{}, results ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return contextStatus(err)
+	}
+	args, input, err := splitInput(args)
 	if err != nil {
 		return err
 	}
-	env := Envelope{RPCType: Unary, ServiceName: service, MethodName: method, CallID: c.nextID(), Body: b}
-	envb, err := codec.Encode(env)
+	b, err := encodeTuple(args)
 	if err != nil {
-		return err
+		return Errorf(InvalidArgument, "encode arguments: %v", err)
 	}
-	if err := tcplite.WriteFrame(c.conn, tcplite.FrameTypeData, envb); err != nil {
-		return err
+	var body []byte
+	if input.IsValid() {
+		body, err = c.call(ctx, ClientStream, service, method, b, input, nil)
+	} else if hp := c.hedgingPolicy(service, method); hp != nil {
+		body, err = c.hedge(ctx, hp, func(ctx context.Context, tried *triedBackends) ([]byte, error) {
+			return c.call(ctx, Unary, service, method, b, input, tried)
+		})
+	} else if rp := c.retryPolicy(service, method); rp != nil {
+		err = c.retry(ctx, rp, func(ctx context.Context) error {
+			body, err = c.call(ctx, Unary, service, method, b, input, nil)
+			return err
+		})
+	} else {
+		body, err = c.call(ctx, Unary, service, method, b, input, nil)
 	}
-	// wait for response
-	ftype, framePayload, err := tcplite.ReadFrame(c.conn)
 	if err != nil {
 		return err
 	}
-	if ftype != tcplite.FrameTypeData {
-		return fmt.Errorf("unexpected frame: %d", ftype)
+	c.retryBudget.succeeded()
+	if err := decodeTuple(body, results); err != nil {
+		return Errorf(Internal, "decode results: %v", err)
 	}
-	var resp Envelope
-	if err := codec.Decode(framePayload, &resp); err != nil {
-		return err
+	return nil
+}
+
+// call makes one attempt of a call with the encoded arguments b and
+// returns the encoded results. If tried is not nil, the attempt avoids
+// its backends while another is ready and is added to them.
+func (c *Client) call(ctx context.Context, rpcType RPCType, service, method string, b []byte, input reflect.Value, tried *triedBackends) ([]byte, error) {
+	env, err := c.request(ctx, rpcType, service, method, b)
+	if err != nil {
+		return nil, err
 	}
-	if resp.CallID != env.CallID {
-		return fmt.Errorf("mismatched call id")
+	be, t, err := c.pick(ctx, &PickInfo{Service: service, Method: method, Metadata: env.Metadata}, tried)
+	if err != nil {
+		return nil, err
 	}
-	// unmarshal response body into out
-	if err := codec.Decode(resp.Body, out); err != nil {
-		return err
+	defer be.begin()()
+	pc, err := t.start(env, 1)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer t.finish(env.CallID, pc)
+	if input.IsValid() {
+		go t.pumpInput(ctx, env, pc, input)
+	}
+	r := t.wait(ctx, pc)
+	return r.env.Body, r.err
+}
+
+// request builds the envelope starting a call, carrying the outgoing
+// metadata, the client's credentials and the time left before the
+// deadline of ctx.
+func (c *Client) request(ctx context.Context, rpcType RPCType, service, method string, body []byte) (Envelope, error) {
+	env := Envelope{RPCType: rpcType, ServiceName: service, MethodName: method, CallID: c.nextID(), Body: body}
+	env.Metadata = OutgoingMetadata(ctx)
+	if c.cfg.creds != nil {
+		creds, err := c.cfg.creds(ctx, &AuthInfo{Service: service, Method: method, Metadata: env.Metadata, Body: body})
+		if err != nil {
+			return env, Errorf(Unauthenticated, "credentials: %v", err)
+		}
+		md := env.Metadata.Copy()
+		for k, v := range creds {
+			md.Set(k, v...)
+		}
+		env.Metadata = md
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// zero means no timeout, so a deadline that is already due is
+		// sent as the shortest one
+		env.Timeout = int64(time.Until(deadline))
+		if env.Timeout <= 0 {
+			env.Timeout = 1
+		}
+	}
+	return env, nil
+}
+
+// maxStreamQueue caps the stream messages queued for a call's consumer.
+// A stream whose consumer falls further behind fails with
+// ResourceExhausted, so the connection reader never waits for it.
+const maxStreamQueue = 1024
+
+// Stream is the client side of a server-streaming or bidirectional call.
+// Messages are read with Recv until it returns io.EOF.
+type Stream struct {
+	t   *transport
+	ctx context.Context
+	id  uint64
+	pc  *pendingCall
+	err error
+	end func() // ends the call on the backend's count, if set
+}
+
+// NewStream starts a server-streaming call. args are encoded the same way
+// as for Call; a channel argument makes the call bidirectional. The stream
+// is abandoned when ctx is done or Close is called.
+func (c *Client) NewStream(ctx context.Context, service, method string, args []interface{}) (*Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextStatus(err)
+	}
+	args, input, err := splitInput(args)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encodeTuple(args)
+	if err != nil {
+		return nil, Errorf(InvalidArgument, "encode arguments: %v", err)
+	}
+	rpcType := ServerStream
+	if input.IsValid() {
+		rpcType = BiDi
+	}
+	env, err := c.request(ctx, rpcType, service, method, b)
+	if err != nil {
+		return nil, err
+	}
+	be, t, err := c.pick(ctx, &PickInfo{Service: service, Method: method, Metadata: env.Metadata}, nil)
+	if err != nil {
+		return nil, err
+	}
+	end := be.begin()
+	s, err := startStream(ctx, t, env, input)
+	if err != nil {
+		end()
+		return nil, err
+	}
+	s.end = end
+	return s, nil
+}
+
+// openStream starts a server-streaming call on t.
+func (c *Client) openStream(ctx context.Context, t *transport, service, method string, args []interface{}) (*Stream, error) {
+	b, err := encodeTuple(args)
+	if err != nil {
+		return nil, Errorf(InvalidArgument, "encode arguments: %v", err)
+	}
+	env, err := c.request(ctx, ServerStream, service, method, b)
+	if err != nil {
+		return nil, err
+	}
+	return startStream(ctx, t, env, reflect.Value{})
+}
+
+// startStream sends the request env starting a stream on t, feeding it
+// from input if valid.
+func startStream(ctx context.Context, t *transport, env Envelope, input reflect.Value) (*Stream, error) {
+	pc, err := t.start(env, maxStreamQueue)
+	if err != nil {
+		return nil, err
+	}
+	if input.IsValid() {
+		go t.pumpInput(ctx, env, pc, input)
+	}
+	return &Stream{t: t, ctx: ctx, id: env.CallID, pc: pc}, nil
+}
+
+// Recv decodes the next stream message into out. It returns io.EOF once
+// the server has finished the stream, or the status that ended it.
+func (s *Stream) Recv(out interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	r := s.t.wait(s.ctx, s.pc)
+	switch {
+	case r.err != nil:
+		s.err = r.err
+	case r.env.EndStream:
+		s.err = io.EOF
+	default:
+		err := codec.Decode(r.env.Body, out)
+		if err == nil {
+			return nil
+		}
+		s.err = Errorf(Internal, "decode stream message: %v", err)
+	}
+	s.finish()
+	return s.err
+}
+
+// Close abandons the stream. Messages still in flight are discarded.
+func (s *Stream) Close() {
+	s.finish()
+}
+
+func (s *Stream) finish() {
+	s.t.finish(s.id, s.pc)
+	if s.end != nil {
+		s.end()
+	}
+}
+
+// decodeErrorFrame converts the payload of a FrameTypeError frame into a
+// *Status. Older peers send plain text instead of an Envelope; that text is
+// surfaced as an Unknown status.
+func decodeErrorFrame(payload []byte) error {
+	var env Envelope
+	if err := codec.Decode(payload, &env); err != nil || env.Code == OK {
+		return &Status{Code: Unknown, Message: string(payload)}
+	}
+	return &Status{Code: env.Code, Message: env.Message}
+}
+
+// contextStatus maps a context error onto the matching status code.
+func contextStatus(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Status{Code: DeadlineExceeded, Message: err.Error()}
+	}
+	return &Status{Code: Canceled, Message: err.Error()}
 }
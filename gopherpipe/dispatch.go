@@ -0,0 +1,266 @@
+package gopherpipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// service is a registered implementation together with its dispatch
+// table. The table is built once in Register so the per-call path only
+// performs a map lookup.
+type service struct {
+	name    string
+	methods map[string]*methodDesc
+}
+
+// methodDesc is the precomputed dispatch entry for one remote method: the
+// bound method value plus the wire argument and result types used for
+// decoding and encoding. An optional leading context.Context parameter
+// and the trailing error result are not part of the wire tuple. For server
+// streams resultTypes holds the single stream element type. A channel
+// parameter (client streaming) is not part of the tuple either: inIndex is
+// its position among the non-context parameters and inType its element.
+//
+// handler serves calls; for reflection-registered services it wraps fn,
+// for services registered from a ServiceDesc it is the generated handler,
+// fn is unset and the types, if any, only feed the reflection service.
+type methodDesc struct {
+	name        string
+	handler     MethodHandler
+	fn          reflect.Value
+	rpcType     RPCType
+	hasContext  bool
+	argTypes    []reflect.Type
+	resultTypes []reflect.Type
+	inIndex     int
+	inType      reflect.Type
+}
+
+// newService walks the exported methods of impl and validates that each
+// one has a supported signature. All unsupported methods are reported in
+// a single error so callers can fix them in one go.
+func newService(name string, impl interface{}) (*service, error) {
+	if impl == nil {
+		return nil, fmt.Errorf("gopherpipe: register %s: nil implementation", name)
+	}
+	return buildService(name, impl, reflect.TypeOf(impl))
+}
+
+// buildService builds the dispatch table for impl, exposing only the
+// exported methods in the method set of contract. contract is either the
+// concrete type of impl or an interface type impl satisfies.
+func buildService(name string, impl interface{}, contract reflect.Type) (*service, error) {
+	rv := reflect.ValueOf(impl)
+	svc := &service{name: name, methods: make(map[string]*methodDesc)}
+	var unsupported []string
+	for i := 0; i < contract.NumMethod(); i++ {
+		m := contract.Method(i)
+		if m.PkgPath != "" {
+			continue
+		}
+		desc, err := newMethodDesc(m.Name, rv.MethodByName(m.Name))
+		if err != nil {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%v)", m.Name, err))
+			continue
+		}
+		svc.methods[m.Name] = desc
+	}
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("gopherpipe: register %s: unsupported methods: %s", name, strings.Join(unsupported, ", "))
+	}
+	if len(svc.methods) == 0 {
+		return nil, fmt.Errorf("gopherpipe: register %s: %s has no exported methods", name, contract)
+	}
+	return svc, nil
+}
+
+// newMethodDesc validates the signature of a bound method value and
+// captures its argument and result types. Supported signatures take an
+// optional context.Context followed by any number of arguments and return
+// either any number of results followed by an error (unary), or a
+// receive-only channel and an error (server streaming). One parameter may
+// be a receive-only channel fed by the caller (client streaming, or
+// bidirectional streaming when combined with a channel result). Streaming
+// methods must take the context, which ends when the caller goes away.
+// genstub checks contracts with the same rules in internal/contract; keep
+// the two in step.
+func newMethodDesc(name string, fn reflect.Value) (*methodDesc, error) {
+	mtype := fn.Type()
+	if mtype.IsVariadic() {
+		return nil, errors.New("variadic methods are not supported")
+	}
+	desc := &methodDesc{name: name, fn: fn, rpcType: Unary, inIndex: -1}
+	desc.handler = desc.reflectHandler
+	first := 0
+	if mtype.NumIn() > 0 && mtype.In(0) == contextType {
+		desc.hasContext = true
+		first = 1
+	}
+	for i := first; i < mtype.NumIn(); i++ {
+		t := mtype.In(i)
+		if t.Kind() == reflect.Chan {
+			if desc.inType != nil {
+				return nil, errors.New("at most one channel parameter is supported")
+			}
+			if t.ChanDir() != reflect.RecvDir {
+				return nil, fmt.Errorf("stream parameter %s must be receive-only", t)
+			}
+			if err := checkWireType(t.Elem()); err != nil {
+				return nil, fmt.Errorf("stream parameter element: %v", err)
+			}
+			desc.inIndex = i - first
+			desc.inType = t.Elem()
+			desc.rpcType = ClientStream
+			continue
+		}
+		if err := checkWireType(t); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		desc.argTypes = append(desc.argTypes, t)
+	}
+	if mtype.NumOut() == 0 || mtype.Out(mtype.NumOut()-1) != errorType {
+		return nil, errors.New("last result must be error")
+	}
+	if mtype.NumOut() == 2 && mtype.Out(0).Kind() == reflect.Chan {
+		ch := mtype.Out(0)
+		if ch.ChanDir() != reflect.RecvDir {
+			return nil, fmt.Errorf("stream result %s must be receive-only", ch)
+		}
+		if !desc.hasContext {
+			return nil, errors.New("streaming methods must take a context.Context first")
+		}
+		if err := checkWireType(ch.Elem()); err != nil {
+			return nil, fmt.Errorf("stream element: %v", err)
+		}
+		desc.rpcType = ServerStream
+		if desc.inType != nil {
+			desc.rpcType = BiDi
+		}
+		desc.resultTypes = []reflect.Type{ch.Elem()}
+		return desc, nil
+	}
+	for i := 0; i < mtype.NumOut()-1; i++ {
+		t := mtype.Out(i)
+		if err := checkWireType(t); err != nil {
+			return nil, fmt.Errorf("result %d: %v", i, err)
+		}
+		desc.resultTypes = append(desc.resultTypes, t)
+	}
+	if desc.inType != nil && !desc.hasContext {
+		return nil, errors.New("streaming methods must take a context.Context first")
+	}
+	return desc, nil
+}
+
+// reflectHandler is the MethodHandler of reflection-registered methods. It
+// decodes the argument tuple into values of the precomputed types, feeds
+// a stream parameter from the caller's messages, calls the method and
+// sends its results or forwards its result stream.
+func (m *methodDesc) reflectHandler(ctx context.Context, call *ServerCall) error {
+	ptrs := make([]interface{}, len(m.argTypes))
+	for i, t := range m.argTypes {
+		ptrs[i] = reflect.New(t).Interface()
+	}
+	if err := call.DecodeArgs(ptrs...); err != nil {
+		return err
+	}
+	in := make([]reflect.Value, 0, len(ptrs)+2)
+	if m.hasContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for i, p := range ptrs {
+		if i == m.inIndex {
+			in = append(in, m.input(ctx, call))
+		}
+		in = append(in, reflect.ValueOf(p).Elem())
+	}
+	if m.inIndex == len(ptrs) {
+		in = append(in, m.input(ctx, call))
+	}
+
+	results := m.fn.Call(in)
+	last := results[len(results)-1]
+	if !last.IsNil() {
+		return last.Interface().(error)
+	}
+	results = results[:len(results)-1]
+	if m.rpcType == ServerStream || m.rpcType == BiDi {
+		return forwardStream(ctx, call, results[0])
+	}
+	vals := make([]interface{}, len(results))
+	for i, r := range results {
+		vals[i] = r.Interface()
+	}
+	return call.SendResults(vals...)
+}
+
+// input returns a channel of the stream parameter's type fed with the
+// messages the caller streams, like RecvStream.
+func (m *methodDesc) input(ctx context.Context, call *ServerCall) reflect.Value {
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, m.inType), 0)
+	go func() {
+		defer ch.Close()
+		done := reflect.ValueOf(ctx.Done())
+		for {
+			v := reflect.New(m.inType)
+			if err := call.Recv(v.Interface()); err != nil {
+				call.recvFailed(err)
+				return
+			}
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: ch, Send: v.Elem()},
+				{Dir: reflect.SelectRecv, Chan: done},
+			})
+			if chosen == 1 {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// forwardStream sends every value received from the result channel ch as
+// a stream message until ch is closed. If the caller goes away, ctx is
+// cancelled and forwarding stops; implementations should watch their
+// context so the producing goroutine can exit.
+func forwardStream(ctx context.Context, call *ServerCall, ch reflect.Value) error {
+	if ch.IsNil() {
+		return nil
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: ch},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	for {
+		chosen, v, ok := reflect.Select(cases)
+		if chosen == 1 {
+			return contextStatus(ctx.Err())
+		}
+		if !ok {
+			return nil
+		}
+		if err := call.Send(v.Interface()); err != nil {
+			return err
+		}
+	}
+}
+
+// checkWireType rejects types that cannot travel in a tuple.
+func checkWireType(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return fmt.Errorf("%s values cannot be sent over the wire", t)
+	}
+	if t == contextType {
+		return errors.New("context.Context must be the first parameter")
+	}
+	return nil
+}
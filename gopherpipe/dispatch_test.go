@@ -0,0 +1,126 @@
+package gopherpipe
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+type mixedSignatures struct{}
+
+func (m *mixedSignatures) Good(in int) (int, error)       { return in, nil }
+func (m *mixedSignatures) Callback(f func()) error        { return nil }
+func (m *mixedSignatures) NoError(in int) int             { return in }
+func (m *mixedSignatures) unexported(in int) (int, error) { return in, nil }
+
+// TestRegisterRejectsUnsupportedMethods verifies Register validates every
+// exported method up front and names each unsupported one in the error.
+func TestRegisterRejectsUnsupportedMethods(t *testing.T) {
+	srv := NewServer("")
+	err := srv.Register("M", &mixedSignatures{})
+	if err == nil {
+		t.Fatal("expected error for unsupported methods")
+	}
+	for _, name := range []string{"Callback", "NoError"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("error does not mention %s: %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Good") || strings.Contains(err.Error(), "unexported") {
+		t.Fatalf("error mentions supported or unexported method: %v", err)
+	}
+}
+
+// TestRejectedSignatures verifies Register rejects the signatures genstub
+// rejects, with the same reasons; internal/contract tests the same table.
+func TestRejectedSignatures(t *testing.T) {
+	cases := []struct {
+		fn   interface{}
+		want string
+	}{
+		{func(x int) int { return x }, "last result must be error"},
+		{func(x int) {}, "last result must be error"},
+		{func(f func()) error { return nil }, "func() values cannot be sent"},
+		{func(xs ...int) error { return nil }, "variadic methods are not supported"},
+		{func(ctx context.Context, a, b <-chan int) error { return nil }, "at most one channel parameter"},
+		{func(ctx context.Context, in chan<- int) error { return nil }, "must be receive-only"},
+		{func(ctx context.Context, in chan int) error { return nil }, "must be receive-only"},
+		{func(ctx context.Context, in <-chan func()) error { return nil }, "stream parameter element"},
+		{func(ctx context.Context) (chan int, error) { return nil, nil }, "must be receive-only"},
+		{func(ctx context.Context) (<-chan func(), error) { return nil, nil }, "stream element"},
+		{func(ctx context.Context) (<-chan int, int, error) { return nil, 0, nil }, "values cannot be sent"},
+		{func(x int, ctx context.Context) error { return nil }, "context.Context must be the first parameter"},
+		{func(p unsafe.Pointer) error { return nil }, "values cannot be sent"},
+		{func(in <-chan int) error { return nil }, "streaming methods must take a context.Context first"},
+		{func(x int) (<-chan int, error) { return nil, nil }, "streaming methods must take a context.Context first"},
+	}
+	for _, tc := range cases {
+		fn := reflect.ValueOf(tc.fn)
+		if _, err := newMethodDesc("M", fn); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", fn.Type(), err, tc.want)
+		}
+	}
+}
+
+// TestRegisterBuildsMethodTable verifies the dispatch table contains
+// precomputed argument and result types for each method.
+func TestRegisterBuildsMethodTable(t *testing.T) {
+	svc, err := newService("P", &panicky{})
+	if err != nil {
+		t.Fatalf("newService: %v", err)
+	}
+	m := svc.methods["Echo"]
+	stringType := reflect.TypeOf("")
+	if m == nil || len(m.argTypes) != 1 || m.argTypes[0] != stringType || len(m.resultTypes) != 1 || m.resultTypes[0] != stringType {
+		t.Fatalf("unexpected method table entry: %+v", m)
+	}
+	srv := NewServer("")
+	if err := srv.Register("P", &panicky{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := srv.Register("P", &panicky{}); err == nil {
+		t.Fatal("expected duplicate registration error")
+	}
+}
+
+// Echoer is the remote contract used by TestRegisterServiceByInterface.
+type Echoer interface {
+	Echo(in string) (string, error)
+}
+
+type echoerWithHelpers struct{}
+
+func (e *echoerWithHelpers) Echo(in string) (string, error) { return in, nil }
+func (e *echoerWithHelpers) Reset()                         {}
+
+// TestRegisterServiceByInterface verifies only the contract's methods are
+// exposed and the service name is derived from the interface name.
+func TestRegisterServiceByInterface(t *testing.T) {
+	srv := NewServer("")
+	if err := RegisterService[Echoer](srv, &echoerWithHelpers{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	c := startTestServer(t, srv)
+
+	var out string
+	if err := c.CallUnary("Echoer", "Echo", "hi", &out); err != nil || out != "hi" {
+		t.Fatalf("echo: %q %v", out, err)
+	}
+	var none struct{}
+	if err := c.CallUnary("Echoer", "Reset", struct{}{}, &none); CodeOf(err) != Unimplemented {
+		t.Fatalf("expected Unimplemented for helper method, got %v", err)
+	}
+}
+
+// TestRegisterServiceRejectsNonInterface verifies RegisterService requires
+// an interface type parameter.
+func TestRegisterServiceRejectsNonInterface(t *testing.T) {
+	if err := RegisterService[*echoerWithHelpers](NewServer(""), &echoerWithHelpers{}); err == nil {
+		t.Fatal("expected error for non-interface contract")
+	}
+	if err := RegisterService[Echoer](NewServer(""), nil); err == nil {
+		t.Fatal("expected error for nil implementation")
+	}
+}
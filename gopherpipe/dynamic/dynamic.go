@@ -0,0 +1,288 @@
+// Package dynamic calls GopherPipe methods known only by their schema,
+// without the Go types of the service. Schema types are turned into
+// equivalent reflect types (gob matches structs by field name, so a
+// reflect.StructOf struct decodes like the server's named type) and
+// arguments and results are converted from and to JSON. It backs the
+// HTTP gateway and command-line clients.
+package dynamic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// Registry maps qualified type names to Go types. Opaque (self-encoding)
+// types and recursive structs cannot be rebuilt from a schema and must be
+// registered. It is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// NewRegistry returns a registry that knows time.Time.
+func NewRegistry() *Registry {
+	r := &Registry{types: make(map[string]reflect.Type)}
+	r.Register("time.Time", reflect.TypeOf(time.Time{}))
+	return r
+}
+
+// Register makes name resolve to t.
+func (r *Registry) Register(name string, t reflect.Type) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[name] = t
+}
+
+func (r *Registry) lookup(name string) reflect.Type {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.types[name]
+}
+
+// Method is a method whose wire types have been rebuilt from a schema.
+type Method struct {
+	Service string
+	Name    string
+	RPCType string
+	Args    []reflect.Type
+	Results []reflect.Type
+	// StreamIn and StreamOut are the message types of the caller's stream
+	// and of the result stream, or nil.
+	StreamIn  reflect.Type
+	StreamOut reflect.Type
+}
+
+// Resolve looks up service.method in s and rebuilds its wire types,
+// consulting reg (which may be nil) for registered types first.
+func Resolve(s *schema.Schema, reg *Registry, service, method string) (*Method, error) {
+	svc := s.Service(service)
+	if svc == nil {
+		return nil, gopherpipe.Errorf(gopherpipe.NotFound, "unknown service %q", service)
+	}
+	sm := svc.Method(method)
+	if sm == nil {
+		return nil, gopherpipe.Errorf(gopherpipe.NotFound, "unknown method %s/%s", service, method)
+	}
+	if reg == nil {
+		reg = NewRegistry()
+	}
+	b := &typeBuilder{s: s, reg: reg, building: make(map[string]bool)}
+	m := &Method{Service: service, Name: method, RPCType: sm.RPCType}
+	var err error
+	if m.Args, err = b.types(sm.Args); err != nil {
+		return nil, err
+	}
+	if m.Results, err = b.types(sm.Results); err != nil {
+		return nil, err
+	}
+	if sm.StreamIn != nil {
+		if m.StreamIn, err = b.typ(sm.StreamIn); err != nil {
+			return nil, err
+		}
+	}
+	if sm.StreamOut != nil {
+		if m.StreamOut, err = b.typ(sm.StreamOut); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// typeBuilder converts schema types to reflect types.
+type typeBuilder struct {
+	s        *schema.Schema
+	reg      *Registry
+	building map[string]bool
+}
+
+var basicTypes = map[schema.Kind]reflect.Type{
+	schema.Bool: reflect.TypeOf(false), schema.Int: reflect.TypeOf(int(0)),
+	schema.Int8: reflect.TypeOf(int8(0)), schema.Int16: reflect.TypeOf(int16(0)),
+	schema.Int32: reflect.TypeOf(int32(0)), schema.Int64: reflect.TypeOf(int64(0)),
+	schema.Uint: reflect.TypeOf(uint(0)), schema.Uint8: reflect.TypeOf(uint8(0)),
+	schema.Uint16: reflect.TypeOf(uint16(0)), schema.Uint32: reflect.TypeOf(uint32(0)),
+	schema.Uint64: reflect.TypeOf(uint64(0)), schema.Uintptr: reflect.TypeOf(uintptr(0)),
+	schema.Float32: reflect.TypeOf(float32(0)), schema.Float64: reflect.TypeOf(float64(0)),
+	schema.Complex64: reflect.TypeOf(complex64(0)), schema.Complex128: reflect.TypeOf(complex128(0)),
+	schema.String:    reflect.TypeOf(""),
+	schema.Interface: reflect.TypeOf((*interface{})(nil)).Elem(),
+}
+
+func (b *typeBuilder) types(ts []*schema.Type) ([]reflect.Type, error) {
+	out := make([]reflect.Type, len(ts))
+	for i, t := range ts {
+		rt, err := b.typ(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rt
+	}
+	return out, nil
+}
+
+func (b *typeBuilder) typ(t *schema.Type) (reflect.Type, error) {
+	if rt, ok := basicTypes[t.Kind]; ok {
+		return rt, nil
+	}
+	switch t.Kind {
+	case schema.Slice, schema.Array:
+		elem, err := b.typ(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == schema.Array {
+			return reflect.ArrayOf(int(t.Len), elem), nil
+		}
+		return reflect.SliceOf(elem), nil
+	case schema.Map:
+		key, err := b.typ(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := b.typ(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, gopherpipe.Errorf(gopherpipe.Unimplemented, "map key %s is not comparable", key)
+		}
+		return reflect.MapOf(key, elem), nil
+	case schema.Struct:
+		return b.structType(t.Fields)
+	case schema.Ref:
+		if rt := b.reg.lookup(t.Name); rt != nil {
+			return rt, nil
+		}
+		def := b.s.Types[t.Name]
+		if def == nil {
+			return nil, gopherpipe.Errorf(gopherpipe.Internal, "schema has no definition of %s", t.Name)
+		}
+		if b.building[t.Name] {
+			return nil, gopherpipe.Errorf(gopherpipe.Unimplemented, "recursive type %s needs a registered Go type", t.Name)
+		}
+		b.building[t.Name] = true
+		defer delete(b.building, t.Name)
+		return b.typ(def)
+	case schema.Opaque:
+		if rt := b.reg.lookup(t.Name); rt != nil {
+			return rt, nil
+		}
+		return nil, gopherpipe.Errorf(gopherpipe.Unimplemented, "self-encoding type %s needs a registered Go type", t.Name)
+	}
+	return nil, gopherpipe.Errorf(gopherpipe.Internal, "unknown schema kind %q", t.Kind)
+}
+
+func (b *typeBuilder) structType(fields []*schema.Field) (reflect.Type, error) {
+	sf := make([]reflect.StructField, len(fields))
+	for i, f := range fields {
+		ft, err := b.typ(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		sf[i] = reflect.StructField{Name: f.Name, Type: ft}
+	}
+	return reflect.StructOf(sf), nil
+}
+
+// ArgsFromJSON decodes the request arguments from JSON. A method with one
+// argument takes the value itself; other methods take an array with one
+// element per argument, and methods without arguments accept an empty
+// body.
+func (m *Method) ArgsFromJSON(data []byte) ([]interface{}, error) {
+	ptrs := make([]interface{}, len(m.Args))
+	for i, t := range m.Args {
+		ptrs[i] = reflect.New(t).Interface()
+	}
+	var err error
+	switch {
+	case len(ptrs) == 1:
+		err = json.Unmarshal(data, ptrs[0])
+	case len(ptrs) == 0 && len(bytes.TrimSpace(data)) == 0:
+	default:
+		var raw []json.RawMessage
+		if err = json.Unmarshal(data, &raw); err == nil && len(raw) != len(ptrs) {
+			err = fmt.Errorf("got %d arguments, want %d", len(raw), len(ptrs))
+		}
+		for i := 0; err == nil && i < len(raw); i++ {
+			err = json.Unmarshal(raw[i], ptrs[i])
+		}
+	}
+	if err != nil {
+		return nil, gopherpipe.Errorf(gopherpipe.InvalidArgument, "%s/%s arguments: %v", m.Service, m.Name, err)
+	}
+	args := make([]interface{}, len(ptrs))
+	for i, p := range ptrs {
+		args[i] = reflect.ValueOf(p).Elem().Interface()
+	}
+	return args, nil
+}
+
+// Invoke calls a unary method with JSON arguments and returns its results
+// as JSON, shaped like the arguments: the value itself for one result, an
+// array otherwise.
+func (m *Method) Invoke(ctx context.Context, c *gopherpipe.Client, jsonArgs []byte) ([]byte, error) {
+	if m.RPCType != schema.Unary {
+		return nil, gopherpipe.Errorf(gopherpipe.FailedPrecondition, "%s/%s is a %s method", m.Service, m.Name, m.RPCType)
+	}
+	args, err := m.ArgsFromJSON(jsonArgs)
+	if err != nil {
+		return nil, err
+	}
+	ptrs := make([]interface{}, len(m.Results))
+	for i, t := range m.Results {
+		ptrs[i] = reflect.New(t).Interface()
+	}
+	if err := c.Call(ctx, m.Service, m.Name, args, ptrs...); err != nil {
+		return nil, err
+	}
+	if len(ptrs) == 1 {
+		return json.Marshal(ptrs[0])
+	}
+	if len(ptrs) == 0 {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(ptrs)
+}
+
+// InvokeStream calls a server-streaming method with JSON arguments and
+// passes every message to fn as JSON. It returns nil once the stream ends
+// and the first error of the call or of fn otherwise.
+func (m *Method) InvokeStream(ctx context.Context, c *gopherpipe.Client, jsonArgs []byte, fn func(msg []byte) error) error {
+	if m.RPCType != schema.ServerStream {
+		return gopherpipe.Errorf(gopherpipe.FailedPrecondition, "%s/%s is a %s method", m.Service, m.Name, m.RPCType)
+	}
+	args, err := m.ArgsFromJSON(jsonArgs)
+	if err != nil {
+		return err
+	}
+	s, err := c.NewStream(ctx, m.Service, m.Name, args)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for {
+		v := reflect.New(m.StreamOut)
+		if err := s.Recv(v.Interface()); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		msg, err := json.Marshal(v.Interface())
+		if err != nil {
+			return gopherpipe.Errorf(gopherpipe.Internal, "encode message: %v", err)
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
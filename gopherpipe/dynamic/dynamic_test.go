@@ -0,0 +1,73 @@
+package dynamic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+type node struct {
+	Name string
+	Kids []node
+}
+
+type event struct {
+	At   time.Time
+	Tags map[string]int
+}
+
+// TestResolveTypes verifies schema types are rebuilt with the same wire
+// shape, that recursive types need a registered Go type and that opaque
+// types come from the registry.
+func TestResolveTypes(t *testing.T) {
+	b := schema.NewReflectBuilder()
+	b.Add(&schema.Service{Name: "S", Methods: []*schema.Method{
+		{Name: "Log", RPCType: schema.Unary, Args: []*schema.Type{b.Type(reflect.TypeOf(event{}))}},
+		{Name: "Tree", RPCType: schema.Unary, Args: []*schema.Type{b.Type(reflect.TypeOf(node{}))}},
+	}})
+	s := b.Schema()
+
+	m, err := Resolve(s, nil, "S", "Log")
+	if err != nil {
+		t.Fatalf("Resolve(Log): %v", err)
+	}
+	ev := m.Args[0]
+	if ev.Kind() != reflect.Struct || ev.Field(0).Type != reflect.TypeOf(time.Time{}) || ev.Field(1).Type != reflect.TypeOf(map[string]int{}) {
+		t.Fatalf("event rebuilt as %v", ev)
+	}
+
+	if _, err := Resolve(s, nil, "S", "Tree"); gopherpipe.CodeOf(err) != gopherpipe.Unimplemented {
+		t.Fatalf("recursive type: got %v, want Unimplemented", err)
+	}
+	reg := NewRegistry()
+	reg.Register(s.Service("S").Method("Tree").Args[0].Name, reflect.TypeOf(node{}))
+	if m, err := Resolve(s, reg, "S", "Tree"); err != nil || m.Args[0] != reflect.TypeOf(node{}) {
+		t.Fatalf("registered recursive type: %v, %v", m, err)
+	}
+	if _, err := Resolve(s, nil, "S", "Nope"); gopherpipe.CodeOf(err) != gopherpipe.NotFound {
+		t.Fatalf("unknown method: got %v", err)
+	}
+}
+
+// TestArgsFromJSON covers the argument shapes: a bare value for one
+// argument, an array otherwise and an empty body for none.
+func TestArgsFromJSON(t *testing.T) {
+	one := &Method{Args: []reflect.Type{reflect.TypeOf([]int(nil))}}
+	if args, err := one.ArgsFromJSON([]byte(`[1,2]`)); err != nil || !reflect.DeepEqual(args[0], []int{1, 2}) {
+		t.Fatalf("one arg = %v, %v", args, err)
+	}
+	two := &Method{Args: []reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0)}}
+	if args, err := two.ArgsFromJSON([]byte(`["a", 3]`)); err != nil || args[0] != "a" || args[1] != 3 {
+		t.Fatalf("two args = %v, %v", args, err)
+	}
+	if _, err := two.ArgsFromJSON([]byte(`["a"]`)); gopherpipe.CodeOf(err) != gopherpipe.InvalidArgument {
+		t.Fatalf("short array: got %v", err)
+	}
+	none := &Method{}
+	if args, err := none.ArgsFromJSON([]byte(" \n")); err != nil || len(args) != 0 {
+		t.Fatalf("no args = %v, %v", args, err)
+	}
+}
@@ -18,10 +18,24 @@ const (
 // a small set of fields sufficient for prototype unary and streaming
 // operations: the RPC type, service and method names for dispatch, a
 // unique CallID for matching requests/responses, and the message body.
+// Error replies travel in FrameTypeError frames and carry a non-OK Code
+// plus a human-readable Message instead of a Body. Server streams send one
+// envelope per message and finish with an empty envelope that has
+// EndStream set. The messages a caller streams after its request, and the
+// envelope ending them, have StreamMessage set so they are never mistaken
+// for a new call. Requests may also carry call Metadata and the Timeout
+// the server applies to the call, in nanoseconds (zero for none).
 type Envelope struct {
 	RPCType     RPCType
 	ServiceName string
 	MethodName  string
 	CallID      uint64
 	Body        []byte
+	Code        Code
+	Message     string
+	EndStream   bool
+	Metadata    Metadata
+	Timeout     int64
+
+	StreamMessage bool
 }
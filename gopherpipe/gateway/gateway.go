@@ -0,0 +1,291 @@
+// Package gateway exposes GopherPipe services over HTTP/JSON. A request
+//
+//	POST /{service}/{method}
+//
+// carries the arguments as JSON (the value itself for one argument, an
+// array otherwise). The gateway looks up the method's wire types through
+// the backend's reflection service (or a static schema), converts the
+// arguments, calls the backend and answers with the JSON results. Failures
+// map to HTTP status codes and a JSON body {"code": ..., "message": ...}.
+//
+// Server-streaming methods answer with Server-Sent Events when the client
+// accepts text/event-stream and with newline-delimited JSON otherwise. An
+// error after the stream has started is sent as a final "error" event or
+// as a final {"error": {...}} line.
+//
+// The Authorization header, and any other header named with
+// WithForwardedHeaders, is forwarded to the backend as call metadata, so
+// the backend's authenticator sees the HTTP caller's credentials. Per-call
+// credentials of the gateway's own client replace forwarded metadata of
+// the same key; a gateway calling as itself must sit behind its own HTTP
+// authentication.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+	"github.com/anthony/gopher-pipe/gopherpipe/dynamic"
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// TimeoutHeader optionally sets the deadline of the backend call, as a Go
+// duration such as "1.5s".
+const TimeoutHeader = "Gopherpipe-Timeout"
+
+// defaultForwardedHeaders are forwarded to the backend by every gateway.
+var defaultForwardedHeaders = []string{"Authorization"}
+
+// maxBody caps request bodies at the largest TCP_LITE frame.
+const maxBody = 10 << 20
+
+// Gateway is an http.Handler translating HTTP/JSON requests to calls on a
+// GopherPipe backend.
+type Gateway struct {
+	c       *gopherpipe.Client
+	reg     *dynamic.Registry
+	static  *schema.Schema
+	headers []string
+
+	mu      sync.Mutex
+	methods map[string]*dynamic.Method
+}
+
+// Option configures a Gateway in New.
+type Option func(*Gateway)
+
+// WithSchema resolves methods from s instead of asking the backend's
+// reflection service, for backends that do not enable it.
+func WithSchema(s *schema.Schema) Option {
+	return func(g *Gateway) {
+		g.static = s
+	}
+}
+
+// WithRegistry supplies Go types for self-encoding and recursive types,
+// which cannot be rebuilt from a schema.
+func WithRegistry(reg *dynamic.Registry) Option {
+	return func(g *Gateway) {
+		g.reg = reg
+	}
+}
+
+// WithForwardedHeaders forwards the named request headers to the backend
+// as call metadata, in addition to Authorization. Metadata keys are the
+// lower-cased header names.
+func WithForwardedHeaders(names ...string) Option {
+	return func(g *Gateway) {
+		g.headers = append(g.headers, names...)
+	}
+}
+
+// New returns a gateway calling the backend through c.
+func New(c *gopherpipe.Client, opts ...Option) *Gateway {
+	g := &Gateway{
+		c:       c,
+		reg:     dynamic.NewRegistry(),
+		headers: append([]string(nil), defaultForwardedHeaders...),
+		methods: make(map[string]*dynamic.Method),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, gopherpipe.Errorf(gopherpipe.Unimplemented, "method %s not allowed, use POST", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := g.outgoing(r)
+	if v := r.Header.Get(TimeoutHeader); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, gopherpipe.Errorf(gopherpipe.InvalidArgument, "bad %s header: %v", TimeoutHeader, err), 0)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	service, method, err := gopherpipe.SplitMethod(r.URL.Path)
+	if err != nil {
+		writeError(w, err, 0)
+		return
+	}
+	m, err := g.method(ctx, service, method)
+	if err != nil {
+		writeError(w, err, 0)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		writeError(w, gopherpipe.Errorf(gopherpipe.InvalidArgument, "read body: %v", err), 0)
+		return
+	}
+	switch m.RPCType {
+	case schema.Unary:
+		out, err := m.Invoke(ctx, g.c, body)
+		if err != nil {
+			writeError(w, err, 0)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(out, '\n'))
+	case schema.ServerStream:
+		g.stream(ctx, w, r, m, body)
+	default:
+		writeError(w, gopherpipe.Errorf(gopherpipe.Unimplemented, "%s methods are not supported over HTTP", m.RPCType), 0)
+	}
+}
+
+// outgoing returns the context of r carrying its forwarded headers as
+// outgoing metadata.
+func (g *Gateway) outgoing(r *http.Request) context.Context {
+	md := gopherpipe.Metadata{}
+	for _, name := range g.headers {
+		if vals := r.Header.Values(name); len(vals) > 0 {
+			md.Append(name, vals...)
+		}
+	}
+	if len(md) == 0 {
+		return r.Context()
+	}
+	return gopherpipe.NewOutgoingContext(r.Context(), md)
+}
+
+// method resolves service.method, caching the result. Unknown methods are
+// looked up again on every request so newly deployed ones show up.
+func (g *Gateway) method(ctx context.Context, service, method string) (*dynamic.Method, error) {
+	key := service + "/" + method
+	g.mu.Lock()
+	m := g.methods[key]
+	g.mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	s := g.static
+	if s == nil {
+		var err error
+		if s, err = gopherpipe.DescribeServices(ctx, g.c, service); err != nil {
+			return nil, err
+		}
+	}
+	m, err := dynamic.Resolve(s, g.reg, service, method)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	g.methods[key] = m
+	g.mu.Unlock()
+	return m, nil
+}
+
+// stream relays a server stream as SSE or NDJSON.
+func (g *Gateway) stream(ctx context.Context, w http.ResponseWriter, r *http.Request, m *dynamic.Method, body []byte) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	err := m.InvokeStream(ctx, g.c, body, func(msg []byte) error {
+		start()
+		var err error
+		if sse {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", msg)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", msg)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	})
+	if err == nil {
+		start()
+		return
+	}
+	if !started {
+		writeError(w, err, 0)
+		return
+	}
+	b, _ := json.Marshal(errorBody(err))
+	if sse {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+	} else {
+		fmt.Fprintf(w, "{\"error\":%s}\n", b)
+	}
+}
+
+// statusJSON is the JSON form of a failed call.
+type statusJSON struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorBody(err error) statusJSON {
+	st := gopherpipe.StatusOf(err)
+	return statusJSON{Code: st.Code.String(), Message: st.Message}
+}
+
+// writeError answers with the JSON form of err, using httpStatus or, if
+// it is zero, the status mapped from err's code.
+func writeError(w http.ResponseWriter, err error, httpStatus int) {
+	if httpStatus == 0 {
+		httpStatus = HTTPStatus(gopherpipe.CodeOf(err))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(errorBody(err))
+}
+
+// HTTPStatus maps a status code to the HTTP status the gateway answers
+// with, following the usual gRPC-to-HTTP conventions.
+func HTTPStatus(code gopherpipe.Code) int {
+	switch code {
+	case gopherpipe.OK:
+		return http.StatusOK
+	case gopherpipe.Canceled:
+		return 499 // client closed request
+	case gopherpipe.InvalidArgument, gopherpipe.FailedPrecondition, gopherpipe.OutOfRange:
+		return http.StatusBadRequest
+	case gopherpipe.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case gopherpipe.NotFound:
+		return http.StatusNotFound
+	case gopherpipe.AlreadyExists, gopherpipe.Aborted:
+		return http.StatusConflict
+	case gopherpipe.PermissionDenied:
+		return http.StatusForbidden
+	case gopherpipe.Unauthenticated:
+		return http.StatusUnauthorized
+	case gopherpipe.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case gopherpipe.Unimplemented:
+		return http.StatusNotImplemented
+	case gopherpipe.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/gopherpipe"
+)
+
+type Point struct {
+	X, Y  int
+	Label string
+}
+
+type geo struct{}
+
+func (geo) Add(a, b Point) (Point, error) {
+	return Point{X: a.X + b.X, Y: a.Y + b.Y, Label: a.Label + b.Label}, nil
+}
+
+func (geo) Fail(code uint32) error {
+	return gopherpipe.Errorf(gopherpipe.Code(code), "failed on purpose")
+}
+
+func (geo) Path(ctx context.Context, n int) (<-chan Point, error) {
+	ch := make(chan Point, n)
+	for i := 0; i < n; i++ {
+		ch <- Point{X: i, Y: i * i}
+	}
+	close(ch)
+	return ch, nil
+}
+
+// Caller reports the forwarded metadata the call arrived with.
+func (geo) Caller(ctx context.Context, key string) (string, error) {
+	return strings.Join(gopherpipe.IncomingMetadata(ctx)[key], ","), nil
+}
+
+// startGateway serves a gateway in front of a geo backend with reflection
+// enabled.
+func startGateway(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	srv := gopherpipe.NewServer("")
+	if err := srv.Register("Geo", geo{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReflection(); err != nil {
+		t.Fatal(err)
+	}
+	c := gopherpipe.DialLoopback(srv)
+	hs := httptest.NewServer(New(c, opts...))
+	t.Cleanup(func() {
+		hs.Close()
+		c.Close()
+	})
+	return hs
+}
+
+func post(t *testing.T, url, accept, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+// TestGatewayUnary verifies JSON arguments reach the backend as its own
+// struct type and results come back as JSON.
+func TestGatewayUnary(t *testing.T) {
+	hs := startGateway(t)
+	resp, body := post(t, hs.URL+"/Geo/Add", "", `[{"X":1,"Y":2,"Label":"a"},{"X":10,"Y":20,"Label":"b"}]`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	var p Point
+	if err := json.Unmarshal([]byte(body), &p); err != nil || p != (Point{11, 22, "ab"}) {
+		t.Fatalf("Add = %s (%v)", body, err)
+	}
+}
+
+// TestGatewayErrors verifies status codes map to HTTP codes with a JSON
+// error body.
+func TestGatewayErrors(t *testing.T) {
+	hs := startGateway(t)
+	cases := []struct {
+		path, body string
+		status     int
+		code       string
+	}{
+		{"/Geo/Nope", `{}`, http.StatusNotFound, "NOT_FOUND"},
+		{"/Geo/Add", `{"X":1}`, http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"/Geo/Fail", `7`, http.StatusForbidden, "PERMISSION_DENIED"},
+		{"/Geo/Fail", `14`, http.StatusServiceUnavailable, "UNAVAILABLE"},
+		{"/nomethod", ``, http.StatusBadRequest, "INVALID_ARGUMENT"},
+	}
+	for _, tc := range cases {
+		resp, body := post(t, hs.URL+tc.path, "", tc.body)
+		var st statusJSON
+		if err := json.Unmarshal([]byte(body), &st); err != nil || resp.StatusCode != tc.status || st.Code != tc.code {
+			t.Errorf("%s %s: got %d %s, want %d %s", tc.path, tc.body, resp.StatusCode, body, tc.status, tc.code)
+		}
+	}
+	if resp, err := http.Get(hs.URL + "/Geo/Add"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %v %v", resp.StatusCode, err)
+	}
+}
+
+// TestGatewayStreams verifies server streams are relayed as NDJSON by
+// default and as Server-Sent Events on request.
+func TestGatewayStreams(t *testing.T) {
+	hs := startGateway(t)
+	resp, body := post(t, hs.URL+"/Geo/Path", "", `3`)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("content type %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 || lines[2] != `{"X":2,"Y":4,"Label":""}` {
+		t.Fatalf("NDJSON body:\n%s", body)
+	}
+
+	resp, body = post(t, hs.URL+"/Geo/Path", "text/event-stream", `2`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	if want := "data: {\"X\":0,\"Y\":0,\"Label\":\"\"}\n\ndata: {\"X\":1,\"Y\":1,\"Label\":\"\"}\n\n"; body != want {
+		t.Fatalf("SSE body = %q, want %q", body, want)
+	}
+}
+
+// TestGatewayForwardsHeaders verifies Authorization and the headers named
+// with WithForwardedHeaders reach the backend as metadata, and no others.
+func TestGatewayForwardsHeaders(t *testing.T) {
+	hs := startGateway(t, WithForwardedHeaders("X-Tenant"))
+	cases := []struct{ key, want string }{
+		{"authorization", `"Bearer tok"`},
+		{"x-tenant", `"acme,beta"`},
+		{"cookie", `""`},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(http.MethodPost, hs.URL+"/Geo/Caller", strings.NewReader(`"`+tc.key+`"`))
+		req.Header.Set("Authorization", "Bearer tok")
+		req.Header.Add("X-Tenant", "acme")
+		req.Header.Add("X-Tenant", "beta")
+		req.Header.Set("Cookie", "session=1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := strings.TrimSpace(string(body)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.key, got, tc.want)
+		}
+	}
+}
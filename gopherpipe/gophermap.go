@@ -0,0 +1,213 @@
+package gopherpipe
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GopherMapService is the name the GopherMap registry is registered under
+// by EnableGopherMap.
+const GopherMapService = "gopherpipe.GopherMap"
+
+// GopherMap is a registry of the addresses serving each service. Backends
+// register themselves with a time to live and renew it while they are up,
+// so a backend that goes away without deregistering drops out once its
+// registration expires.
+type GopherMap struct {
+	mu       sync.Mutex
+	services map[string]map[string]time.Time // service → address → expiry
+}
+
+// NewGopherMap returns an empty registry.
+func NewGopherMap() *GopherMap {
+	return &GopherMap{services: make(map[string]map[string]time.Time)}
+}
+
+// EnableGopherMap registers a GopherMap registry on s and returns it, so
+// the process can also register its own services without a round trip.
+// The service has three unary methods:
+//
+//	Register(service, addr string, ttl time.Duration) error
+//	Deregister(service, addr string) error
+//	Lookup(service string) ([]string, error)
+func (s *Server) EnableGopherMap() (*GopherMap, error) {
+	m := NewGopherMap()
+	if err := s.Register(GopherMapService, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Register records that addr serves service for the next ttl, replacing
+// the expiry of an earlier registration.
+func (m *GopherMap) Register(service, addr string, ttl time.Duration) error {
+	if service == "" || addr == "" {
+		return Errorf(InvalidArgument, "gophermap: register needs a service and an address")
+	}
+	if ttl <= 0 {
+		return Errorf(InvalidArgument, "gophermap: register %s: time to live %v is not positive", service, ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addrs := m.services[service]
+	if addrs == nil {
+		addrs = make(map[string]time.Time)
+		m.services[service] = addrs
+	}
+	addrs[addr] = time.Now().Add(ttl)
+	return nil
+}
+
+// Deregister removes addr from service. Removing an address that is not
+// registered is not an error.
+func (m *GopherMap) Deregister(service, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.services[service], addr)
+	if len(m.services[service]) == 0 {
+		delete(m.services, service)
+	}
+	return nil
+}
+
+// Lookup returns the sorted addresses registered for service that have not
+// expired, failing with NotFound if there are none.
+func (m *GopherMap) Lookup(service string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var addrs []string
+	for addr, expiry := range m.services[service] {
+		if now.After(expiry) {
+			delete(m.services[service], addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		delete(m.services, service)
+		return nil, Errorf(NotFound, "gophermap: no addresses for %s", service)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// LookupService asks the GopherMap registry c is connected to for the
+// addresses of service.
+func LookupService(ctx context.Context, c *Client, service string) ([]string, error) {
+	var addrs []string
+	err := c.Call(ctx, GopherMapService, "Lookup", []interface{}{service}, &addrs)
+	return addrs, err
+}
+
+// Announce registers addr as serving each of services with the GopherMap
+// registry c is connected to, and renews the registrations every third of
+// ttl until ctx is done, when it deregisters them. It fails if the first
+// registration does; later failures are logged and retried at the next
+// renewal.
+func Announce(ctx context.Context, c *Client, addr string, ttl time.Duration, services ...string) error {
+	register := func() error {
+		for _, service := range services {
+			if err := c.Call(ctx, GopherMapService, "Register", []interface{}{service, addr, ttl}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := register(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := register(); err != nil && ctx.Err() == nil {
+				log.Printf("gopherpipe: announce %s: %v", addr, err)
+			}
+		case <-ctx.Done():
+			dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, service := range services {
+				if err := c.Call(dctx, GopherMapService, "Deregister", []interface{}{service, addr}); err != nil {
+					log.Printf("gopherpipe: announce %s: deregister %s: %v", addr, service, err)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// GopherMapResolver resolves gophermap://registry:port/ChatService to the
+// addresses registered for ChatService with the GopherMap registry at
+// registry:port, looked up again every Refresh. The registry may be left
+// out of the target, as in gophermap:///ChatService, when Registry is set.
+// Failed lookups after the first keep the previous addresses.
+type GopherMapResolver struct {
+	// Registry is the address of the registry for targets without an
+	// authority.
+	Registry string
+	// Refresh is the time between lookups; it defaults to 10 seconds.
+	Refresh time.Duration
+	// DialOptions are used to dial the registry, for example to set up
+	// TLS.
+	DialOptions []DialOption
+	// Lookup replaces the call to the registry, for tests or to ask
+	// another service directory.
+	Lookup func(ctx context.Context, registry, service string) ([]string, error)
+}
+
+func (r *GopherMapResolver) Resolve(ctx context.Context, target Target, update func([]string)) ([]string, error) {
+	registry := target.Authority
+	if registry == "" {
+		registry = r.Registry
+	}
+	if registry == "" && r.Lookup == nil {
+		return nil, errors.New("no GopherMap registry: name it in the target, as in gophermap://registry:9000/ChatService, or set GopherMapResolver.Registry")
+	}
+	service := target.Endpoint
+	refresh := r.Refresh
+	if refresh <= 0 {
+		refresh = 10 * time.Second
+	}
+	lookup := r.Lookup
+	var reg *Client
+	if lookup == nil {
+		var err error
+		if reg, err = Dial(registry, r.DialOptions...); err != nil {
+			return nil, err
+		}
+		lookup = func(ctx context.Context, _, service string) ([]string, error) {
+			return LookupService(ctx, reg, service)
+		}
+	}
+	resolve := func() ([]string, error) {
+		ctx, cancel := context.WithTimeout(ctx, refresh)
+		defer cancel()
+		addrs, err := lookup(ctx, registry, service)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append([]string(nil), addrs...)
+		sort.Strings(addrs)
+		return addrs, nil
+	}
+	addrs, err := resolve()
+	if err != nil {
+		if reg != nil {
+			reg.Close()
+		}
+		return nil, err
+	}
+	go func() {
+		if reg != nil {
+			defer reg.Close()
+		}
+		poll(ctx, target, refresh, addrs, resolve, update)
+	}()
+	return addrs, nil
+}
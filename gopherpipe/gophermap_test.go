@@ -0,0 +1,105 @@
+package gopherpipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestGopherMapRegistry verifies registrations are listed until they are
+// removed or expire.
+func TestGopherMapRegistry(t *testing.T) {
+	m := NewGopherMap()
+	if _, err := m.Lookup("Svc"); CodeOf(err) != NotFound {
+		t.Fatalf("lookup of an unknown service: %v", err)
+	}
+	if err := m.Register("Svc", "10.0.0.1:9000", 0); CodeOf(err) != InvalidArgument {
+		t.Fatalf("register without a time to live: %v", err)
+	}
+	for _, addr := range []string{"10.0.0.2:9000", "10.0.0.1:9000"} {
+		if err := m.Register("Svc", addr, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Register("Svc", "10.0.0.3:9000", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := m.Lookup("Svc")
+	if want := []string{"10.0.0.1:9000", "10.0.0.2:9000", "10.0.0.3:9000"}; err != nil || !equalStrings(addrs, want) {
+		t.Fatalf("Lookup = %v, %v; want %v", addrs, err, want)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if err := m.Deregister("Svc", "10.0.0.1:9000"); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err = m.Lookup("Svc")
+	if want := []string{"10.0.0.2:9000"}; err != nil || !equalStrings(addrs, want) {
+		t.Fatalf("Lookup = %v, %v; want %v", addrs, err, want)
+	}
+}
+
+// TestGopherMapTarget verifies a gophermap target dials the backends
+// announced to the registry and follows them as they come and go.
+func TestGopherMapTarget(t *testing.T) {
+	registry, _ := startBackends(t, 1, func(srv *Server) {
+		if _, err := srv.EnableGopherMap(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	addrs, _ := startBackends(t, 2, nil)
+	reg, err := Dial(registry[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	announce := func(addr string) (stop func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- Announce(ctx, reg, addr, time.Minute, "Named") }()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			got, _ := LookupService(context.Background(), reg, "Named")
+			if contains(got, addr) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s not registered: %v", addr, got)
+			}
+		}
+		return func() {
+			cancel()
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	stopA := announce(addrs[0])
+	c, err := Dial("gophermap://"+registry[0]+"/Named", WithResolver(&GopherMapResolver{Refresh: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitReady(t, c, 1)
+	if counts := names(t, c, context.Background(), 2); counts["a"] != 2 {
+		t.Fatalf("calls reached %v", counts)
+	}
+
+	stopB := announce(addrs[1])
+	defer stopB()
+	stopA()
+	waitBackends(t, c, addrs[1])
+	waitReady(t, c, 1)
+	if counts := names(t, c, context.Background(), 2); counts["b"] != 2 {
+		t.Fatalf("calls reached %v after the change", counts)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Package gptest provides the runtime support of mocks generated by
+// genstub -mock: call recording, expectations and stubbed streams.
+package gptest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Call is one recorded invocation of a mocked method. Args holds the
+// non-context arguments in declaration order.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// TB is the subset of testing.TB used by Verify.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Recorder records the calls made to a mock and checks them against
+// expectations. The zero value is ready to use and it is safe for
+// concurrent use. Generated mocks embed a Recorder.
+type Recorder struct {
+	mu     sync.Mutex
+	calls  []Call
+	expect map[string]int
+}
+
+// Record appends a call to method with args.
+func (r *Recorder) Record(method string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns every recorded call in order.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns the recorded calls to method in order.
+func (r *Recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var calls []Call
+	for _, c := range r.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Expect declares that method must be called exactly times times by the
+// time Verify runs. Once any expectation is set, calls to methods without
+// one are reported as unexpected.
+func (r *Recorder) Expect(method string, times int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.expect == nil {
+		r.expect = make(map[string]int)
+	}
+	r.expect[method] = times
+}
+
+// Verify reports every unmet expectation and unexpected call to t.
+func (r *Recorder) Verify(t TB) {
+	t.Helper()
+	if err := r.check(); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// check compares the recorded calls with the expectations.
+func (r *Recorder) check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.expect == nil {
+		return nil
+	}
+	got := make(map[string]int)
+	for _, c := range r.calls {
+		got[c.Method]++
+	}
+	var problems []string
+	for method, want := range r.expect {
+		if got[method] != want {
+			problems = append(problems, fmt.Sprintf("%s called %d times, want %d", method, got[method], want))
+		}
+	}
+	for method, n := range got {
+		if _, ok := r.expect[method]; !ok {
+			problems = append(problems, fmt.Sprintf("unexpected %d calls to %s", n, method))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("gptest: %s", strings.Join(problems, "; "))
+}
+
+// Stream returns a closed channel that yields vals, for stubbing the
+// result of a streaming method.
+func Stream[T any](vals ...T) <-chan T {
+	ch := make(chan T, len(vals))
+	for _, v := range vals {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+// Collect receives from ch until it is closed and returns the values.
+func Collect[T any](ch <-chan T) []T {
+	var vals []T
+	for v := range ch {
+		vals = append(vals, v)
+	}
+	return vals
+}
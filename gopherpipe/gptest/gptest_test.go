@@ -0,0 +1,37 @@
+package gptest
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRecorderExpectations verifies Verify reports miscounted and
+// unexpected calls and stays quiet when expectations are met.
+func TestRecorderExpectations(t *testing.T) {
+	var r Recorder
+	if err := r.check(); err != nil {
+		t.Fatalf("no expectations: %v", err)
+	}
+	r.Expect("A", 2)
+	r.Record("A", 1)
+	r.Record("B")
+	err := r.check()
+	if err == nil || !strings.Contains(err.Error(), "A called 1 times, want 2") || !strings.Contains(err.Error(), "unexpected 1 calls to B") {
+		t.Fatalf("check = %v", err)
+	}
+	r.Record("A", 2)
+	r.Expect("B", 1)
+	if err := r.check(); err != nil {
+		t.Fatalf("met expectations: %v", err)
+	}
+	if calls := r.CallsTo("A"); len(calls) != 2 || calls[1].Args[0] != 2 {
+		t.Fatalf("CallsTo(A) = %+v", calls)
+	}
+}
+
+// TestStream verifies stubbed streams yield their values and close.
+func TestStream(t *testing.T) {
+	if got := Collect(Stream(1, 2, 3)); len(got) != 3 || got[2] != 3 {
+		t.Fatalf("Collect(Stream(1, 2, 3)) = %v", got)
+	}
+}
@@ -0,0 +1,238 @@
+package gopherpipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/anthony/gopher-pipe/internal/codec"
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// MethodHandler serves a single call. It decodes the request with
+// call.DecodeArgs, invokes the implementation and answers with
+// call.SendResults (unary and client-streaming methods) or call.Send
+// (server-streaming and bidirectional methods). Returning an error fails
+// the call with that error's status.
+type MethodHandler func(ctx context.Context, call *ServerCall) error
+
+// ServiceDesc describes a service whose handlers are known ahead of time,
+// typically generated by cmd/genstub, so calls are dispatched without
+// reflection.
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc
+}
+
+// MethodDesc describes one method of a ServiceDesc.
+type MethodDesc struct {
+	Name    string
+	RPCType RPCType
+	Handler MethodHandler
+
+	// ArgTypes, ResultTypes and InType optionally describe the wire types
+	// for the reflection service: the request tuple, the reply tuple (or
+	// the element of the result stream) and the element of the caller's
+	// stream. Generated descriptors always set them.
+	ArgTypes    []reflect.Type
+	ResultTypes []reflect.Type
+	InType      reflect.Type
+}
+
+// TypeOf returns the reflect.Type of T, including interface types. It
+// keeps generated descriptors short.
+func TypeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// RegisterServiceDesc registers a service described by desc. It is the
+// entry point used by generated Register<Service>Server functions.
+func (s *Server) RegisterServiceDesc(desc ServiceDesc) error {
+	if desc.Name == "" {
+		return fmt.Errorf("gopherpipe: register: service descriptor has no name")
+	}
+	svc := &service{name: desc.Name, methods: make(map[string]*methodDesc)}
+	for _, m := range desc.Methods {
+		if m.Handler == nil {
+			return fmt.Errorf("gopherpipe: register %s: method %s has no handler", desc.Name, m.Name)
+		}
+		if _, dup := svc.methods[m.Name]; dup {
+			return fmt.Errorf("gopherpipe: register %s: duplicate method %s", desc.Name, m.Name)
+		}
+		svc.methods[m.Name] = &methodDesc{
+			name:        m.Name,
+			rpcType:     m.RPCType,
+			handler:     m.Handler,
+			argTypes:    m.ArgTypes,
+			resultTypes: m.ResultTypes,
+			inIndex:     -1,
+			inType:      m.InType,
+		}
+	}
+	return s.addService(svc)
+}
+
+// ServerCall is the server side of one in-flight call, handed to method
+// handlers.
+type ServerCall struct {
+	ctx     context.Context
+	sc      *serverConn
+	env     Envelope
+	input   chan Envelope // nil unless the caller streams messages
+	replied bool
+
+	// recvErr is the error that ended the channel of RecvStream early.
+	recvMu  sync.Mutex
+	recvErr error
+}
+
+// Service returns the name of the called service.
+func (c *ServerCall) Service() string { return c.env.ServiceName }
+
+// Method returns the name of the called method.
+func (c *ServerCall) Method() string { return c.env.MethodName }
+
+// DecodeArgs decodes the request tuple into ptrs, which must be pointers
+// in parameter order (excluding any context and stream parameter).
+func (c *ServerCall) DecodeArgs(ptrs ...interface{}) error {
+	if err := decodeTuple(c.env.Body, ptrs); err != nil {
+		return Errorf(InvalidArgument, "decode arguments: %v", err)
+	}
+	return nil
+}
+
+// SendResults sends the reply of a unary or client-streaming call, encoded
+// as a tuple of vals. A handler that returns nil without calling
+// SendResults replies with no values.
+func (c *ServerCall) SendResults(vals ...interface{}) error {
+	if c.replied {
+		return Errorf(Internal, "%s/%s: results already sent", c.Service(), c.Method())
+	}
+	b, err := encodeTuple(vals)
+	if err != nil {
+		return Errorf(Internal, "encode results: %v", err)
+	}
+	c.replied = true
+	return c.sc.write(tcplite.FrameTypeData, c.reply(b))
+}
+
+// Send sends one message of a server-streaming or bidirectional call.
+func (c *ServerCall) Send(v interface{}) error {
+	b, err := codec.Encode(v)
+	if err != nil {
+		return Errorf(Internal, "encode stream message: %v", err)
+	}
+	if err := c.sc.write(tcplite.FrameTypeData, c.reply(b)); err != nil {
+		c.sc.cancel()
+		return err
+	}
+	return nil
+}
+
+// Recv decodes the next message streamed by the caller into out. It
+// returns io.EOF once the caller has closed its stream.
+func (c *ServerCall) Recv(out interface{}) error {
+	if c.input == nil {
+		return Errorf(FailedPrecondition, "%s/%s does not take a stream", c.Service(), c.Method())
+	}
+	select {
+	case env, ok := <-c.input:
+		if !ok {
+			return io.EOF
+		}
+		if err := codec.Decode(env.Body, out); err != nil {
+			return Errorf(InvalidArgument, "decode stream message: %v", err)
+		}
+		return nil
+	case <-c.ctx.Done():
+		return contextStatus(c.ctx.Err())
+	}
+}
+
+// RecvErr returns the error that closed the channel of RecvStream before
+// the caller ended its stream, or nil. A call whose handler succeeds
+// despite such an error fails with it, so a message that cannot be
+// decoded is reported to the caller as InvalidArgument rather than
+// silently ending the stream.
+func (c *ServerCall) RecvErr() error {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	return c.recvErr
+}
+
+// recvFailed records err, unless it is the end of the caller's stream,
+// for RecvErr.
+func (c *ServerCall) recvFailed(err error) {
+	if err == io.EOF {
+		return
+	}
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	c.recvErr = err
+}
+
+// reply builds a data envelope answering this call.
+func (c *ServerCall) reply(body []byte) Envelope {
+	return Envelope{RPCType: c.env.RPCType, ServiceName: c.env.ServiceName, MethodName: c.env.MethodName, CallID: c.env.CallID, Body: body}
+}
+
+// finish completes a call whose handler returned successfully.
+func (c *ServerCall) finish() error {
+	switch c.env.RPCType {
+	case ServerStream, BiDi:
+		end := c.reply(nil)
+		end.EndStream = true
+		return c.sc.write(tcplite.FrameTypeData, end)
+	}
+	if c.replied {
+		return nil
+	}
+	return c.SendResults()
+}
+
+// SendStream forwards every value from ch as a stream message until ch is
+// closed. It stops early with a status error if ctx is done.
+func SendStream[T any](ctx context.Context, call *ServerCall, ch <-chan T) error {
+	if ch == nil {
+		return nil
+	}
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := call.Send(v); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return contextStatus(ctx.Err())
+		}
+	}
+}
+
+// RecvStream returns a channel fed with the messages the caller streams.
+// The channel is closed when the caller ends its stream, a message cannot
+// be decoded, or ctx is done; call.RecvErr tells the first case from the
+// others.
+func RecvStream[T any](ctx context.Context, call *ServerCall) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			var v T
+			if err := call.Recv(&v); err != nil {
+				call.recvFailed(err)
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,127 @@
+package gopherpipe
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// counterDesc hand-writes what genstub generates for counter: handlers
+// that decode into concrete types and call the implementation directly.
+func counterDesc(impl *counter) ServiceDesc {
+	return ServiceDesc{
+		Name: "Counter",
+		Methods: []MethodDesc{
+			{Name: "Double", RPCType: Unary, Handler: func(ctx context.Context, call *ServerCall) error {
+				var n int
+				if err := call.DecodeArgs(&n); err != nil {
+					return err
+				}
+				r, err := impl.Double(n)
+				if err != nil {
+					return err
+				}
+				return call.SendResults(r)
+			}},
+			{Name: "Scale", RPCType: BiDi, Handler: func(ctx context.Context, call *ServerCall) error {
+				var factor int
+				if err := call.DecodeArgs(&factor); err != nil {
+					return err
+				}
+				out, err := impl.Scale(ctx, factor, RecvStream[int](ctx, call))
+				if err != nil {
+					return err
+				}
+				return SendStream[int](ctx, call, out)
+			}},
+		},
+	}
+}
+
+// TestRegisterServiceDesc verifies descriptor-registered handlers serve
+// unary and bidirectional calls like reflection-registered methods.
+func TestRegisterServiceDesc(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.RegisterServiceDesc(counterDesc(&counter{})); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	c := startTestServer(t, srv)
+	ctx := context.Background()
+
+	got, err := Invoke[int, int](ctx, c, "Counter/Double", 21)
+	if err != nil || got != 42 {
+		t.Fatalf("Double(21) = %d, %v", got, err)
+	}
+	if _, err := Invoke[string, int](ctx, c, "Counter/Double", "x"); CodeOf(err) != InvalidArgument {
+		t.Fatalf("bad argument: got %v, want InvalidArgument", err)
+	}
+
+	in := make(chan int)
+	s, err := c.NewStream(ctx, "Counter", "Scale", []interface{}{3, (<-chan int)(in)})
+	if err != nil {
+		t.Fatalf("Scale: %v", err)
+	}
+	go func() {
+		defer close(in)
+		for i := 1; i <= 3; i++ {
+			in <- i
+		}
+	}()
+	var sum int
+	for v := range RecvChan[int](ctx, s) {
+		sum += v
+	}
+	if sum != 18 {
+		t.Fatalf("Scale sum = %d, want 18", sum)
+	}
+}
+
+// TestUndecodableStreamMessage verifies a stream message of the wrong type
+// fails the call with InvalidArgument, through both reflection-registered
+// methods and RecvStream, instead of ending the stream as if the caller
+// had.
+func TestUndecodableStreamMessage(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Counter", &counter{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	desc := counterDesc(&counter{})
+	desc.Name = "DescCounter"
+	if err := srv.RegisterServiceDesc(desc); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	c := startTestServer(t, srv)
+	for _, service := range []string{"Counter", "DescCounter"} {
+		in := make(chan string, 1)
+		in <- "x"
+		close(in)
+		s, err := c.NewStream(context.Background(), service, "Scale", []interface{}{3, (<-chan string)(in)})
+		if err != nil {
+			t.Fatalf("%s: %v", service, err)
+		}
+		for err == nil {
+			var v int
+			err = s.Recv(&v)
+		}
+		if err == io.EOF || CodeOf(err) != InvalidArgument {
+			t.Errorf("%s: stream ended with %v, want InvalidArgument", service, err)
+		}
+	}
+}
+
+// TestRegisterServiceDescValidates verifies malformed descriptors are
+// rejected at registration.
+func TestRegisterServiceDescValidates(t *testing.T) {
+	srv := NewServer("")
+	noop := func(context.Context, *ServerCall) error { return nil }
+	cases := []ServiceDesc{
+		{Methods: []MethodDesc{{Name: "A", RPCType: Unary, Handler: noop}}},
+		{Name: "S", Methods: []MethodDesc{{Name: "A", RPCType: Unary}}},
+		{Name: "S", Methods: []MethodDesc{{Name: "A", RPCType: Unary, Handler: noop}, {Name: "A", RPCType: Unary, Handler: noop}}},
+	}
+	for i, desc := range cases {
+		if err := srv.RegisterServiceDesc(desc); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package gopherpipe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// HealthService is the name the health service is registered under by
+// EnableHealth.
+const HealthService = "gopherpipe.Health"
+
+// ServingStatus is the health of a service as reported by the health
+// service.
+type ServingStatus int32
+
+// Serving statuses.
+const (
+	HealthUnknown ServingStatus = iota
+	HealthServing
+	HealthNotServing
+)
+
+var servingStatusNames = map[ServingStatus]string{
+	HealthUnknown:    "UNKNOWN",
+	HealthServing:    "SERVING",
+	HealthNotServing: "NOT_SERVING",
+}
+
+func (s ServingStatus) String() string {
+	if n, ok := servingStatusNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("STATUS(%d)", int32(s))
+}
+
+// HealthServer holds the serving status of each service and notifies
+// watchers of changes. The empty service name stands for the server as a
+// whole and starts out SERVING; other services are unknown until their
+// status is set. It is safe for concurrent use.
+type HealthServer struct {
+	mu       sync.Mutex
+	statuses map[string]ServingStatus
+	watchers map[string]map[chan ServingStatus]struct{}
+	shutdown bool
+}
+
+// NewHealthServer returns a HealthServer reporting the server as SERVING.
+func NewHealthServer() *HealthServer {
+	return &HealthServer{
+		statuses: map[string]ServingStatus{"": HealthServing},
+		watchers: make(map[string]map[chan ServingStatus]struct{}),
+	}
+}
+
+// EnableHealth registers a health service on s and returns it so the
+// application can report status changes, for example NOT_SERVING while
+// warming up or while a dependency is down. The service has two methods:
+//
+//	Check(service string) (ServingStatus, error)
+//	Watch(ctx context.Context, service string) (<-chan ServingStatus, error)
+func (s *Server) EnableHealth() (*HealthServer, error) {
+	h := NewHealthServer()
+	err := s.RegisterServiceDesc(ServiceDesc{
+		Name: HealthService,
+		Methods: []MethodDesc{
+			{
+				Name:        "Check",
+				RPCType:     Unary,
+				Handler:     h.checkHandler,
+				ArgTypes:    []reflect.Type{TypeOf[string]()},
+				ResultTypes: []reflect.Type{TypeOf[ServingStatus]()},
+			},
+			{
+				Name:        "Watch",
+				RPCType:     ServerStream,
+				Handler:     h.watchHandler,
+				ArgTypes:    []reflect.Type{TypeOf[string]()},
+				ResultTypes: []reflect.Type{TypeOf[ServingStatus]()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetServingStatus records the status of service and notifies its
+// watchers. It has no effect after Shutdown until Resume is called.
+func (h *HealthServer) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.shutdown {
+		return
+	}
+	h.set(service, status)
+}
+
+// Shutdown marks every known service NOT_SERVING and ignores further
+// updates, so load balancers stop sending traffic while the server
+// drains.
+func (h *HealthServer) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = true
+	for service := range h.statuses {
+		h.set(service, HealthNotServing)
+	}
+}
+
+// Resume undoes Shutdown, marking every known service SERVING again.
+func (h *HealthServer) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = false
+	for service := range h.statuses {
+		h.set(service, HealthServing)
+	}
+}
+
+// set updates service and notifies its watchers. Each watcher channel
+// holds at most one status, so a slow watcher skips intermediate states
+// but always sees the latest one. h.mu must be held.
+func (h *HealthServer) set(service string, status ServingStatus) {
+	if old, ok := h.statuses[service]; ok && old == status {
+		return
+	}
+	h.statuses[service] = status
+	for ch := range h.watchers[service] {
+		select {
+		case ch <- status:
+		default:
+			// h.mu serialises senders, so after dropping the stale
+			// status the send cannot block.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- status
+		}
+	}
+}
+
+// Check returns the status of service. Services whose status was never
+// set are reported as NotFound.
+func (h *HealthServer) Check(service string) (ServingStatus, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.statuses[service]
+	if !ok {
+		return HealthUnknown, Errorf(NotFound, "unknown service %q", service)
+	}
+	return status, nil
+}
+
+// Watch returns a channel that receives the current status of service and
+// then every change, until ctx is done. Services whose status was never
+// set are reported as UNKNOWN until it is.
+func (h *HealthServer) Watch(ctx context.Context, service string) (<-chan ServingStatus, error) {
+	ch := make(chan ServingStatus, 1)
+	h.mu.Lock()
+	ch <- h.statuses[service]
+	if h.watchers[service] == nil {
+		h.watchers[service] = make(map[chan ServingStatus]struct{})
+	}
+	h.watchers[service][ch] = struct{}{}
+	h.mu.Unlock()
+
+	out := make(chan ServingStatus)
+	go func() {
+		defer close(out)
+		defer func() {
+			h.mu.Lock()
+			delete(h.watchers[service], ch)
+			if len(h.watchers[service]) == 0 {
+				delete(h.watchers, service)
+			}
+			h.mu.Unlock()
+		}()
+		for {
+			select {
+			case status := <-ch:
+				select {
+				case out <- status:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (h *HealthServer) checkHandler(ctx context.Context, call *ServerCall) error {
+	var service string
+	if err := call.DecodeArgs(&service); err != nil {
+		return err
+	}
+	status, err := h.Check(service)
+	if err != nil {
+		return err
+	}
+	return call.SendResults(status)
+}
+
+func (h *HealthServer) watchHandler(ctx context.Context, call *ServerCall) error {
+	var service string
+	if err := call.DecodeArgs(&service); err != nil {
+		return err
+	}
+	ch, err := h.Watch(ctx, service)
+	if err != nil {
+		return err
+	}
+	return SendStream(ctx, call, ch)
+}
+
+// CheckHealth asks a server with the health service enabled for the
+// status of service; the empty name checks the server as a whole.
+func CheckHealth(ctx context.Context, c *Client, service string) (ServingStatus, error) {
+	var status ServingStatus
+	err := c.Call(ctx, HealthService, "Check", []interface{}{service}, &status)
+	return status, err
+}
+
+// WatchHealth streams the status of service from a server with the health
+// service enabled: first the current status, then every change. The
+// channel is closed when ctx is done or the connection fails.
+func WatchHealth(ctx context.Context, c *Client, service string) (<-chan ServingStatus, error) {
+	s, err := c.NewStream(ctx, HealthService, "Watch", []interface{}{service})
+	if err != nil {
+		return nil, err
+	}
+	return RecvChan[ServingStatus](ctx, s), nil
+}
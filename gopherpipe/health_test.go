@@ -0,0 +1,101 @@
+package gopherpipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nextStatus receives one status from ch or fails the test.
+func nextStatus(t *testing.T, ch <-chan ServingStatus) ServingStatus {
+	t.Helper()
+	select {
+	case st, ok := <-ch:
+		if !ok {
+			t.Fatalf("watch closed")
+		}
+		return st
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for a status")
+	}
+	return HealthUnknown
+}
+
+// TestHealthService verifies Check and Watch over the wire as statuses
+// change through startup, an outage and a drain.
+func TestHealthService(t *testing.T) {
+	srv := NewServer("")
+	health, err := srv.EnableHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startTestServer(t, srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if st, err := CheckHealth(ctx, c, ""); err != nil || st != HealthServing {
+		t.Fatalf("Check(\"\") = %v, %v", st, err)
+	}
+	if _, err := CheckHealth(ctx, c, "Chat"); CodeOf(err) != NotFound {
+		t.Fatalf("Check(Chat) before any status: got %v, want NotFound", err)
+	}
+
+	watch, err := WatchHealth(ctx, c, "Chat")
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if st := nextStatus(t, watch); st != HealthUnknown {
+		t.Fatalf("initial Watch status = %v, want UNKNOWN", st)
+	}
+	health.SetServingStatus("Chat", HealthNotServing)
+	if st := nextStatus(t, watch); st != HealthNotServing {
+		t.Fatalf("status = %v, want NOT_SERVING", st)
+	}
+	health.SetServingStatus("Chat", HealthServing)
+	if st := nextStatus(t, watch); st != HealthServing {
+		t.Fatalf("status = %v, want SERVING", st)
+	}
+
+	health.Shutdown()
+	if st := nextStatus(t, watch); st != HealthNotServing {
+		t.Fatalf("status after Shutdown = %v, want NOT_SERVING", st)
+	}
+	health.SetServingStatus("Chat", HealthServing)
+	if st, _ := CheckHealth(ctx, c, "Chat"); st != HealthNotServing {
+		t.Fatalf("SetServingStatus took effect during shutdown: %v", st)
+	}
+	health.Resume()
+	if st := nextStatus(t, watch); st != HealthServing {
+		t.Fatalf("status after Resume = %v, want SERVING", st)
+	}
+}
+
+// TestHealthWatchCoalesces verifies a slow watcher skips intermediate
+// states but ends on the latest one, and that cancelling the watch
+// releases it.
+func TestHealthWatchCoalesces(t *testing.T) {
+	h := NewHealthServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, _ := h.Watch(ctx, "")
+	for i := 0; i < 10; i++ {
+		h.SetServingStatus("", HealthNotServing)
+		h.SetServingStatus("", HealthServing)
+	}
+	h.SetServingStatus("", HealthNotServing)
+	var last ServingStatus
+	for deadline := time.After(time.Second); last != HealthNotServing; {
+		select {
+		case last = <-ch:
+		case <-deadline:
+			t.Fatalf("latest status never delivered, last = %v", last)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.watchers) != 0 {
+		t.Fatalf("watcher not released: %v", h.watchers)
+	}
+}
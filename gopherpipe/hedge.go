@@ -0,0 +1,190 @@
+package gopherpipe
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HedgingPolicy sends a unary call of a read-only method again, to
+// another backend if one is ready, when no attempt has answered within
+// Delay. The first successful reply is returned and the other attempts
+// are cancelled. An attempt failing with one of NonFatalCodes starts the
+// next one at once; any other error ends the call.
+type HedgingPolicy struct {
+	// MaxAttempts counts the first attempt; it defaults to 2.
+	MaxAttempts int
+	// Delay is the time to wait for a reply before the next attempt. Zero
+	// sends every attempt at once.
+	Delay time.Duration
+	// NonFatalCodes defaults to Unavailable alone.
+	NonFatalCodes []Code
+}
+
+// withDefaults fills in the zero fields of p.
+func (p HedgingPolicy) withDefaults() HedgingPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 2
+	}
+	if len(p.NonFatalCodes) == 0 {
+		p.NonFatalCodes = []Code{Unavailable}
+	}
+	return p
+}
+
+func (p *HedgingPolicy) nonFatal(code Code) bool {
+	for _, c := range p.NonFatalCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHedgingPolicy hedges the calls of method of service under p, or of
+// every method of service if method is empty. Only methods marked
+// read-only, with WithReadOnly or the //gopherpipe:readonly contract
+// directive, are hedged, since the server may run every attempt. A
+// hedging policy replaces the retry policy of its methods.
+func WithHedgingPolicy(service, method string, p HedgingPolicy) DialOption {
+	return func(cfg *dialConfig) {
+		if cfg.hedgingPolicies == nil {
+			cfg.hedgingPolicies = make(map[string]*HedgingPolicy)
+		}
+		p := p.withDefaults()
+		cfg.hedgingPolicies[service+"/"+method] = &p
+	}
+}
+
+// WithReadOnly marks methods of service as free of side effects, in
+// addition to those registered with RegisterReadOnly. Read-only methods
+// are idempotent too.
+func WithReadOnly(service string, methods ...string) DialOption {
+	return func(cfg *dialConfig) {
+		if cfg.methodFlags == nil {
+			cfg.methodFlags = make(map[string]methodFlag)
+		}
+		markMethods(cfg.methodFlags, flagReadOnly|flagIdempotent, service, methods)
+	}
+}
+
+// RegisterReadOnly marks methods of service as free of side effects for
+// every client. Clients generated by genstub call it for the contract
+// methods carrying the //gopherpipe:readonly directive.
+func RegisterReadOnly(service string, methods ...string) {
+	methodFlagsMu.Lock()
+	defer methodFlagsMu.Unlock()
+	markMethods(methodFlags, flagReadOnly|flagIdempotent, service, methods)
+}
+
+// hedgingPolicy returns the policy of a read-only method, or nil.
+func (c *Client) hedgingPolicy(service, method string) *HedgingPolicy {
+	p := c.cfg.hedgingPolicies[service+"/"+method]
+	if p == nil {
+		p = c.cfg.hedgingPolicies[service+"/"]
+	}
+	if p == nil || !c.methodIs(flagReadOnly, service, method) {
+		return nil
+	}
+	return p
+}
+
+// triedBackends collects the backends a call's attempts were sent to, so
+// later attempts can go elsewhere.
+type triedBackends struct {
+	mu       sync.Mutex
+	backends []*backend
+}
+
+func (tb *triedBackends) add(b *backend) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.backends = append(tb.backends, b)
+}
+
+func (tb *triedBackends) has(b *backend) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, t := range tb.backends {
+		if t == b {
+			return true
+		}
+	}
+	return false
+}
+
+// attemptResult is the outcome of one hedged attempt.
+type attemptResult struct {
+	body []byte
+	err  error
+}
+
+// hedge runs attempts of a call under p and returns the first successful
+// reply. Attempts after the first need a token from the retry budget and
+// carry the attempt number in the context they receive; the attempts
+// still running when hedge returns are cancelled.
+func (c *Client) hedge(ctx context.Context, p *HedgingPolicy, attempt func(ctx context.Context, tried *triedBackends) ([]byte, error)) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tried := &triedBackends{}
+	results := make(chan attemptResult, p.MaxAttempts)
+	started, running := 0, 0
+	var timer *time.Timer
+	var next <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	// start begins the next attempt and arms the timer of the one after,
+	// reporting false if no attempt is left or the budget is spent.
+	start := func() bool {
+		if started == p.MaxAttempts || started > 0 && !c.retryBudget.spend() {
+			return false
+		}
+		started++
+		running++
+		actx := ctx
+		if started > 1 {
+			actx = AppendToOutgoingContext(ctx, AttemptMetadataKey, strconv.Itoa(started))
+		}
+		go func() {
+			body, err := attempt(actx, tried)
+			results <- attemptResult{body, err}
+		}()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, next = nil, nil
+		if started < p.MaxAttempts {
+			timer = time.NewTimer(p.Delay)
+			next = timer.C
+		}
+		return true
+	}
+	start()
+	var err error
+	for {
+		select {
+		case <-next:
+			if !start() {
+				next = nil
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				return r.body, nil
+			}
+			err = r.err
+			if !p.nonFatal(CodeOf(err)) {
+				return nil, err
+			}
+			if !start() && running == 0 {
+				return nil, err
+			}
+		case <-ctx.Done():
+			return nil, contextStatus(ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gopherpipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// replica answers Get with its name, or blocks until the call is
+// cancelled if slow.
+type replica struct {
+	name      string
+	slow      bool
+	attempts  chan string   // the attempt metadata of each call
+	cancelled chan struct{} // receives once per cancelled call
+}
+
+func (r *replica) Get(ctx context.Context) (string, error) {
+	r.attempts <- IncomingMetadata(ctx).Get(AttemptMetadataKey)
+	if !r.slow {
+		return r.name, nil
+	}
+	<-ctx.Done()
+	r.cancelled <- struct{}{}
+	return "", contextStatus(ctx.Err())
+}
+
+// first picks the first ready backend.
+type first struct{}
+
+func (first) Pick(info *PickInfo, ready []Backend) Backend { return ready[0] }
+
+// startReplicas serves a slow replica and a fast one, in that order.
+func startReplicas(t *testing.T, opts ...DialOption) (*Client, []*replica) {
+	t.Helper()
+	replicas := []*replica{{name: "slow", slow: true}, {name: "fast"}}
+	i := 0
+	addrs, _ := startBackends(t, 2, func(srv *Server) {
+		r := replicas[i]
+		r.attempts, r.cancelled = make(chan string, 10), make(chan struct{}, 10)
+		if err := srv.Register("Replica", r); err != nil {
+			t.Fatal(err)
+		}
+		i++
+	})
+	c, err := DialPool(addrs, append([]DialOption{WithBalancer(first{})}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	waitReady(t, c, 2)
+	return c, replicas
+}
+
+// waitCancelled waits for the slow replica to see its call cancelled.
+func waitCancelled(t *testing.T, r *replica) {
+	t.Helper()
+	select {
+	case <-r.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server call was not cancelled")
+	}
+}
+
+// TestHedging verifies a read-only call is sent to another backend after
+// the hedging delay and the losing attempt is cancelled on its server.
+func TestHedging(t *testing.T) {
+	c, replicas := startReplicas(t,
+		WithHedgingPolicy("Replica", "", HedgingPolicy{Delay: 20 * time.Millisecond}),
+		WithReadOnly("Replica", "Get"))
+	var got string
+	if err := c.Call(context.Background(), "Replica", "Get", nil, &got); err != nil || got != "fast" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if a := <-replicas[0].attempts; a != "" {
+		t.Fatalf("first attempt numbered %q", a)
+	}
+	if a := <-replicas[1].attempts; a != "2" {
+		t.Fatalf("hedged attempt numbered %q", a)
+	}
+	waitCancelled(t, replicas[0])
+}
+
+// TestHedgingOnlyReadOnly verifies methods not marked read-only are not
+// hedged.
+func TestHedgingOnlyReadOnly(t *testing.T) {
+	c, replicas := startReplicas(t,
+		WithHedgingPolicy("Replica", "Get", HedgingPolicy{Delay: time.Millisecond}),
+		WithIdempotent("Replica", "Get"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var got string
+	if err := c.Call(ctx, "Replica", "Get", nil, &got); CodeOf(err) != DeadlineExceeded {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if n := len(replicas[1].attempts); n != 0 {
+		t.Fatalf("fast replica called %d times", n)
+	}
+}
+
+// TestCancelFrame verifies a call abandoned by its caller is cancelled on
+// the server.
+func TestCancelFrame(t *testing.T) {
+	c, replicas := startReplicas(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		var got string
+		errc <- c.Call(ctx, "Replica", "Get", nil, &got)
+	}()
+	<-replicas[0].attempts
+	cancel()
+	if err := <-errc; CodeOf(err) != Canceled {
+		t.Fatalf("Get = %v", err)
+	}
+	waitCancelled(t, replicas[0])
+}
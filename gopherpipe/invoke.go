@@ -0,0 +1,93 @@
+package gopherpipe
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// Invoke is a typed wrapper around Client.Call for single-argument,
+// single-result methods. fullMethod has the form "Service/Method". Using
+// Invoke in hand-written stubs moves request/response type mistakes from
+// runtime decode errors to compile errors.
+func Invoke[Req, Resp any](ctx context.Context, c *Client, fullMethod string, req Req) (Resp, error) {
+	var resp Resp
+	service, method, err := SplitMethod(fullMethod)
+	if err != nil {
+		return resp, err
+	}
+	if err := c.Call(ctx, service, method, []interface{}{req}, &resp); err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp, nil
+}
+
+// InvokeStream starts a server-streaming call and returns a channel of
+// typed messages. The channel is closed when the stream ends, fails or ctx
+// is done; callers that need the terminal status should use
+// Client.NewStream and RecvChanErr.
+func InvokeStream[Req, Resp any](ctx context.Context, c *Client, fullMethod string, req Req) (<-chan Resp, error) {
+	service, method, err := SplitMethod(fullMethod)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.NewStream(ctx, service, method, []interface{}{req})
+	if err != nil {
+		return nil, err
+	}
+	return RecvChan[Resp](ctx, s), nil
+}
+
+// RecvChan pumps the messages of s into a typed channel, which is closed
+// once the stream ends, fails or ctx is done. It drops the status that
+// ended the stream, so a failed stream looks like a finished one; use
+// RecvChanErr, or Stream.Recv, when the difference matters.
+func RecvChan[T any](ctx context.Context, s *Stream) <-chan T {
+	out, _ := RecvChanErr[T](ctx, s)
+	return out
+}
+
+// RecvChanErr is RecvChan that also returns a func reporting why the
+// channel was closed. The func blocks until then and returns nil if the
+// server finished the stream, the status that failed it otherwise, or the
+// status of ctx if it was done first.
+func RecvChanErr[T any](ctx context.Context, s *Stream) (<-chan T, func() error) {
+	out := make(chan T)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		defer close(out)
+		defer s.Close()
+		for {
+			var v T
+			if e := s.Recv(&v); e != nil {
+				if e != io.EOF {
+					err = e
+				}
+				return
+			}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				err = contextStatus(ctx.Err())
+				return
+			}
+		}
+	}()
+	return out, func() error {
+		<-done
+		return err
+	}
+}
+
+// SplitMethod splits a "Service/Method" name, tolerating a leading slash.
+func SplitMethod(fullMethod string) (service, method string, err error) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", Errorf(InvalidArgument, "malformed method name %q, want Service/Method", fullMethod)
+	}
+	return name[:i], name[i+1:], nil
+}
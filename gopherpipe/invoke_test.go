@@ -0,0 +1,335 @@
+package gopherpipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+type counter struct{}
+
+func (c *counter) Double(n int) (int, error) { return 2 * n, nil }
+
+func (c *counter) Count(ctx context.Context, n int) (<-chan int, error) {
+	if n < 0 {
+		return nil, Errorf(InvalidArgument, "negative count %d", n)
+	}
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < n; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *counter) Sleep(d time.Duration) error {
+	time.Sleep(d)
+	return nil
+}
+
+func (c *counter) Sum(ctx context.Context, nums <-chan int) (int, error) {
+	total := 0
+	for n := range nums {
+		total += n
+	}
+	return total, nil
+}
+
+func (c *counter) Scale(ctx context.Context, factor int, in <-chan int) (<-chan int, error) {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for n := range in {
+			select {
+			case out <- n * factor:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func startCounter(t *testing.T) *Client {
+	t.Helper()
+	srv := NewServer("")
+	if err := srv.Register("Counter", &counter{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	return startTestServer(t, srv)
+}
+
+// TestInvokeTyped verifies the generic unary helper and its method name
+// validation.
+func TestInvokeTyped(t *testing.T) {
+	c := startCounter(t)
+	got, err := Invoke[int, int](context.Background(), c, "Counter/Double", 21)
+	if err != nil || got != 42 {
+		t.Fatalf("invoke: %d %v", got, err)
+	}
+	if _, err := Invoke[int, int](context.Background(), c, "Double", 1); CodeOf(err) != InvalidArgument {
+		t.Fatalf("expected InvalidArgument for malformed name, got %v", err)
+	}
+}
+
+// TestInvokeStream verifies server-streaming calls deliver every message
+// in order and that a setup error is returned from the call itself.
+func TestInvokeStream(t *testing.T) {
+	c := startCounter(t)
+	ch, err := InvokeStream[int, int](context.Background(), c, "/Counter/Count", 5)
+	if err != nil {
+		t.Fatalf("invoke stream: %v", err)
+	}
+	var got []int
+	for v := range ch {
+		got = append(got, v)
+	}
+	if len(got) != 5 || got[0] != 0 || got[4] != 4 {
+		t.Fatalf("unexpected stream: %v", got)
+	}
+
+	s, err := c.NewStream(context.Background(), "Counter", "Count", []interface{}{-1})
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	var v int
+	if err := s.Recv(&v); CodeOf(err) != InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+
+	s, err = c.NewStream(context.Background(), "Counter", "Count", []interface{}{-1})
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	out, streamErr := RecvChanErr[int](context.Background(), s)
+	if _, ok := <-out; ok {
+		t.Fatal("expected the failed stream's channel to be closed")
+	}
+	if err := streamErr(); CodeOf(err) != InvalidArgument {
+		t.Fatalf("RecvChanErr: expected InvalidArgument, got %v", err)
+	}
+
+	s, err = c.NewStream(context.Background(), "Counter", "Count", []interface{}{3})
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	out, streamErr = RecvChanErr[int](context.Background(), s)
+	for range out {
+	}
+	if err := streamErr(); err != nil {
+		t.Fatalf("RecvChanErr after a finished stream: %v", err)
+	}
+
+	s, err = c.NewStream(context.Background(), "Counter", "Count", []interface{}{0})
+	if err != nil {
+		t.Fatalf("new stream: %v", err)
+	}
+	if err := s.Recv(&v); err != io.EOF {
+		t.Fatalf("expected io.EOF for empty stream, got %v", err)
+	}
+}
+
+// TestConcurrentCalls verifies calls are multiplexed on one connection: a
+// slow call does not hold up faster ones and a deadline fails only the
+// call it belongs to.
+func TestConcurrentCalls(t *testing.T) {
+	c := startCounter(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "Counter", "Sleep", []interface{}{time.Second}); CodeOf(err) != DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got, err := Invoke[int, int](context.Background(), c, "Counter/Double", i)
+			if err != nil || got != 2*i {
+				t.Errorf("double %d: %d %v", i, got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestClientAndBidiStreams verifies channel arguments are streamed to the
+// server for client-streaming and bidirectional methods.
+func TestClientAndBidiStreams(t *testing.T) {
+	c := startCounter(t)
+	nums := make(chan int)
+	go func() {
+		defer close(nums)
+		for i := 1; i <= 4; i++ {
+			nums <- i
+		}
+	}()
+	var total int
+	if err := c.Call(context.Background(), "Counter", "Sum", []interface{}{(<-chan int)(nums)}, &total); err != nil || total != 10 {
+		t.Fatalf("sum: %d %v", total, err)
+	}
+
+	in := make(chan int)
+	s, err := c.NewStream(context.Background(), "Counter", "Scale", []interface{}{3, (<-chan int)(in)})
+	if err != nil {
+		t.Fatalf("scale: %v", err)
+	}
+	out := RecvChan[int](context.Background(), s)
+	for i := 1; i <= 3; i++ {
+		in <- i
+		if got := <-out; got != 3*i {
+			t.Fatalf("scale %d: got %d", i, got)
+		}
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Fatal("expected output stream to end after input closed")
+	}
+}
+
+// staller never reads its input stream.
+type staller struct{}
+
+func (staller) Stall(ctx context.Context, nums <-chan int) error {
+	<-ctx.Done()
+	return nil
+}
+
+// TestStalledStreamDoesNotBlockConnection verifies a handler that stops
+// reading its input neither stalls the other calls on its connection nor
+// queues without bound.
+func TestStalledStreamDoesNotBlockConnection(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Counter", &counter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Register("Staller", staller{}); err != nil {
+		t.Fatal(err)
+	}
+	c := startTestServer(t, srv)
+	nums := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case nums <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(context.Background(), "Staller", "Stall", []interface{}{(<-chan int)(nums)})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		if got, err := Invoke[int, int](ctx, c, "Counter/Double", i); err != nil || got != 2*i {
+			t.Fatalf("double %d: %d %v", i, got, err)
+		}
+	}
+	select {
+	case err := <-errc:
+		if CodeOf(err) != ResourceExhausted {
+			t.Fatalf("stall: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("the stalled call was not failed")
+	}
+}
+
+// TestStalledConsumerDoesNotBlockConnection verifies a stream whose
+// consumer stops reading fails on its own with ResourceExhausted while a
+// unary call on the same connection still completes.
+func TestStalledConsumerDoesNotBlockConnection(t *testing.T) {
+	c := startCounter(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := c.NewStream(ctx, "Counter", "Count", []interface{}{10 * maxStreamQueue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// let the stream fill its queue before anything reads it
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if got, err := Invoke[int, int](ctx, c, "Counter/Double", i); err != nil || got != 2*i {
+			t.Fatalf("double %d: %d %v", i, got, err)
+		}
+	}
+	n := 0
+	for {
+		var v int
+		if err = s.Recv(&v); err != nil {
+			break
+		}
+		n++
+	}
+	if CodeOf(err) != ResourceExhausted || n > maxStreamQueue {
+		t.Fatalf("stream ended with %v after %d messages", err, n)
+	}
+}
+
+// TestUnencodableStreamValue verifies a client-stream value that cannot be
+// encoded fails the call instead of being skipped.
+func TestUnencodableStreamValue(t *testing.T) {
+	c := startCounter(t)
+	in := make(chan interface{}, 3)
+	in <- 1
+	in <- func() {}
+	in <- 2
+	close(in)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var sum int
+	err := c.Call(ctx, "Counter", "Sum", []interface{}{(<-chan interface{})(in)}, &sum)
+	if CodeOf(err) != Internal {
+		t.Fatalf("Sum = %d, %v; want Internal", sum, err)
+	}
+}
+
+// TestUndecodableReply verifies a reply that cannot be decoded fails the
+// call waiting on it with Internal instead of leaving it waiting.
+func TestUndecodableReply(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := tcplite.ReadFrame(conn); err != nil {
+			return
+		}
+		_ = tcplite.WriteFrame(conn, tcplite.FrameTypeData, []byte("not an envelope"))
+		io.Copy(io.Discard, conn)
+	}()
+	c, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Invoke[int, int](ctx, c, "Counter/Double", 1); CodeOf(err) != Internal {
+		t.Fatalf("call = %v, want Internal", err)
+	}
+}
@@ -0,0 +1,195 @@
+package gopherpipe
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// KeepaliveParams make one side of a connection ping the other when the
+// connection has been quiet, so a peer that vanished without closing it
+// (a crashed host, a NAT that dropped the mapping) is noticed and the
+// connection torn down instead of hanging its calls.
+type KeepaliveParams struct {
+	// Time is how long the connection may go without receiving a frame
+	// before a PING is sent. Zero disables keepalive.
+	Time time.Duration
+	// Timeout is how long to wait for any frame after a PING before the
+	// connection is considered dead; it defaults to 20s.
+	Timeout time.Duration
+	// PermitWithoutCalls sends pings even when no call is in flight.
+	PermitWithoutCalls bool
+}
+
+// KeepaliveEnforcement is a server's policy on client pings. A client
+// that breaks it more than twice in a row is sent a Close frame reading
+// "too many pings" and disconnected.
+type KeepaliveEnforcement struct {
+	// MinTime is the shortest interval allowed between two client pings.
+	MinTime time.Duration
+	// PermitWithoutCalls allows pings while the client has no call in
+	// flight.
+	PermitWithoutCalls bool
+}
+
+// defaultKeepaliveTimeout is used when KeepaliveParams.Timeout is zero.
+const defaultKeepaliveTimeout = 20 * time.Second
+
+// maxPingStrikes is the number of bad pings tolerated in a row.
+const maxPingStrikes = 2
+
+// WithKeepalive makes the server ping its clients.
+func WithKeepalive(p KeepaliveParams) ServerOption {
+	return func(s *Server) {
+		s.keepalive = p
+	}
+}
+
+// WithKeepaliveEnforcement disconnects clients that ping more often than
+// policy allows. Without it, every ping is answered.
+func WithKeepaliveEnforcement(policy KeepaliveEnforcement) ServerOption {
+	return func(s *Server) {
+		s.pingPolicy = &policy
+	}
+}
+
+// WithClientKeepalive makes the client ping the server. The server's
+// enforcement policy, if any, must allow p.
+func WithClientKeepalive(p KeepaliveParams) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.keepalive = p
+	}
+}
+
+// keepalive pings one side of a connection on behalf of its owner.
+type keepalive struct {
+	params KeepaliveParams
+	busy   func() bool             // whether calls are in flight
+	ping   func(data []byte) error // writes a heartbeat frame
+	dead   func()                  // tears the connection down
+
+	lastRead int64 // UnixNano of the last frame received, accessed atomically
+	rtt      int64 // round-trip time of the last ACK, accessed atomically
+	acked    chan struct{}
+}
+
+func newKeepalive(p KeepaliveParams, busy func() bool, ping func([]byte) error, dead func()) *keepalive {
+	if p.Timeout <= 0 {
+		p.Timeout = defaultKeepaliveTimeout
+	}
+	return &keepalive{params: p, busy: busy, ping: ping, dead: dead, lastRead: time.Now().UnixNano(), acked: make(chan struct{}, 1)}
+}
+
+// received records that a frame arrived.
+func (k *keepalive) received() {
+	atomic.StoreInt64(&k.lastRead, time.Now().UnixNano())
+}
+
+// heartbeat handles a heartbeat frame, answering a PING and timing an
+// ACK. It reports the kind of a valid payload.
+func (k *keepalive) heartbeat(payload []byte) (byte, bool) {
+	kind, data, ok := tcplite.DecodeHeartbeat(payload)
+	if !ok {
+		return 0, false
+	}
+	switch kind {
+	case tcplite.HeartbeatPing:
+		_ = k.ping(tcplite.EncodeHeartbeat(tcplite.HeartbeatAck, data))
+	case tcplite.HeartbeatAck:
+		if rtt := time.Now().UnixNano() - int64(data); rtt > 0 {
+			atomic.StoreInt64(&k.rtt, rtt)
+		}
+		select {
+		case k.acked <- struct{}{}:
+		default:
+		}
+	}
+	return kind, true
+}
+
+// RTT returns the round-trip time measured by the last acknowledged ping,
+// or 0 before any.
+func (k *keepalive) RTT() time.Duration {
+	if k == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&k.rtt))
+}
+
+// run pings the peer until done is closed, calling dead if a ping goes
+// unanswered for the timeout.
+func (k *keepalive) run(done <-chan struct{}) {
+	t := time.NewTimer(k.params.Time)
+	defer t.Stop()
+	wait := func() bool {
+		select {
+		case <-t.C:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for wait() {
+		quiet := time.Since(time.Unix(0, atomic.LoadInt64(&k.lastRead)))
+		if quiet < k.params.Time {
+			t.Reset(k.params.Time - quiet)
+			continue
+		}
+		if !k.params.PermitWithoutCalls && !k.busy() {
+			t.Reset(k.params.Time)
+			continue
+		}
+		select {
+		case <-k.acked: // a late ACK of an earlier ping
+		default:
+		}
+		sent := time.Now()
+		// A write stuck behind a full send buffer must not stop the
+		// timeout, so the PING is written concurrently.
+		go func() {
+			if k.ping(tcplite.EncodeHeartbeat(tcplite.HeartbeatPing, uint64(sent.UnixNano()))) != nil {
+				k.dead()
+			}
+		}()
+		t.Reset(k.params.Timeout)
+		select {
+		case <-k.acked:
+			if !t.Stop() {
+				<-t.C
+			}
+			t.Reset(k.params.Time)
+		case <-t.C:
+			// other frames show the peer is alive too
+			if atomic.LoadInt64(&k.lastRead) < sent.UnixNano() {
+				k.dead()
+				return
+			}
+			t.Reset(0)
+		case <-done:
+			return
+		}
+	}
+}
+
+// pingEnforcer applies a KeepaliveEnforcement to the pings received on
+// one connection. It is only used by the connection's read loop.
+type pingEnforcer struct {
+	policy  KeepaliveEnforcement
+	last    time.Time
+	strikes int
+}
+
+// allow records a ping and reports whether the client may keep its
+// connection.
+func (e *pingEnforcer) allow(busy bool) bool {
+	now := time.Now()
+	bad := (!busy && !e.policy.PermitWithoutCalls) || (!e.last.IsZero() && now.Sub(e.last) < e.policy.MinTime)
+	e.last = now
+	if !bad {
+		e.strikes = 0
+		return true
+	}
+	e.strikes++
+	return e.strikes <= maxPingStrikes
+}
@@ -0,0 +1,138 @@
+package gopherpipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// TestClientKeepaliveDeadServer verifies a call to a server that stopped
+// answering fails once a keepalive ping goes unanswered.
+func TestClientKeepaliveDeadServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// read everything, answer nothing: a half-open connection
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	c, err := Dial(ln.Addr().String(), WithClientKeepalive(KeepaliveParams{Time: 50 * time.Millisecond, Timeout: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = c.Call(ctx, "Sleeper", "Sleep", []interface{}{time.Duration(0)})
+	if CodeOf(err) != Unavailable || !strings.Contains(err.Error(), "keepalive timeout") {
+		t.Fatalf("call to dead server: %v", err)
+	}
+}
+
+// TestServerKeepaliveDeadClient verifies the server pings a quiet client
+// and closes the connection when the ping goes unanswered.
+func TestServerKeepaliveDeadClient(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithKeepalive(KeepaliveParams{Time: 50 * time.Millisecond, Timeout: 100 * time.Millisecond, PermitWithoutCalls: true})))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	ftype, payload, err := tcplite.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, ok := tcplite.DecodeHeartbeat(payload); ftype != tcplite.FrameTypeHeartbeat || !ok || kind != tcplite.HeartbeatPing {
+		t.Fatalf("got frame %d %x, want a PING", ftype, payload)
+	}
+	if _, _, err := tcplite.ReadFrame(conn); err != io.EOF {
+		t.Fatalf("read after unanswered ping: %v", err)
+	}
+}
+
+type rtt struct{}
+
+// RTT reports the server's view of the round-trip time to the caller.
+func (rtt) RTT(ctx context.Context) (time.Duration, error) {
+	p, _ := PeerFromContext(ctx)
+	return p.RTT(), nil
+}
+
+// TestKeepaliveRTT verifies both sides measure the round-trip time.
+func TestKeepaliveRTT(t *testing.T) {
+	params := KeepaliveParams{Time: 20 * time.Millisecond, PermitWithoutCalls: true}
+	srv := NewServer("", WithKeepalive(params))
+	if err := srv.Register("RTT", rtt{}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serveLimited(t, srv)
+	c, err := Dial(addr, WithClientKeepalive(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var server time.Duration
+	// calls keep the connection busy, so leave gaps for pings
+	for deadline := time.Now().Add(5 * time.Second); c.RTT() == 0 || server == 0; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("RTT not measured: client %v, server %v", c.RTT(), server)
+		}
+		if err := c.Call(context.Background(), "RTT", "RTT", nil, &server); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestKeepaliveEnforcement verifies a client pinging more often than the
+// server allows is disconnected, and one within the policy is not.
+func TestKeepaliveEnforcement(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithKeepaliveEnforcement(KeepaliveEnforcement{MinTime: 100 * time.Millisecond, PermitWithoutCalls: true})))
+	polite, err := Dial(addr, WithClientKeepalive(KeepaliveParams{Time: 150 * time.Millisecond, PermitWithoutCalls: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer polite.Close()
+	eager, err := Dial(addr, WithClientKeepalive(KeepaliveParams{Time: 10 * time.Millisecond, PermitWithoutCalls: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eager.Close()
+
+	waitState(t, eager, StateIdle)
+	if err := eager.backends[0].sc.lastError(); CodeOf(err) != Unavailable || !strings.Contains(err.Error(), "too many pings") {
+		t.Fatalf("eager client disconnected with %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if err := polite.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)}); err != nil {
+		t.Fatalf("polite client: %v", err)
+	}
+	if polite.RTT() == 0 {
+		t.Error("polite client's pings were not acknowledged")
+	}
+}
+
+// TestPingsDoNotPreventIdleTimeout verifies keepalive pings do not hold
+// an idle connection open.
+func TestPingsDoNotPreventIdleTimeout(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithConnLimits(ConnLimits{IdleTimeout: 200 * time.Millisecond})))
+	c, err := Dial(addr, WithClientKeepalive(KeepaliveParams{Time: 20 * time.Millisecond, PermitWithoutCalls: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitState(t, c, StateIdle)
+}
@@ -0,0 +1,72 @@
+package gopherpipe
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// ConnLimits protect a server from slow, idle and excessive connections.
+// Zero fields disable the corresponding limit. The timeouts apply to the
+// HTTP connections of WithHTTPHandler as well.
+type ConnLimits struct {
+	// IdleTimeout closes connections that have had no call in flight for
+	// this long, including new connections that never send anything.
+	IdleTimeout time.Duration
+	// HeaderTimeout is the time the rest of a frame header may take once
+	// its first byte has arrived, and PayloadTimeout the time its payload
+	// may take after that, so clients cannot trickle frames in.
+	HeaderTimeout  time.Duration
+	PayloadTimeout time.Duration
+	// MaxFrameSize caps the payload of a frame; it defaults to 10 MiB.
+	MaxFrameSize int
+	// MaxConns and MaxConnsPerIP cap the number of open connections, in
+	// total and per remote IP address. Connections over a limit are
+	// closed as soon as they are accepted.
+	MaxConns      int
+	MaxConnsPerIP int
+	// PayloadBudget caps the memory, in bytes, of frame payloads being
+	// received across all connections, including the stream messages
+	// queued for calls that have not read them yet. A connection waits up
+	// to PayloadTimeout for room before reading a payload and is closed
+	// if none frees up.
+	PayloadBudget int64
+	// MaxConcurrentCalls caps the calls in progress on one connection.
+	// Calls over the limit fail with ResourceExhausted.
+	MaxConcurrentCalls int
+}
+
+// WithConnLimits applies l to the server's connections.
+func WithConnLimits(l ConnLimits) ServerOption {
+	return func(s *Server) {
+		s.connLimits = l
+		s.frameLimits = l.frameLimits()
+	}
+}
+
+// httpServer returns a server for h bounded by the timeouts of l: the
+// request headers must arrive within HeaderTimeout and the body within
+// PayloadTimeout after them, and idle keep-alive connections are closed
+// after IdleTimeout.
+func (l ConnLimits) httpServer(h http.Handler) *http.Server {
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: l.HeaderTimeout, IdleTimeout: l.IdleTimeout}
+	if l.PayloadTimeout > 0 {
+		srv.ReadTimeout = l.HeaderTimeout + l.PayloadTimeout
+	}
+	return srv
+}
+
+// frameLimits returns the limits of reading frames from one connection.
+func (l ConnLimits) frameLimits() tcplite.Limits {
+	fl := tcplite.Limits{
+		IdleTimeout:    l.IdleTimeout,
+		HeaderTimeout:  l.HeaderTimeout,
+		PayloadTimeout: l.PayloadTimeout,
+		MaxFrameSize:   l.MaxFrameSize,
+	}
+	if l.PayloadBudget > 0 {
+		fl.Budget = tcplite.NewBudget(l.PayloadBudget)
+	}
+	return fl
+}
@@ -0,0 +1,186 @@
+package gopherpipe
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+type sleeper struct{}
+
+func (sleeper) Sleep(d time.Duration) error {
+	time.Sleep(d)
+	return nil
+}
+
+// serveLimited serves a sleeper on srv and returns its address.
+func serveLimited(t *testing.T, srv *Server) string {
+	t.Helper()
+	if err := srv.Register("Sleeper", sleeper{}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.ServeListener(ln) }()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// TestIdleTimeout verifies idle connections are closed while ones with a
+// call in flight are kept, and that the client reconnects afterwards.
+func TestIdleTimeout(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithConnLimits(ConnLimits{IdleTimeout: 100 * time.Millisecond})))
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{300 * time.Millisecond}); err != nil {
+		t.Fatalf("call longer than the idle timeout: %v", err)
+	}
+	waitState(t, c, StateIdle)
+	if err := c.backends[0].sc.lastError(); CodeOf(err) != Unavailable || !strings.Contains(err.Error(), "closed by server") {
+		t.Fatalf("idle connection closed with %v", err)
+	}
+	// the next call reconnects
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)}); err != nil {
+		t.Fatalf("call after idle timeout: %v", err)
+	}
+}
+
+// TestSlowHeaderClosed verifies a client trickling a frame header is
+// disconnected.
+func TestSlowHeaderClosed(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithConnLimits(ConnLimits{HeaderTimeout: 50 * time.Millisecond})))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{tcplite.FrameTypeData, 0})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("read after partial header: %v after %v", err, time.Since(start))
+	}
+}
+
+// TestSlowHTTPHeaderClosed verifies the header timeout applies to the
+// connections of the HTTP handler too.
+func TestSlowHTTPHeaderClosed(t *testing.T) {
+	addr := serveLimited(t, NewServer("",
+		WithConnLimits(ConnLimits{HeaderTimeout: 50 * time.Millisecond}),
+		WithHTTPHandler(http.NotFoundHandler())))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("read after partial request: %v after %v", err, time.Since(start))
+	}
+}
+
+// TestQueuedStreamMessagesHoldBudget verifies stream messages a handler
+// has not read keep their payloads charged to the PayloadBudget until the
+// call ends.
+func TestQueuedStreamMessagesHoldBudget(t *testing.T) {
+	srv := NewServer("", WithConnLimits(ConnLimits{PayloadBudget: 1 << 20}))
+	if err := srv.Register("Staller", staller{}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Dial(serveLimited(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	budget := srv.frameLimits.Budget
+	nums := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		nums <- i
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(ctx, "Staller", "Stall", []interface{}{(<-chan int)(nums)})
+	}()
+	waitUsed := func(ok func(int64) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !ok(budget.Used()); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("budget in use: %d bytes", budget.Used())
+			}
+		}
+	}
+	// the handler takes none of them, so all ten stay charged
+	waitUsed(func(n int64) bool { return n > 0 })
+	time.Sleep(50 * time.Millisecond)
+	if budget.Used() == 0 {
+		t.Fatal("queued messages were released before the handler read them")
+	}
+	cancel()
+	<-errc
+	waitUsed(func(n int64) bool { return n == 0 })
+}
+
+// TestMaxConcurrentCalls verifies calls over the per-connection limit
+// fail while those under it run.
+func TestMaxConcurrentCalls(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithConnLimits(ConnLimits{MaxConcurrentCalls: 1})))
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{300 * time.Millisecond})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)}); CodeOf(err) != ResourceExhausted {
+		t.Fatalf("call over the limit: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("call under the limit: %v", err)
+	}
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)}); err != nil {
+		t.Fatalf("call after the first ended: %v", err)
+	}
+}
+
+// TestMaxConnsPerIP verifies connections over the per-IP limit are
+// refused.
+func TestMaxConnsPerIP(t *testing.T) {
+	addr := serveLimited(t, NewServer("", WithConnLimits(ConnLimits{MaxConnsPerIP: 1})))
+	call := func(c *Client) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return c.Call(ctx, "Sleeper", "Sleep", []interface{}{time.Duration(0)})
+	}
+	first, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := call(first); err != nil {
+		t.Fatal(err)
+	}
+	second, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := call(second); CodeOf(err) != Unavailable {
+		t.Fatalf("call over the limit: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package gopherpipe
+
+import (
+	"context"
+	"net"
+)
+
+// DialLoopback returns a client connected to srv through an in-memory
+// pipe. Calls take the full path of a networked call — framing, gob
+// encoding and dispatch — so serialization bugs surface in unit tests
+// without opening a socket. Closing the client ends the server side of
+// the connection.
+func DialLoopback(srv *Server) *Client {
+	c := newClient(func(string) func(ctx context.Context) (net.Conn, error) {
+		return func(ctx context.Context) (net.Conn, error) {
+			clientEnd, serverEnd := net.Pipe()
+			go srv.handleConn(serverEnd)
+			return clientEnd, nil
+		}
+	}, &dialConfig{})
+	c.setAddresses([]string{"loopback"})
+	_ = c.awaitFirstAttempts() // cannot fail
+	return c
+}
@@ -0,0 +1,25 @@
+package gopherpipe
+
+import (
+	"context"
+	"testing"
+)
+
+// TestDialLoopback verifies calls over the in-memory pipe and that closing
+// the client does not leave calls hanging.
+func TestDialLoopback(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Counter", &counter{}); err != nil {
+		t.Fatal(err)
+	}
+	c := DialLoopback(srv)
+	ctx := context.Background()
+	got, err := Invoke[int, int](ctx, c, "Counter/Double", 4)
+	if err != nil || got != 8 {
+		t.Fatalf("Double(4) = %d, %v", got, err)
+	}
+	c.Close()
+	if _, err := Invoke[int, int](ctx, c, "Counter/Double", 4); err == nil {
+		t.Fatalf("call on closed loopback client succeeded")
+	}
+}
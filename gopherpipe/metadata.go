@@ -0,0 +1,83 @@
+package gopherpipe
+
+import (
+	"context"
+	"strings"
+)
+
+// Metadata is call metadata: string keys, case-insensitive and stored in
+// lower case, each with one or more values. Clients attach it to the
+// outgoing context; it travels in the request envelope and handlers read
+// it from their context with IncomingMetadata.
+type Metadata map[string][]string
+
+// Pairs builds Metadata from alternating keys and values. A trailing key
+// without a value is ignored.
+func Pairs(kv ...string) Metadata {
+	md := make(Metadata, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md.Append(kv[i], kv[i+1])
+	}
+	return md
+}
+
+// Get returns the first value of key, or "".
+func (md Metadata) Get(key string) string {
+	if v := md[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set replaces the values of key.
+func (md Metadata) Set(key string, vals ...string) {
+	md[strings.ToLower(key)] = vals
+}
+
+// Append adds values to key.
+func (md Metadata) Append(key string, vals ...string) {
+	k := strings.ToLower(key)
+	md[k] = append(md[k], vals...)
+}
+
+// Copy returns a deep copy of md.
+func (md Metadata) Copy() Metadata {
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+
+// NewOutgoingContext returns a context carrying md as the metadata of
+// calls made with it, replacing any metadata already attached.
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a context whose outgoing metadata is
+// that of ctx plus the alternating keys and values in kv.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md := OutgoingMetadata(ctx).Copy()
+	for k, v := range Pairs(kv...) {
+		md.Append(k, v...)
+	}
+	return NewOutgoingContext(ctx, md)
+}
+
+// OutgoingMetadata returns the metadata attached to ctx for outgoing
+// calls, or nil.
+func OutgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingKey{}).(Metadata)
+	return md
+}
+
+// IncomingMetadata returns the metadata the caller sent, from the context
+// of a method handler, or nil.
+func IncomingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingKey{}).(Metadata)
+	return md
+}
@@ -0,0 +1,49 @@
+package gopherpipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type probe struct{}
+
+// Inspect reports the caller's "trace" metadata and whether the call has
+// a deadline.
+func (probe) Inspect(ctx context.Context) (string, bool, error) {
+	_, ok := ctx.Deadline()
+	return IncomingMetadata(ctx).Get("Trace"), ok, nil
+}
+
+// TestMetadataAndTimeoutPropagate verifies outgoing metadata and the
+// context deadline reach the handler's context.
+func TestMetadataAndTimeoutPropagate(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Probe", probe{}); err != nil {
+		t.Fatal(err)
+	}
+	c := DialLoopback(srv)
+	defer c.Close()
+
+	var trace string
+	var hasDeadline bool
+	if err := c.Call(context.Background(), "Probe", "Inspect", nil, &trace, &hasDeadline); err != nil {
+		t.Fatal(err)
+	}
+	if trace != "" || hasDeadline {
+		t.Fatalf("plain call saw trace %q, deadline %v", trace, hasDeadline)
+	}
+
+	ctx := AppendToOutgoingContext(context.Background(), "TRACE", "abc", "trace", "def")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := c.Call(ctx, "Probe", "Inspect", nil, &trace, &hasDeadline); err != nil {
+		t.Fatal(err)
+	}
+	if trace != "abc" || !hasDeadline {
+		t.Fatalf("got trace %q, deadline %v", trace, hasDeadline)
+	}
+	if md := OutgoingMetadata(ctx); len(md["trace"]) != 2 {
+		t.Fatalf("outgoing metadata = %v", md)
+	}
+}
@@ -0,0 +1,353 @@
+package gopherpipe
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+)
+
+// backend is a pooled connection to one address.
+type backend struct {
+	sc          *subConn
+	outstanding int64 // accessed atomically
+
+	// The fields below are guarded by the client's mu.
+	state  ConnState
+	t      *transport    // the connection while READY
+	err    error         // why the last connection or attempt failed
+	health ServingStatus // UNKNOWN until the health service reports
+}
+
+func (b *backend) Addr() string     { return b.sc.addr }
+func (b *backend) Outstanding() int { return int(atomic.LoadInt64(&b.outstanding)) }
+
+// begin counts a call in flight and returns the function ending it.
+func (b *backend) begin() func() {
+	atomic.AddInt64(&b.outstanding, 1)
+	var once int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&once, 0, 1) {
+			atomic.AddInt64(&b.outstanding, -1)
+		}
+	}
+}
+
+// WithHealthCheck ejects backends whose health service (see EnableHealth)
+// does not report service as SERVING, until it does again. Backends
+// without the health service are assumed healthy.
+func WithHealthCheck(service string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.healthCheck = &service
+	}
+}
+
+// DialPool connects to every address in addrs and returns a Client that
+// spreads its calls across them with the Balancer given by WithBalancer.
+// Each address keeps its own connection, reconnected on failure. DialPool
+// fails only if no address could be reached. It is Dial of a static:///
+// target.
+func DialPool(addrs []string, opts ...DialOption) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("gopherpipe: DialPool: no addresses")
+	}
+	cfg := newDialConfig(opts)
+	c := newClient(cfg.dialFunc, &cfg)
+	c.setAddresses(addrs)
+	if err := c.awaitFirstAttempts(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// setAddresses makes addrs the client's backends, connecting to new ones
+// and closing the ones no longer listed.
+func (c *Client) setAddresses(addrs []string) {
+	c.mu.Lock()
+	if c.state == StateShutdown {
+		c.mu.Unlock()
+		return
+	}
+	old := make(map[string]*backend, len(c.backends))
+	for _, b := range c.backends {
+		old[b.sc.addr] = b
+	}
+	var added []*backend
+	backends := make([]*backend, 0, len(addrs))
+	for _, addr := range addrs {
+		if b, ok := old[addr]; ok {
+			backends = append(backends, b)
+			delete(old, addr)
+			continue
+		}
+		if c.hasBackend(backends, addr) {
+			continue
+		}
+		b := &backend{state: StateIdle}
+		b.sc = newSubConn(addr, c.dial(addr), &c.cfg, func(state ConnState, t *transport, err error) {
+			c.subConnChanged(b, state, t, err)
+		})
+		backends = append(backends, b)
+		added = append(added, b)
+	}
+	c.backends = backends
+	c.updateState()
+	c.mu.Unlock()
+
+	for _, b := range old {
+		b.sc.close()
+	}
+	for _, b := range added {
+		b.sc.connect()
+	}
+}
+
+func (c *Client) hasBackend(backends []*backend, addr string) bool {
+	for _, b := range backends {
+		if b.sc.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitFirstAttempts waits until a backend has connected or every backend
+// has failed to, returning the first failure in that case. A backend that
+// connected and was dropped again leaves the client IDLE, which counts as
+// connected: the next call reconnects.
+func (c *Client) awaitFirstAttempts() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*c.cfg.backoff.withDefaults().ConnectTimeout)
+	defer cancel()
+	for {
+		c.mu.Lock()
+		state, changed := c.state, c.changed
+		var err error
+		for _, b := range c.backends {
+			if err = b.err; err != nil {
+				break
+			}
+		}
+		c.mu.Unlock()
+		switch {
+		case state == StateReady || state == StateIdle:
+			return nil
+		case state == StateTransientFailure && err != nil:
+			return err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return &Status{Code: Unavailable, Message: "timed out waiting for a backend to become ready"}
+		}
+	}
+}
+
+// subConnChanged records the state of b. It is called with b.sc.mu held.
+func (c *Client) subConnChanged(b *backend, state ConnState, t *transport, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.state, b.t, b.err = state, t, err
+	b.health = HealthUnknown
+	if state == StateReady {
+		if c.cfg.healthCheck == nil {
+			b.health = HealthServing
+		} else {
+			go c.watchHealth(b, t, *c.cfg.healthCheck)
+		}
+	}
+	c.updateState()
+}
+
+// watchHealth follows the health of b's connection t until it fails.
+func (c *Client) watchHealth(b *backend, t *transport, service string) {
+	s, err := c.openStream(context.Background(), t, HealthService, "Watch", []interface{}{service})
+	for err == nil {
+		var status ServingStatus
+		if err = s.Recv(&status); err == nil {
+			if status != HealthServing {
+				status = HealthNotServing
+			}
+			c.setHealth(b, t, status)
+		}
+	}
+	if t.failed() != nil {
+		return
+	}
+	log.Printf("gopherpipe: health check of %s disabled: %v", b.sc.addr, err)
+	c.setHealth(b, t, HealthServing)
+}
+
+// setHealth records the health of b's connection t.
+func (c *Client) setHealth(b *backend, t *transport, status ServingStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b.t != t || b.health == status {
+		return
+	}
+	b.health = status
+	c.updateState()
+}
+
+// updateState derives the client's state from its backends': READY if
+// one is ready and healthy, otherwise CONNECTING if one is connecting or
+// awaiting its first health report, IDLE if one is idle and
+// TRANSIENT_FAILURE if none of these. It notifies waiters and watchers of
+// changes. c.mu must be held.
+func (c *Client) updateState() {
+	if c.state == StateShutdown {
+		return
+	}
+	state := StateTransientFailure
+	for _, b := range c.backends {
+		switch {
+		case b.state == StateReady && b.health == HealthServing:
+			state = StateReady
+		case (b.state == StateConnecting || b.state == StateReady && b.health == HealthUnknown) && state != StateReady:
+			state = StateConnecting
+		case b.state == StateIdle && state == StateTransientFailure:
+			state = StateIdle
+		}
+	}
+	c.setState(state)
+}
+
+// setState moves to state and notifies waiters and watchers. Each
+// watcher channel holds at most one state, so a slow watcher skips
+// intermediate states but always sees the latest one. c.mu must be held.
+func (c *Client) setState(state ConnState) {
+	if c.state == state {
+		// a backend changed even if the client did not
+		close(c.changed)
+		c.changed = make(chan struct{})
+		return
+	}
+	c.state = state
+	close(c.changed)
+	c.changed = make(chan struct{})
+	for ch := range c.watchers {
+		select {
+		case ch <- state:
+		default:
+			// c.mu serialises senders, so after dropping the stale
+			// state the send cannot block.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- state
+		}
+	}
+}
+
+// pick chooses the backend of a call, waiting while none is ready.
+// Unless the client waits for ready, it fails once no backend is ready
+// or connecting. If tried is not nil, the backends in it are only picked
+// when no other is ready, and the chosen one is added to it.
+func (c *Client) pick(ctx context.Context, info *PickInfo, tried *triedBackends) (*backend, *transport, error) {
+	for {
+		c.mu.Lock()
+		if c.state == StateShutdown {
+			c.mu.Unlock()
+			return nil, nil, errClientClosed
+		}
+		var ready, retried []Backend
+		var idle []*backend
+		var err error
+		for _, b := range c.backends {
+			switch {
+			case b.state == StateReady && b.health == HealthServing && b.t.failed() == nil:
+				if tried != nil && tried.has(b) {
+					retried = append(retried, b)
+				} else {
+					ready = append(ready, b)
+				}
+			case b.state == StateIdle:
+				idle = append(idle, b)
+			case b.state == StateTransientFailure && err == nil:
+				err = b.err
+			}
+		}
+		state, changed := c.state, c.changed
+		c.mu.Unlock()
+
+		if len(ready) == 0 {
+			ready = retried
+		}
+		if len(ready) > 0 {
+			b := c.balancer.Pick(info, ready).(*backend)
+			c.mu.Lock()
+			t := b.t
+			c.mu.Unlock()
+			if t != nil {
+				if tried != nil {
+					tried.add(b)
+				}
+				return b, t, nil
+			}
+			continue
+		}
+		for _, b := range idle {
+			b.sc.connect()
+		}
+		if len(idle) == 0 && state == StateTransientFailure && !c.cfg.waitForReady {
+			if err == nil {
+				err = errors.New("no healthy backend")
+			}
+			return nil, nil, &Status{Code: Unavailable, Message: "connection failed: " + err.Error()}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil, contextStatus(ctx.Err())
+		}
+	}
+}
+
+// watch returns a channel receiving the current state and then every
+// change until ctx is done. It is closed after SHUTDOWN.
+func (c *Client) watch(ctx context.Context) <-chan ConnState {
+	ch := make(chan ConnState, 1)
+	c.mu.Lock()
+	ch <- c.state
+	c.watchers[ch] = struct{}{}
+	c.mu.Unlock()
+
+	out := make(chan ConnState)
+	go func() {
+		defer close(out)
+		defer func() {
+			c.mu.Lock()
+			delete(c.watchers, ch)
+			c.mu.Unlock()
+		}()
+		for {
+			select {
+			case state := <-ch:
+				select {
+				case out <- state:
+				case <-ctx.Done():
+					return
+				}
+				if state == StateShutdown {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Backends returns the client's backends in the order of their
+// addresses.
+func (c *Client) Backends() []Backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backends := make([]Backend, len(c.backends))
+	for i, b := range c.backends {
+		backends[i] = b
+	}
+	return backends
+}
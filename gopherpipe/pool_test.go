@@ -0,0 +1,157 @@
+package gopherpipe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type named string
+
+// Name returns the name of the server answering.
+func (n named) Name() (string, error) { return string(n), nil }
+
+// startBackends serves a named service on n servers, configured by
+// configure if not nil, and returns their addresses and servers.
+func startBackends(t *testing.T, n int, configure func(*Server)) ([]string, []*Server) {
+	t.Helper()
+	var addrs []string
+	var servers []*Server
+	for i := 0; i < n; i++ {
+		srv := NewServer("")
+		if err := srv.Register("Named", named(string(rune('a'+i)))); err != nil {
+			t.Fatal(err)
+		}
+		if configure != nil {
+			configure(srv)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = srv.ServeListener(ln) }()
+		t.Cleanup(func() { ln.Close() })
+		addrs = append(addrs, ln.Addr().String())
+		servers = append(servers, srv)
+	}
+	return addrs, servers
+}
+
+// waitReady waits for n of c's backends to be ready.
+func waitReady(t *testing.T, c *Client, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ready := 0
+		c.mu.Lock()
+		for _, b := range c.backends {
+			if b.state == StateReady {
+				ready++
+			}
+		}
+		c.mu.Unlock()
+		if ready == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d backends ready, want %d", ready, n)
+		}
+	}
+}
+
+// names makes n calls and counts the answers of each backend.
+func names(t *testing.T, c *Client, ctx context.Context, n int) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		var name string
+		if err := c.Call(ctx, "Named", "Name", nil, &name); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		counts[name]++
+	}
+	return counts
+}
+
+// TestDialPool verifies calls are spread over the backends, skipping an
+// unreachable one.
+func TestDialPool(t *testing.T) {
+	addrs, _ := startBackends(t, 3, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+
+	c, err := DialPool(append(addrs, down), WithBackoff(fastBackoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitReady(t, c, 3)
+	counts := names(t, c, context.Background(), 30)
+	if counts["a"] != 10 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Fatalf("round robin spread calls %v", counts)
+	}
+	if len(c.Backends()) != 4 {
+		t.Fatalf("backends = %v", c.Backends())
+	}
+
+	if _, err := DialPool([]string{down}, WithBackoff(fastBackoff)); err == nil {
+		t.Fatal("DialPool of unreachable addresses succeeded")
+	}
+}
+
+// TestPoolConsistentHash verifies calls with the same key reach the same
+// backend.
+func TestPoolConsistentHash(t *testing.T) {
+	addrs, _ := startBackends(t, 3, nil)
+	c, err := DialPool(addrs, WithBalancer(ConsistentHash("tenant")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitReady(t, c, 3)
+	ctx := AppendToOutgoingContext(context.Background(), "tenant", "acme")
+	if counts := names(t, c, ctx, 20); len(counts) != 1 {
+		t.Fatalf("one tenant reached %v", counts)
+	}
+}
+
+// TestHealthEjection verifies a backend reporting NOT_SERVING stops
+// receiving calls until it is SERVING again.
+func TestHealthEjection(t *testing.T) {
+	health := make([]*HealthServer, 0, 2)
+	addrs, _ := startBackends(t, 2, func(srv *Server) {
+		h, err := srv.EnableHealth()
+		if err != nil {
+			t.Fatal(err)
+		}
+		health = append(health, h)
+	})
+	c, err := DialPool(addrs, WithHealthCheck(""), WithBalancer(LeastRequests()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	health[0].SetServingStatus("", HealthNotServing)
+	for deadline := time.Now().Add(5 * time.Second); names(t, c, ctx, 20)["a"] != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("NOT_SERVING backend still called")
+		}
+	}
+	health[1].SetServingStatus("", HealthNotServing)
+	waitState(t, c, StateTransientFailure)
+	if err := c.Call(ctx, "Named", "Name", nil, new(string)); CodeOf(err) != Unavailable {
+		t.Fatalf("call with every backend ejected: %v", err)
+	}
+	health[0].SetServingStatus("", HealthServing)
+	waitState(t, c, StateReady)
+	if counts := names(t, c, ctx, 5); counts["a"] != 5 {
+		t.Fatalf("calls went to %v", counts)
+	}
+}
@@ -0,0 +1,143 @@
+package gopherpipe
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// ReflectionService is the name the reflection service is registered
+// under by EnableReflection.
+const ReflectionService = "gopherpipe.Reflection"
+
+// schemaRPCTypes maps RPC types to their schema names.
+var schemaRPCTypes = map[RPCType]string{
+	Unary:        schema.Unary,
+	ClientStream: schema.ClientStream,
+	ServerStream: schema.ServerStream,
+	BiDi:         schema.BiDi,
+}
+
+// EnableReflection registers the reflection service, which lets generic
+// clients discover what the server exposes without the Go source. It has
+// two unary methods:
+//
+//	ListServices() ([]string, error)
+//	Describe(names []string) (*schema.Schema, error)
+//
+// Describe returns the schema of the named services, or of every service
+// when names is empty. Services registered from a ServiceDesc without
+// wire types are described with their methods and RPC types only.
+func (s *Server) EnableReflection() error {
+	return s.RegisterServiceDesc(ServiceDesc{
+		Name: ReflectionService,
+		Methods: []MethodDesc{
+			{
+				Name:        "ListServices",
+				RPCType:     Unary,
+				Handler:     s.listServicesHandler,
+				ResultTypes: []reflect.Type{TypeOf[[]string]()},
+			},
+			{
+				Name:        "Describe",
+				RPCType:     Unary,
+				Handler:     s.describeHandler,
+				ArgTypes:    []reflect.Type{TypeOf[[]string]()},
+				ResultTypes: []reflect.Type{TypeOf[*schema.Schema]()},
+			},
+		},
+	})
+}
+
+func (s *Server) listServicesHandler(ctx context.Context, call *ServerCall) error {
+	if err := call.DecodeArgs(); err != nil {
+		return err
+	}
+	return call.SendResults(s.serviceNames())
+}
+
+func (s *Server) describeHandler(ctx context.Context, call *ServerCall) error {
+	var names []string
+	if err := call.DecodeArgs(&names); err != nil {
+		return err
+	}
+	sch, err := s.describe(names)
+	if err != nil {
+		return err
+	}
+	return call.SendResults(sch)
+}
+
+// serviceNames returns the registered service names, sorted.
+func (s *Server) serviceNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// describe builds the schema of the named services, or of all of them.
+func (s *Server) describe(names []string) (*schema.Schema, error) {
+	if len(names) == 0 {
+		names = s.serviceNames()
+	}
+	b := schema.NewReflectBuilder()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, name := range names {
+		svc := s.services[name]
+		if svc == nil {
+			return nil, Errorf(NotFound, "unknown service %q", name)
+		}
+		b.Add(svc.describe(b))
+	}
+	return b.Schema(), nil
+}
+
+// describe converts the dispatch table of svc to its schema.
+func (svc *service) describe(b *schema.ReflectBuilder) *schema.Service {
+	out := &schema.Service{Name: svc.name}
+	for _, m := range svc.methods {
+		sm := &schema.Method{Name: m.name, RPCType: schemaRPCTypes[m.rpcType], Args: []*schema.Type{}, Results: []*schema.Type{}}
+		for _, t := range m.argTypes {
+			sm.Args = append(sm.Args, b.Type(t))
+		}
+		if m.inType != nil {
+			sm.StreamIn = b.Type(m.inType)
+		}
+		if (m.rpcType == ServerStream || m.rpcType == BiDi) && len(m.resultTypes) == 1 {
+			sm.StreamOut = b.Type(m.resultTypes[0])
+		} else {
+			for _, t := range m.resultTypes {
+				sm.Results = append(sm.Results, b.Type(t))
+			}
+		}
+		out.Methods = append(out.Methods, sm)
+	}
+	sort.Slice(out.Methods, func(i, j int) bool { return out.Methods[i].Name < out.Methods[j].Name })
+	return out
+}
+
+// ListServices asks a server with reflection enabled for the names of
+// its services.
+func ListServices(ctx context.Context, c *Client) ([]string, error) {
+	var names []string
+	err := c.Call(ctx, ReflectionService, "ListServices", nil, &names)
+	return names, err
+}
+
+// DescribeServices asks a server with reflection enabled for the schema
+// of the named services, or of all of them if no name is given.
+func DescribeServices(ctx context.Context, c *Client, names ...string) (*schema.Schema, error) {
+	var sch schema.Schema
+	if err := c.Call(ctx, ReflectionService, "Describe", []interface{}{names}, &sch); err != nil {
+		return nil, err
+	}
+	return &sch, nil
+}
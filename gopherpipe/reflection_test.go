@@ -0,0 +1,55 @@
+package gopherpipe
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/gopherpipe/schema"
+)
+
+// TestReflectionService verifies the reflection service lists and
+// describes reflection-registered services over the wire.
+func TestReflectionService(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Counter", &counter{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.EnableReflection(); err != nil {
+		t.Fatal(err)
+	}
+	c := startTestServer(t, srv)
+	ctx := context.Background()
+
+	names, err := ListServices(ctx, c)
+	if err != nil {
+		t.Fatalf("ListServices: %v", err)
+	}
+	if want := []string{"Counter", ReflectionService}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ListServices = %v, want %v", names, want)
+	}
+
+	sch, err := DescribeServices(ctx, c, "Counter")
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+	svc := sch.Service("Counter")
+	if len(sch.Services) != 1 || svc == nil || len(svc.Methods) != 5 {
+		t.Fatalf("Describe(Counter) = %+v", sch.Services)
+	}
+	scale := svc.Method("Scale")
+	if scale.RPCType != schema.BiDi || len(scale.Args) != 1 || scale.StreamIn.Kind != schema.Int || scale.StreamOut.Kind != schema.Int {
+		t.Fatalf("Scale = %+v", scale)
+	}
+	if sleep := svc.Method("Sleep"); sleep.Args[0].Kind != schema.Int64 || len(sleep.Results) != 0 {
+		t.Fatalf("Sleep = %+v", sleep)
+	}
+
+	all, err := DescribeServices(ctx, c)
+	if err != nil || len(all.Services) != 2 || all.Service(ReflectionService).Method("Describe").Results[0].Kind != schema.Ref {
+		t.Fatalf("Describe() = %+v, %v", all, err)
+	}
+	if _, err := DescribeServices(ctx, c, "Nope"); CodeOf(err) != NotFound {
+		t.Fatalf("Describe(Nope): got %v, want NotFound", err)
+	}
+}
@@ -0,0 +1,283 @@
+package gopherpipe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target is a dial target of the form scheme://authority/endpoint, for
+// example dns:///chat.internal:9000.
+type Target struct {
+	Scheme    string
+	Authority string
+	Endpoint  string
+}
+
+func (t Target) String() string {
+	return t.Scheme + "://" + t.Authority + "/" + t.Endpoint
+}
+
+// parseTarget splits a target with a scheme. ok is false for a plain
+// address.
+func parseTarget(target string) (t Target, ok bool) {
+	scheme, rest, ok := strings.Cut(target, "://")
+	if !ok {
+		return t, false
+	}
+	t.Scheme = scheme
+	t.Authority, t.Endpoint, _ = strings.Cut(rest, "/")
+	return t, true
+}
+
+// Resolver finds the backend addresses of a target.
+type Resolver interface {
+	// Resolve returns the current addresses of target, failing if there
+	// are none. It then calls update with the full list of addresses
+	// whenever they change, never before Resolve has returned, until ctx
+	// is done.
+	Resolve(ctx context.Context, target Target, update func(addrs []string)) ([]string, error)
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]Resolver{
+		"static":    StaticResolver{},
+		"dns":       &DNSResolver{},
+		"file":      &FileResolver{},
+		"gophermap": &GopherMapResolver{},
+	}
+)
+
+// RegisterResolver makes r resolve the targets of scheme in Dial,
+// replacing any resolver registered for it before. It is meant to be
+// called from init functions.
+func RegisterResolver(scheme string, r Resolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[scheme] = r
+}
+
+func lookupResolver(scheme string) Resolver {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	return resolvers[scheme]
+}
+
+// WithResolver resolves the target of this Dial with r whatever its
+// scheme.
+func WithResolver(r Resolver) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.resolver = r
+	}
+}
+
+// dialTarget resolves target and returns a client of its addresses.
+func dialTarget(target Target, cfg *dialConfig) (*Client, error) {
+	r := cfg.resolver
+	if r == nil {
+		if r = lookupResolver(target.Scheme); r == nil {
+			return nil, fmt.Errorf("gopherpipe: dial %s: no resolver for scheme %q", target, target.Scheme)
+		}
+	}
+	c := newClient(cfg.dialFunc, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopResolver = cancel
+	addrs, err := r.Resolve(ctx, target, c.setAddresses)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses")
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("gopherpipe: resolve %s: %w", target, err)
+	}
+	c.setAddresses(addrs)
+	if err := c.awaitFirstAttempts(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// StaticResolver resolves static:///host1:port,host2:port to the listed
+// addresses, which never change.
+type StaticResolver struct{}
+
+func (StaticResolver) Resolve(ctx context.Context, target Target, update func([]string)) ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(target.Endpoint, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// DNSResolver resolves dns:///host:port to the addresses of host, looked
+// up again every Refresh. A target authority names the DNS server to
+// ask, as in dns://10.0.0.53:53/chat.internal:9000; otherwise the
+// system's resolver is used. Failed lookups after the first keep the
+// previous addresses.
+type DNSResolver struct {
+	// Refresh is the time between lookups; it defaults to 30 seconds.
+	Refresh time.Duration
+	// LookupHost replaces the lookup, for tests or custom discovery.
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+func (r *DNSResolver) Resolve(ctx context.Context, target Target, update func([]string)) ([]string, error) {
+	host, port, err := net.SplitHostPort(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	lookup := r.LookupHost
+	if lookup == nil {
+		resolver := net.DefaultResolver
+		if server := target.Authority; server != "" {
+			resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server)
+			}}
+		}
+		lookup = resolver.LookupHost
+	}
+	resolve := func() ([]string, error) {
+		hosts, err := lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, len(hosts))
+		for i, h := range hosts {
+			addrs[i] = net.JoinHostPort(h, port)
+		}
+		sort.Strings(addrs)
+		return addrs, nil
+	}
+	addrs, err := resolve()
+	if err != nil {
+		return nil, err
+	}
+	refresh := r.Refresh
+	if refresh <= 0 {
+		refresh = 30 * time.Second
+	}
+	go poll(ctx, target, refresh, addrs, resolve, update)
+	return addrs, nil
+}
+
+// poll calls resolve every interval until ctx is done and reports the
+// addresses to update when they differ from the last ones. A failed
+// lookup is logged and the previous addresses kept.
+func poll(ctx context.Context, target Target, interval time.Duration, last []string, resolve func() ([]string, error), update func([]string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		addrs, err := resolve()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("gopherpipe: resolve %s: %v", target, err)
+			}
+			continue
+		}
+		if !equalStrings(addrs, last) {
+			last = addrs
+			update(addrs)
+		}
+	}
+}
+
+// FileResolver resolves file:///path/to/backends.json to the addresses
+// listed in the file, as in {"addresses": ["10.0.0.1:9000"]}, and
+// rereads it when it changes. An invalid edit is logged and the previous
+// addresses kept.
+type FileResolver struct {
+	// CheckInterval is the time between two checks of the file's
+	// modification time; it defaults to one second.
+	CheckInterval time.Duration
+}
+
+// backendsFile is the format of a FileResolver file.
+type backendsFile struct {
+	Addresses []string `json:"addresses"`
+}
+
+func (r *FileResolver) Resolve(ctx context.Context, target Target, update func([]string)) ([]string, error) {
+	name := "/" + target.Endpoint
+	read := func() ([]string, time.Time, error) {
+		mod, err := modTime(name)
+		if err != nil {
+			return nil, mod, err
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, mod, err
+		}
+		var f backendsFile
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, mod, fmt.Errorf("parse %s: %w", name, err)
+		}
+		return f.Addresses, mod, nil
+	}
+	addrs, mod, err := read()
+	if err != nil {
+		return nil, err
+	}
+	interval := r.CheckInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func(last []string) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if m, err := modTime(name); err != nil || m.Equal(mod) {
+				continue
+			}
+			addrs, m, err := read()
+			// not retried until the file changes again
+			mod = m
+			switch {
+			case err != nil:
+				log.Printf("gopherpipe: resolve %s: %v", target, err)
+			case len(addrs) == 0:
+				log.Printf("gopherpipe: resolve %s: no addresses, keeping the previous ones", target)
+			case !equalStrings(addrs, last):
+				last = addrs
+				update(addrs)
+			}
+		}
+	}(addrs)
+	return addrs, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
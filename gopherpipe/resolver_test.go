@@ -0,0 +1,170 @@
+package gopherpipe
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Target
+		ok   bool
+	}{
+		{"localhost:9000", Target{}, false},
+		{"static:///a:1,b:2", Target{"static", "", "a:1,b:2"}, true},
+		{"dns://10.0.0.53:53/chat.internal:9000", Target{"dns", "10.0.0.53:53", "chat.internal:9000"}, true},
+		{"file:///etc/backends.json", Target{"file", "", "etc/backends.json"}, true},
+	}
+	for _, c := range cases {
+		got, ok := parseTarget(c.in)
+		if ok != c.ok || got != c.want {
+			t.Errorf("parseTarget(%q) = %+v, %v; want %+v, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestStaticTarget verifies a static target balances over its addresses.
+func TestStaticTarget(t *testing.T) {
+	addrs, _ := startBackends(t, 2, nil)
+	c, err := Dial("static:///" + strings.Join(addrs, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitReady(t, c, 2)
+	if counts := names(t, c, context.Background(), 4); counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("calls spread %v", counts)
+	}
+}
+
+// TestFileTarget verifies backends are added and removed as the file
+// listing them changes, and that an invalid edit is ignored.
+func TestFileTarget(t *testing.T) {
+	addrs, _ := startBackends(t, 2, nil)
+	name := filepath.Join(t.TempDir(), "backends.json")
+	mtime := time.Now()
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Coarse file system timestamps could hide the change.
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"addresses": ["` + addrs[0] + `"]}`)
+
+	c, err := Dial("file://"+filepath.ToSlash(name), WithResolver(&FileResolver{CheckInterval: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitReady(t, c, 1)
+	if counts := names(t, c, context.Background(), 2); counts["a"] != 2 {
+		t.Fatalf("calls reached %v", counts)
+	}
+
+	write(`{"addresses": ["` + addrs[1] + `"]}`)
+	waitBackends(t, c, addrs[1])
+	waitReady(t, c, 1)
+	if counts := names(t, c, context.Background(), 2); counts["b"] != 2 {
+		t.Fatalf("calls reached %v after the edit", counts)
+	}
+
+	write(`{"adresses": []}`)
+	time.Sleep(50 * time.Millisecond)
+	waitBackends(t, c, addrs[1])
+}
+
+// waitBackends waits for c's backends to be exactly addrs.
+func waitBackends(t *testing.T, c *Client, addrs ...string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var got []string
+		for _, b := range c.Backends() {
+			got = append(got, b.Addr())
+		}
+		if equalStrings(got, addrs) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backends = %v, want %v", got, addrs)
+		}
+	}
+}
+
+// TestDNSResolver verifies lookups are polled and changes reported.
+func TestDNSResolver(t *testing.T) {
+	var mu sync.Mutex
+	hosts := []string{"10.0.0.2", "10.0.0.1"}
+	r := &DNSResolver{Refresh: 10 * time.Millisecond, LookupHost: func(ctx context.Context, host string) ([]string, error) {
+		if host != "chat.internal" {
+			t.Errorf("lookup of %q", host)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return hosts, nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []string, 10)
+	target, _ := parseTarget("dns:///chat.internal:9000")
+	addrs, err := r.Resolve(ctx, target, func(addrs []string) { updates <- addrs })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:9000", "10.0.0.2:9000"}; !equalStrings(addrs, want) {
+		t.Fatalf("addresses = %v, want %v", addrs, want)
+	}
+
+	mu.Lock()
+	hosts = []string{"10.0.0.3"}
+	mu.Unlock()
+	select {
+	case addrs := <-updates:
+		if !equalStrings(addrs, []string{"10.0.0.3:9000"}) {
+			t.Fatalf("update = %v", addrs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(updates) != 0 {
+		t.Fatalf("update without a change: %v", <-updates)
+	}
+}
+
+// TestDNSTarget verifies a dns target dials the addresses looked up.
+func TestDNSTarget(t *testing.T) {
+	addrs, _ := startBackends(t, 1, nil)
+	host, port, _ := net.SplitHostPort(addrs[0])
+	r := &DNSResolver{LookupHost: func(ctx context.Context, _ string) ([]string, error) {
+		return []string{host}, nil
+	}}
+	c, err := Dial("dns:///backend.test:"+port, WithResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if counts := names(t, c, context.Background(), 1); counts["a"] != 1 {
+		t.Fatalf("call reached %v", counts)
+	}
+}
+
+func TestUnresolvableTargets(t *testing.T) {
+	for _, target := range []string{"gophermap:///ChatService", "unknown:///x", "static:///"} {
+		if c, err := Dial(target); err == nil {
+			c.Close()
+			t.Errorf("Dial(%q) succeeded", target)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package gopherpipe
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AttemptMetadataKey is the outgoing metadata key numbering the attempts of
+// a retried or hedged call: "2" on the second attempt, "3" on the next and
+// so on. It is absent from first attempts.
+const AttemptMetadataKey = "gp-attempt"
+
+// RetryPolicy retries the unary calls of an idempotent method that fail
+// with one of RetryableCodes. The delay before the n-th retry is
+// InitialBackoff * BackoffMultiplier^(n-1), capped at MaxBackoff and
+// randomised by ±20%. Zero fields take the defaults given below.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt; it defaults to 3.
+	MaxAttempts int
+	// InitialBackoff defaults to 100 milliseconds.
+	InitialBackoff time.Duration
+	// MaxBackoff defaults to one second.
+	MaxBackoff time.Duration
+	// BackoffMultiplier defaults to 2.
+	BackoffMultiplier float64
+	// RetryableCodes defaults to Unavailable alone.
+	RetryableCodes []Code
+}
+
+// withDefaults fills in the zero fields of p.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Second
+	}
+	if p.BackoffMultiplier < 1 {
+		p.BackoffMultiplier = 2
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = []Code{Unavailable}
+	}
+	return p
+}
+
+// backoff returns the delay before retry n, counted from 1.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	b := BackoffConfig{BaseDelay: p.InitialBackoff, Multiplier: p.BackoffMultiplier, Jitter: 0.2, MaxDelay: p.MaxBackoff}
+	return b.delay(n - 1)
+}
+
+func (p *RetryPolicy) retryable(code Code) bool {
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// WithRetryPolicy retries the calls of method of service under p, or of
+// every method of service if method is empty. A policy for a method takes
+// precedence over one for its service. Only methods marked idempotent,
+// with WithIdempotent or the //gopherpipe:idempotent contract directive,
+// are retried: the server may have run a failed call before failing.
+// Streaming calls are never retried.
+func WithRetryPolicy(service, method string, p RetryPolicy) DialOption {
+	return func(cfg *dialConfig) {
+		if cfg.retryPolicies == nil {
+			cfg.retryPolicies = make(map[string]*RetryPolicy)
+		}
+		p := p.withDefaults()
+		cfg.retryPolicies[service+"/"+method] = &p
+	}
+}
+
+// methodFlag records what calling a method more than once is safe for.
+type methodFlag uint8
+
+const (
+	flagIdempotent methodFlag = 1 << iota
+	flagReadOnly
+)
+
+// markMethods sets flag on methods of service in flags.
+func markMethods(flags map[string]methodFlag, flag methodFlag, service string, methods []string) {
+	for _, m := range methods {
+		flags[service+"/"+m] |= flag
+	}
+}
+
+// WithIdempotent marks methods of service as safe to call more than once,
+// in addition to those registered with RegisterIdempotent.
+func WithIdempotent(service string, methods ...string) DialOption {
+	return func(cfg *dialConfig) {
+		if cfg.methodFlags == nil {
+			cfg.methodFlags = make(map[string]methodFlag)
+		}
+		markMethods(cfg.methodFlags, flagIdempotent, service, methods)
+	}
+}
+
+var (
+	methodFlagsMu sync.RWMutex
+	methodFlags   = make(map[string]methodFlag)
+)
+
+// RegisterIdempotent marks methods of service as safe to call more than
+// once for every client. Clients generated by genstub call it for the
+// contract methods carrying the //gopherpipe:idempotent directive.
+func RegisterIdempotent(service string, methods ...string) {
+	methodFlagsMu.Lock()
+	defer methodFlagsMu.Unlock()
+	markMethods(methodFlags, flagIdempotent, service, methods)
+}
+
+// methodIs reports whether method of service is marked with flag, for
+// this client or every client.
+func (c *Client) methodIs(flag methodFlag, service, method string) bool {
+	key := service + "/" + method
+	if c.cfg.methodFlags[key]&flag != 0 {
+		return true
+	}
+	methodFlagsMu.RLock()
+	defer methodFlagsMu.RUnlock()
+	return methodFlags[key]&flag != 0
+}
+
+// retryPolicy returns the policy of an idempotent method, or nil.
+func (c *Client) retryPolicy(service, method string) *RetryPolicy {
+	p := c.cfg.retryPolicies[service+"/"+method]
+	if p == nil {
+		p = c.cfg.retryPolicies[service+"/"]
+	}
+	if p == nil || !c.methodIs(flagIdempotent, service, method) {
+		return nil
+	}
+	return p
+}
+
+// RetryBudget bounds the retries of a client so they cannot multiply the
+// load on servers that are already failing. Each retry spends a token from
+// a bucket holding up to MaxTokens, which starts full; each successful
+// call adds TokenRatio tokens back. With the defaults of 10 tokens and a
+// ratio of 0.1, retries are limited to a burst of 10 and then to one per
+// 10 successful calls.
+type RetryBudget struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+// WithRetryBudget replaces the default retry budget.
+func WithRetryBudget(b RetryBudget) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.retryBudget = b
+	}
+}
+
+// retryBudget is the token bucket of a RetryBudget.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	ratio  float64
+}
+
+func newRetryBudget(b RetryBudget) *retryBudget {
+	if b.MaxTokens <= 0 {
+		b.MaxTokens = 10
+	}
+	if b.TokenRatio <= 0 {
+		b.TokenRatio = 0.1
+	}
+	return &retryBudget{tokens: b.MaxTokens, max: b.MaxTokens, ratio: b.TokenRatio}
+}
+
+// spend takes a token for a retry, reporting false if none is left.
+func (b *retryBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// succeeded refills the bucket after a successful call.
+func (b *retryBudget) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens += b.ratio; b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// retry runs attempt until it succeeds or p gives up, returning the last
+// error. A retry is made only if the error is retryable, the budget has a
+// token left and the backoff delay ends before the deadline of ctx.
+// attempt receives the context carrying the attempt number.
+func (c *Client) retry(ctx context.Context, p *RetryPolicy, attempt func(ctx context.Context) error) error {
+	err := attempt(ctx)
+	for n := 1; err != nil && n < p.MaxAttempts; n++ {
+		if !p.retryable(CodeOf(err)) {
+			break
+		}
+		delay := p.backoff(n)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			break
+		}
+		if !c.retryBudget.spend() {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		err = attempt(AppendToOutgoingContext(ctx, AttemptMetadataKey, strconv.Itoa(n+1)))
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package gopherpipe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flaky fails every call with Unavailable until ok is reached and records
+// the attempt metadata of each call.
+type flaky struct {
+	mu       sync.Mutex
+	ok       int
+	attempts []string
+}
+
+func (f *flaky) Get(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts = append(f.attempts, IncomingMetadata(ctx).Get(AttemptMetadataKey))
+	if len(f.attempts) < f.ok {
+		return "", Errorf(Unavailable, "try again")
+	}
+	return "done", nil
+}
+
+func (f *flaky) Put(ctx context.Context) error {
+	_, err := f.Get(ctx)
+	return err
+}
+
+func (f *flaky) Bad(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts = append(f.attempts, "")
+	return Errorf(InvalidArgument, "bad")
+}
+
+func (f *flaky) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.attempts)
+	f.attempts = nil
+	return n
+}
+
+func startFlaky(t *testing.T, f *flaky, opts ...DialOption) *Client {
+	t.Helper()
+	addrs, _ := startBackends(t, 1, func(srv *Server) {
+		if err := srv.Register("Flaky", f); err != nil {
+			t.Fatal(err)
+		}
+	})
+	retry := RetryPolicy{InitialBackoff: time.Millisecond, RetryableCodes: []Code{Unavailable, InvalidArgument}}
+	opts = append([]DialOption{WithRetryPolicy("Flaky", "", retry), WithIdempotent("Flaky", "Get", "Bad")}, opts...)
+	c, err := Dial(addrs[0], opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestRetry verifies idempotent calls are retried with the attempt number
+// in their metadata, and other calls are not.
+func TestRetry(t *testing.T) {
+	f := &flaky{ok: 3}
+	c := startFlaky(t, f)
+	var got string
+	if err := c.Call(context.Background(), "Flaky", "Get", nil, &got); err != nil || got != "done" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+	if want := []string{"", "2", "3"}; !equalStrings(f.attempts, want) {
+		t.Fatalf("attempts = %q, want %q", f.attempts, want)
+	}
+
+	f.calls()
+	f.ok = 10
+	if err := c.Call(context.Background(), "Flaky", "Put", nil); CodeOf(err) != Unavailable {
+		t.Fatalf("Put = %v", err)
+	}
+	if n := f.calls(); n != 1 {
+		t.Fatalf("non-idempotent method attempted %d times", n)
+	}
+	if err := c.Call(context.Background(), "Flaky", "Get", nil, &got); CodeOf(err) != Unavailable {
+		t.Fatalf("Get = %v", err)
+	}
+	if n := f.calls(); n != 3 {
+		t.Fatalf("Get attempted %d times, want 3", n)
+	}
+}
+
+// TestRetryOnlyRetryableCodes verifies codes outside the policy's list end
+// the call.
+func TestRetryOnlyRetryableCodes(t *testing.T) {
+	f := &flaky{}
+	c := startFlaky(t, f, WithRetryPolicy("Flaky", "Bad", RetryPolicy{InitialBackoff: time.Millisecond}))
+	if err := c.Call(context.Background(), "Flaky", "Bad", nil); CodeOf(err) != InvalidArgument {
+		t.Fatalf("Bad = %v", err)
+	}
+	if n := f.calls(); n != 1 {
+		t.Fatalf("Bad attempted %d times", n)
+	}
+}
+
+// TestRetryBudget verifies retries stop once the budget is spent and
+// resume as calls succeed.
+func TestRetryBudget(t *testing.T) {
+	f := &flaky{ok: 100}
+	c := startFlaky(t, f, WithRetryBudget(RetryBudget{MaxTokens: 3, TokenRatio: 1}))
+	var got string
+	for _, want := range []int{3, 2, 1} {
+		if err := c.Call(context.Background(), "Flaky", "Get", nil, &got); err == nil {
+			t.Fatal("Get succeeded")
+		}
+		if n := f.calls(); n != want {
+			t.Fatalf("Get attempted %d times, want %d", n, want)
+		}
+	}
+	f.ok = 0
+	if err := c.Call(context.Background(), "Flaky", "Get", nil, &got); err != nil {
+		t.Fatal(err)
+	}
+	f.calls()
+	f.ok = 100
+	if err := c.Call(context.Background(), "Flaky", "Get", nil, &got); err == nil {
+		t.Fatal("Get succeeded")
+	}
+	if n := f.calls(); n != 2 {
+		t.Fatalf("Get attempted %d times after a success, want 2", n)
+	}
+}
+
+// TestRetryRespectsDeadline verifies no retry is made when its backoff
+// would outlast the deadline.
+func TestRetryRespectsDeadline(t *testing.T) {
+	f := &flaky{ok: 100}
+	c := startFlaky(t, f, WithRetryPolicy("Flaky", "Get", RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Minute}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	var got string
+	if err := c.Call(ctx, "Flaky", "Get", nil, &got); CodeOf(err) != Unavailable {
+		t.Fatalf("Get = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Get took %v", elapsed)
+	}
+	if n := f.calls(); n != 1 {
+		t.Fatalf("Get attempted %d times", n)
+	}
+}
@@ -0,0 +1,247 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Change is one difference between two schemas.
+type Change struct {
+	// Path locates the change, e.g. "ChatService.Send arg 1 .Text".
+	Path    string
+	Message string
+	// Breaking reports whether peers built against the old schema can
+	// fail or silently lose data talking to peers built against the new
+	// one.
+	Breaking bool
+}
+
+func (c Change) String() string {
+	tag := "compatible"
+	if c.Breaking {
+		tag = "BREAKING"
+	}
+	return fmt.Sprintf("%s: %s: %s", tag, c.Path, c.Message)
+}
+
+// HasBreaking reports whether any change is breaking.
+func HasBreaking(changes []Change) bool {
+	for _, c := range changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// direction is the way values flow between old and new peers. Requests
+// are sent by old clients to new servers and replies the other way round,
+// which decides whether a change of integer width can overflow.
+type direction int
+
+const (
+	request direction = iota
+	reply
+)
+
+// Compare reports the differences between old and new under gob's
+// decoding rules:
+//
+//   - removing a service or method, or changing its RPC type or the
+//     number of arguments or results, is breaking;
+//   - struct fields are matched by name, so removing or renaming a field
+//     silently drops its values and is breaking, while adding one is not;
+//   - integers, unsigned integers, floats and complex numbers may change
+//     width within their family, but narrowing the receiving side can
+//     overflow and is breaking; any other change of kind is breaking;
+//   - pointers and type names do not matter, except for self-encoding
+//     (opaque) types, which are identified by name.
+//
+// Changes are sorted by path.
+func Compare(old, new *Schema) []Change {
+	c := &comparer{old: old, new: new, seen: make(map[[2]string]bool)}
+	for _, osvc := range old.Services {
+		nsvc := new.Service(osvc.Name)
+		if nsvc == nil {
+			c.add(osvc.Name, true, "service removed")
+			continue
+		}
+		for _, om := range osvc.Methods {
+			path := osvc.Name + "." + om.Name
+			nm := nsvc.Method(om.Name)
+			if nm == nil {
+				c.add(path, true, "method removed")
+				continue
+			}
+			c.method(path, om, nm)
+		}
+		for _, nm := range nsvc.Methods {
+			if osvc.Method(nm.Name) == nil {
+				c.add(osvc.Name+"."+nm.Name, false, "method added")
+			}
+		}
+	}
+	for _, nsvc := range new.Services {
+		if old.Service(nsvc.Name) == nil {
+			c.add(nsvc.Name, false, "service added")
+		}
+	}
+	sort.SliceStable(c.changes, func(i, j int) bool { return c.changes[i].Path < c.changes[j].Path })
+	return c.changes
+}
+
+type comparer struct {
+	old, new *Schema
+	// seen holds the pairs of named types being compared on the current
+	// path, which keeps recursive types from looping. Types are compared
+	// again on every other path, as each may flow in another direction.
+	seen    map[[2]string]bool
+	changes []Change
+}
+
+func (c *comparer) add(path string, breaking bool, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{Path: path, Message: fmt.Sprintf(format, args...), Breaking: breaking})
+}
+
+func (c *comparer) method(path string, old, new *Method) {
+	if old.RPCType != new.RPCType {
+		c.add(path, true, "RPC type changed from %s to %s", old.RPCType, new.RPCType)
+		return
+	}
+	c.tuple(path, "arg", old.Args, new.Args, request)
+	c.tuple(path, "result", old.Results, new.Results, reply)
+	if old.StreamIn != nil && new.StreamIn != nil {
+		c.typ(path+" stream in", old.StreamIn, new.StreamIn, request)
+	}
+	if old.StreamOut != nil && new.StreamOut != nil {
+		c.typ(path+" stream out", old.StreamOut, new.StreamOut, reply)
+	}
+}
+
+func (c *comparer) tuple(path, what string, old, new []*Type, dir direction) {
+	if len(old) != len(new) {
+		c.add(path, true, "%s count changed from %d to %d", what, len(old), len(new))
+		return
+	}
+	for i := range old {
+		c.typ(fmt.Sprintf("%s %s %d", path, what, i), old[i], new[i], dir)
+	}
+}
+
+// typ compares the values described by old and new.
+func (c *comparer) typ(path string, old, new *Type, dir direction) {
+	if old.Kind == Ref && new.Kind == Ref {
+		key := [2]string{old.Name, new.Name}
+		if c.seen[key] {
+			return
+		}
+		c.seen[key] = true
+		defer delete(c.seen, key)
+	}
+	od, nd := old.String(), new.String()
+	changed := func() { c.add(path, true, "type changed from %s to %s", od, nd) }
+	old, new = c.old.resolve(old), c.new.resolve(new)
+	if old.Kind == Opaque || new.Kind == Opaque {
+		if old.Kind != new.Kind || old.Name != new.Name {
+			changed()
+		}
+		return
+	}
+	if of, nf := family(old.Kind), family(new.Kind); of != "" && of == nf {
+		c.width(path, old.Kind, new.Kind, dir)
+		return
+	}
+	if old.Kind != new.Kind {
+		changed()
+		return
+	}
+	switch old.Kind {
+	case Array:
+		if old.Len != new.Len {
+			c.add(path, true, "array length changed from %d to %d", old.Len, new.Len)
+			return
+		}
+		c.typ(path+"[]", old.Elem, new.Elem, dir)
+	case Slice:
+		c.typ(path+"[]", old.Elem, new.Elem, dir)
+	case Map:
+		c.typ(path+"[key]", old.Key, new.Key, dir)
+		c.typ(path+"[]", old.Elem, new.Elem, dir)
+	case Struct:
+		c.fields(path, old.Fields, new.Fields, dir)
+	}
+}
+
+func (c *comparer) fields(path string, old, new []*Field, dir direction) {
+	byName := make(map[string]*Field, len(new))
+	for _, f := range new {
+		byName[f.Name] = f
+	}
+	for _, of := range old {
+		nf := byName[of.Name]
+		if nf == nil {
+			c.add(path+" ."+of.Name, true, "field removed or renamed; gob drops its values")
+			continue
+		}
+		delete(byName, of.Name)
+		c.typ(path+" ."+of.Name, of.Type, nf.Type, dir)
+	}
+	for _, nf := range new {
+		if byName[nf.Name] != nil {
+			c.add(path+" ."+nf.Name, false, "field added")
+		}
+	}
+}
+
+// width compares two kinds of the same numeric family.
+func (c *comparer) width(path string, old, new Kind, dir direction) {
+	if old == new {
+		return
+	}
+	ob, nb := bits[old], bits[new]
+	// The receiver is the new peer for requests and the old one for
+	// replies; it overflows if it is narrower than the sender.
+	recv, send := nb, ob
+	if dir == reply {
+		recv, send = ob, nb
+	}
+	if recv < send {
+		c.add(path, true, "%s changed to %s; values may overflow the receiver", old, new)
+		return
+	}
+	c.add(path, false, "%s changed to %s", old, new)
+}
+
+// family groups the kinds gob encodes with the same wire type.
+func family(k Kind) string {
+	switch k {
+	case Int, Int8, Int16, Int32, Int64:
+		return "int"
+	case Uint, Uint8, Uint16, Uint32, Uint64, Uintptr:
+		return "uint"
+	case Float32, Float64:
+		return "float"
+	case Complex64, Complex128:
+		return "complex"
+	}
+	return ""
+}
+
+// bits is the width of numeric kinds; int, uint and uintptr count as 64
+// bits, their size on the platforms GopherPipe runs on.
+var bits = map[Kind]int{
+	Int: 64, Int8: 8, Int16: 16, Int32: 32, Int64: 64,
+	Uint: 64, Uint8: 8, Uint16: 16, Uint32: 32, Uint64: 64, Uintptr: 64,
+	Float32: 32, Float64: 64, Complex64: 64, Complex128: 128,
+}
+
+// resolve returns the definition of a Ref type, or t itself.
+func (s *Schema) resolve(t *Type) *Type {
+	if t.Kind != Ref {
+		return t
+	}
+	if def := s.Types[t.Name]; def != nil {
+		return def
+	}
+	return &Type{Kind: Opaque, Name: t.Name}
+}
@@ -0,0 +1,76 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCompareSourceVersions evolves the v1 contract and checks which
+// changes are reported as breaking.
+func TestCompareSourceVersions(t *testing.T) {
+	v2 := strings.NewReplacer(
+		"Name     string", "Title    string", // rename: breaking
+		"Count    int32", "Count    int64\n\tExtra    bool", // request widening, added field
+		"Size(ids []string) (map[string]int64, error)", "Size(ids []string) (map[string]int32, error)", // reply narrowing
+		"Ping() error", "Pong() error", // removed and added method
+	).Replace(v1)
+	changes := Compare(loadSource(t, v1), loadSource(t, v2))
+
+	want := map[string]bool{
+		"TreeService.Get result 0 .Name":     true,
+		"TreeService.Get result 0 .Title":    false,
+		"TreeService.Get result 0 .Count":    true, // replies: old clients receive int64 into int32
+		"TreeService.Get result 0 .Extra":    false,
+		"TreeService.Walk arg 0 .Count":      false, // requests: new servers receive into int64
+		"TreeService.Size result 0[]":        false,
+		"TreeService.Ping":                   true,
+		"TreeService.Pong":                   false,
+		"TreeService.Walk stream out .Count": true,
+		"TreeService.Walk arg 0 .Name":       true,
+		"TreeService.Walk stream out .Name":  true,
+		"TreeService.Walk arg 0 .Title":      false,
+		"TreeService.Walk stream out .Title": false,
+		"TreeService.Walk arg 0 .Extra":      false,
+		"TreeService.Walk stream out .Extra": false,
+	}
+	got := make(map[string]bool)
+	for _, c := range changes {
+		got[c.Path] = c.Breaking
+	}
+	for path, breaking := range want {
+		if b, ok := got[path]; !ok || b != breaking {
+			t.Errorf("%s: got reported=%v breaking=%v, want breaking=%v", path, ok, b, breaking)
+		}
+	}
+	if !HasBreaking(changes) {
+		t.Fatalf("no breaking change reported")
+	}
+}
+
+// TestCompareKinds covers type changes that are not width changes.
+func TestCompareKinds(t *testing.T) {
+	unary := func(args ...*Type) *Schema {
+		return &Schema{Version: Version, Services: []*Service{{Name: "S", Methods: []*Method{
+			{Name: "M", RPCType: Unary, Args: args},
+		}}}}
+	}
+	cases := []struct {
+		name     string
+		old, new *Schema
+		breaking bool
+	}{
+		{"int to uint", unary(&Type{Kind: Int}), unary(&Type{Kind: Uint}), true},
+		{"string to bytes", unary(&Type{Kind: String}), unary(&Type{Kind: Slice, Elem: &Type{Kind: Uint8}}), true},
+		{"array length", unary(&Type{Kind: Array, Len: 2, Elem: &Type{Kind: Int}}), unary(&Type{Kind: Array, Len: 3, Elem: &Type{Kind: Int}}), true},
+		{"arg added", unary(&Type{Kind: Int}), unary(&Type{Kind: Int}, &Type{Kind: Int}), true},
+		{"opaque renamed", unary(&Type{Kind: Opaque, Name: "a.T"}), unary(&Type{Kind: Opaque, Name: "b.T"}), true},
+		{"float widened", unary(&Type{Kind: Float32}), unary(&Type{Kind: Float64}), false},
+		{"rpc type", unary(), &Schema{Services: []*Service{{Name: "S", Methods: []*Method{{Name: "M", RPCType: ServerStream, StreamOut: &Type{Kind: Int}}}}}}, true},
+		{"service removed", unary(), &Schema{}, true},
+	}
+	for _, tc := range cases {
+		if got := HasBreaking(Compare(tc.old, tc.new)); got != tc.breaking {
+			t.Errorf("%s: breaking = %v, want %v (%v)", tc.name, got, tc.breaking, Compare(tc.old, tc.new))
+		}
+	}
+}
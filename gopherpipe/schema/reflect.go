@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/gob"
+	"reflect"
+	"sort"
+)
+
+// ReflectBuilder builds a schema from reflect types, for servers that only
+// know their services at run time.
+type ReflectBuilder struct {
+	s *Schema
+}
+
+// NewReflectBuilder returns a builder of an empty schema.
+func NewReflectBuilder() *ReflectBuilder {
+	return &ReflectBuilder{s: &Schema{Version: Version, Services: []*Service{}, Types: make(map[string]*Type)}}
+}
+
+// Add adds svc to the schema. Its method types must come from Type.
+func (b *ReflectBuilder) Add(svc *Service) {
+	b.s.Services = append(b.s.Services, svc)
+}
+
+// Schema returns the schema built so far, with services sorted by name.
+func (b *ReflectBuilder) Schema() *Schema {
+	sort.Slice(b.s.Services, func(i, j int) bool { return b.s.Services[i].Name < b.s.Services[j].Name })
+	return b.s
+}
+
+var (
+	gobEncoderType    = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	binaryMarshalType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	textMarshalType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+var reflectKinds = map[reflect.Kind]Kind{
+	reflect.Bool: Bool, reflect.Int: Int, reflect.Int8: Int8, reflect.Int16: Int16,
+	reflect.Int32: Int32, reflect.Int64: Int64, reflect.Uint: Uint, reflect.Uint8: Uint8,
+	reflect.Uint16: Uint16, reflect.Uint32: Uint32, reflect.Uint64: Uint64,
+	reflect.Uintptr: Uintptr, reflect.Float32: Float32, reflect.Float64: Float64,
+	reflect.Complex64: Complex64, reflect.Complex128: Complex128, reflect.String: String,
+}
+
+// Type describes t as gob encodes it, recording named struct types in
+// the schema. It matches what Load produces for the same Go type.
+func (b *ReflectBuilder) Type(t reflect.Type) *Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		name := t.PkgPath() + "." + t.Name()
+		if selfEncodingType(t) {
+			return &Type{Kind: Opaque, Name: name}
+		}
+		if t.Kind() == reflect.Struct {
+			if _, done := b.s.Types[name]; !done {
+				def := &Type{Kind: Struct}
+				b.s.Types[name] = def
+				def.Fields = b.fields(t)
+			}
+			return &Type{Kind: Ref, Name: name}
+		}
+	}
+	if k, ok := reflectKinds[t.Kind()]; ok {
+		return &Type{Kind: k}
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return &Type{Kind: Slice, Elem: b.Type(t.Elem())}
+	case reflect.Array:
+		return &Type{Kind: Array, Len: int64(t.Len()), Elem: b.Type(t.Elem())}
+	case reflect.Map:
+		return &Type{Kind: Map, Key: b.Type(t.Key()), Elem: b.Type(t.Elem())}
+	case reflect.Struct:
+		return &Type{Kind: Struct, Fields: b.fields(t)}
+	case reflect.Interface:
+		return &Type{Kind: Interface}
+	}
+	return &Type{Kind: Opaque, Name: t.String()}
+}
+
+// fields lists the fields of the struct type t that gob encodes.
+func (b *ReflectBuilder) fields(t reflect.Type) []*Field {
+	var fields []*Field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Chan, reflect.Func:
+			continue
+		}
+		fields = append(fields, &Field{Name: f.Name, Type: b.Type(f.Type)})
+	}
+	return fields
+}
+
+// selfEncodingType reports whether gob encodes t through one of its own
+// marshaling methods.
+func selfEncodingType(t reflect.Type) bool {
+	p := reflect.PointerTo(t)
+	return p.Implements(gobEncoderType) || p.Implements(binaryMarshalType) || p.Implements(textMarshalType)
+}
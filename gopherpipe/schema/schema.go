@@ -0,0 +1,220 @@
+// Package schema describes GopherPipe service contracts as a stable,
+// language-neutral JSON document: every service, its methods in wire terms
+// and the full graph of types they carry. Schemas are built from Go source
+// with Load and compared with Compare, which reports changes that would
+// break deployed peers under gob's decoding rules.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Version is the schema format version written by this package.
+const Version = 1
+
+// RPC types, matching gopherpipe.RPCType.
+const (
+	Unary        = "unary"
+	ClientStream = "client_stream"
+	ServerStream = "server_stream"
+	BiDi         = "bidi"
+)
+
+// Schema is the exported description of a set of services.
+type Schema struct {
+	Version int `json:"version"`
+	// Package is the import path the services were loaded from, if any.
+	Package  string     `json:"package,omitempty"`
+	Services []*Service `json:"services"`
+	// Types holds the definitions of named struct types, keyed by their
+	// qualified name. Type values refer to them with KindRef so recursive
+	// types can be described.
+	Types map[string]*Type `json:"types,omitempty"`
+}
+
+// Service describes one service.
+type Service struct {
+	Name    string    `json:"name"`
+	Methods []*Method `json:"methods"`
+}
+
+// Method describes a method in wire terms. Args and Results are the
+// values of the request and reply tuples; the context parameter and
+// error result are not part of the wire contract. StreamIn and StreamOut
+// are the message types of the client and server streams, if any.
+type Method struct {
+	Name      string  `json:"name"`
+	RPCType   string  `json:"rpc_type"`
+	Args      []*Type `json:"args"`
+	Results   []*Type `json:"results"`
+	StreamIn  *Type   `json:"stream_in,omitempty"`
+	StreamOut *Type   `json:"stream_out,omitempty"`
+}
+
+// Kind classifies a Type. Pointers do not appear: gob flattens them, so
+// *T and T have the same schema.
+type Kind string
+
+// Kinds of types.
+const (
+	Bool       Kind = "bool"
+	Int        Kind = "int"
+	Int8       Kind = "int8"
+	Int16      Kind = "int16"
+	Int32      Kind = "int32"
+	Int64      Kind = "int64"
+	Uint       Kind = "uint"
+	Uint8      Kind = "uint8"
+	Uint16     Kind = "uint16"
+	Uint32     Kind = "uint32"
+	Uint64     Kind = "uint64"
+	Uintptr    Kind = "uintptr"
+	Float32    Kind = "float32"
+	Float64    Kind = "float64"
+	Complex64  Kind = "complex64"
+	Complex128 Kind = "complex128"
+	String     Kind = "string"
+	Slice      Kind = "slice"
+	Array      Kind = "array"
+	Map        Kind = "map"
+	Struct     Kind = "struct"
+	Interface  Kind = "interface"
+	// Ref refers to the named struct type Type.Name in Schema.Types.
+	Ref Kind = "ref"
+	// Opaque is a type that encodes itself (GobEncoder, BinaryMarshaler
+	// or TextMarshaler); Type.Name identifies it.
+	Opaque Kind = "opaque"
+)
+
+// Type describes the wire shape of a Go type.
+type Type struct {
+	Kind Kind `json:"kind"`
+	// Name is the qualified type name of Ref and Opaque types.
+	Name string `json:"name,omitempty"`
+	// Len is the length of an Array.
+	Len int64 `json:"len,omitempty"`
+	// Key and Elem are the key and element types of maps, slices and
+	// arrays.
+	Key  *Type `json:"key,omitempty"`
+	Elem *Type `json:"elem,omitempty"`
+	// Fields are the encoded fields of a Struct, in declaration order.
+	Fields []*Field `json:"fields,omitempty"`
+}
+
+// Field is an encoded struct field. Embedded structs are fields named
+// after their type, as gob sends them.
+type Field struct {
+	Name string `json:"name"`
+	Type *Type  `json:"type"`
+}
+
+// String renders t in Go syntax, with named types by their qualified
+// name.
+func (t *Type) String() string {
+	switch t.Kind {
+	case Ref, Opaque:
+		return t.Name
+	case Slice:
+		return "[]" + t.Elem.String()
+	case Array:
+		return fmt.Sprintf("[%d]%s", t.Len, t.Elem.String())
+	case Map:
+		return "map[" + t.Key.String() + "]" + t.Elem.String()
+	case Struct:
+		fields := make([]string, len(t.Fields))
+		for i, f := range t.Fields {
+			fields[i] = f.Name + " " + f.Type.String()
+		}
+		return "struct{" + strings.Join(fields, "; ") + "}"
+	case Interface:
+		return "interface{}"
+	}
+	return string(t.Kind)
+}
+
+// Signature renders m as a Go method signature, e.g.
+// "Watch(string) (<-chan example.com/chat.Message, error)". The context
+// parameter is not part of the wire contract and is omitted.
+func (m *Method) Signature() string {
+	var params, results []string
+	for _, a := range m.Args {
+		params = append(params, a.String())
+	}
+	if m.StreamIn != nil {
+		params = append(params, "<-chan "+m.StreamIn.String())
+	}
+	if m.StreamOut != nil {
+		results = append(results, "<-chan "+m.StreamOut.String())
+	}
+	for _, r := range m.Results {
+		results = append(results, r.String())
+	}
+	results = append(results, "error")
+	out := strings.Join(results, ", ")
+	if len(results) > 1 {
+		out = "(" + out + ")"
+	}
+	return m.Name + "(" + strings.Join(params, ", ") + ") " + out
+}
+
+// Service returns the service called name, or nil.
+func (s *Schema) Service(name string) *Service {
+	for _, svc := range s.Services {
+		if svc.Name == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+// Method returns the method called name, or nil.
+func (s *Service) Method(name string) *Method {
+	for _, m := range s.Methods {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Write encodes s as indented JSON. Services, methods and fields keep
+// their sorted or declaration order and type definitions are sorted by
+// name, so the output is stable.
+func (s *Schema) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Read decodes a schema written by Write.
+func Read(r io.Reader) (*Schema, error) {
+	var s Schema
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("schema: decode: %v", err)
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("schema: unsupported version %d, want %d", s.Version, Version)
+	}
+	return &s, nil
+}
+
+// ReadFile reads the schema stored in file.
+func ReadFile(file string) (*Schema, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return s, nil
+}
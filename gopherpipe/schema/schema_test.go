@@ -0,0 +1,95 @@
+package schema
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/internal/contract/contracttest"
+)
+
+// loadSource writes src as the only file of a temporary module and loads
+// its schema.
+func loadSource(t *testing.T, src string) *Schema {
+	t.Helper()
+	s, err := Load(contracttest.WriteModule(t, map[string]string{"svc.go": src}))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return s
+}
+
+const v1 = `package svc
+
+import (
+	"context"
+	"time"
+)
+
+type Node struct {
+	Name     string
+	Children []*Node
+	Count    int32
+	At       time.Time
+	hidden   int
+}
+
+//gopherpipe:service
+type TreeService interface {
+	Get(ctx context.Context, id string) (*Node, error)
+	Walk(ctx context.Context, root Node) (<-chan Node, error)
+	Size(ids []string) (map[string]int64, error)
+	Ping() error
+}
+`
+
+// TestLoadDescribesTypeGraph verifies recursive structs become shared
+// definitions, pointers are flattened, unexported fields are skipped and
+// self-encoding types are opaque.
+func TestLoadDescribesTypeGraph(t *testing.T) {
+	s := loadSource(t, v1)
+	node := s.Types["example.com/svc.Node"]
+	if node == nil || len(node.Fields) != 4 {
+		t.Fatalf("Node definition = %+v", node)
+	}
+	if f := node.Fields[1]; f.Name != "Children" || f.Type.Kind != Slice || f.Type.Elem.Kind != Ref {
+		t.Fatalf("Children = %+v", f.Type)
+	}
+	if f := node.Fields[3]; f.Type.Kind != Opaque || f.Type.Name != "time.Time" {
+		t.Fatalf("At = %+v", f.Type)
+	}
+	get := s.Service("TreeService").Method("Get")
+	if len(get.Args) != 1 || get.Results[0].Kind != Ref {
+		t.Fatalf("Get = %+v", get)
+	}
+	walk := s.Service("TreeService").Method("Walk")
+	if walk.RPCType != ServerStream || walk.StreamOut.Name != "example.com/svc.Node" || len(walk.Results) != 0 {
+		t.Fatalf("Walk = %+v", walk)
+	}
+}
+
+// TestWriteReadRoundTrip verifies the JSON form is stable and decodes to
+// an identical schema.
+func TestWriteReadRoundTrip(t *testing.T) {
+	s := loadSource(t, v1)
+	var a, b bytes.Buffer
+	if err := s.Write(&a); err != nil {
+		t.Fatal(err)
+	}
+	back, err := Read(bytes.NewReader(a.Bytes()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := back.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("round trip changed the schema:\n%s\n---\n%s", a.Bytes(), b.Bytes())
+	}
+	if changes := Compare(s, back); len(changes) != 0 {
+		t.Fatalf("schema differs from itself: %v", changes)
+	}
+	if _, err := Read(strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Fatalf("unknown version accepted")
+	}
+}
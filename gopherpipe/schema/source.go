@@ -0,0 +1,134 @@
+package schema
+
+import (
+	"go/types"
+
+	"github.com/anthony/gopher-pipe/internal/contract"
+)
+
+// Load builds the schema of the services marked with //gopherpipe:service
+// in the Go package in dir.
+func Load(dir string) (*Schema, error) {
+	pkg, err := contract.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{Version: Version, Package: pkg.Path, Types: make(map[string]*Type)}
+	b := &sourceBuilder{s: s}
+	for _, svc := range pkg.Services {
+		out := &Service{Name: svc.Name}
+		for _, m := range svc.Methods {
+			out.Methods = append(out.Methods, b.method(m))
+		}
+		s.Services = append(s.Services, out)
+	}
+	return s, nil
+}
+
+// sourceBuilder converts go/types types, recording named structs in s.
+type sourceBuilder struct {
+	s *Schema
+}
+
+func (b *sourceBuilder) method(m *contract.Method) *Method {
+	out := &Method{Name: m.Name, RPCType: m.RPCType, Args: []*Type{}, Results: []*Type{}}
+	for _, p := range m.Args() {
+		out.Args = append(out.Args, b.typ(p.Type))
+	}
+	if in := m.InElem(); in != nil {
+		out.StreamIn = b.typ(in)
+	}
+	if elem := m.OutElem(); elem != nil {
+		out.StreamOut = b.typ(elem)
+		return out
+	}
+	for _, r := range m.Results {
+		out.Results = append(out.Results, b.typ(r))
+	}
+	return out
+}
+
+var basicKinds = map[types.BasicKind]Kind{
+	types.Bool: Bool, types.Int: Int, types.Int8: Int8, types.Int16: Int16,
+	types.Int32: Int32, types.Int64: Int64, types.Uint: Uint, types.Uint8: Uint8,
+	types.Uint16: Uint16, types.Uint32: Uint32, types.Uint64: Uint64,
+	types.Uintptr: Uintptr, types.Float32: Float32, types.Float64: Float64,
+	types.Complex64: Complex64, types.Complex128: Complex128, types.String: String,
+}
+
+// typ describes t as gob encodes it.
+func (b *sourceBuilder) typ(t types.Type) *Type {
+	for {
+		p, ok := t.(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = p.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		name := types.TypeString(named, nil)
+		if selfEncoding(named) {
+			return &Type{Kind: Opaque, Name: name}
+		}
+		if st, ok := named.Underlying().(*types.Struct); ok {
+			if _, done := b.s.Types[name]; !done {
+				def := &Type{Kind: Struct}
+				b.s.Types[name] = def
+				def.Fields = b.fields(st)
+			}
+			return &Type{Kind: Ref, Name: name}
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if k, ok := basicKinds[u.Kind()]; ok {
+			return &Type{Kind: k}
+		}
+	case *types.Slice:
+		return &Type{Kind: Slice, Elem: b.typ(u.Elem())}
+	case *types.Array:
+		return &Type{Kind: Array, Len: u.Len(), Elem: b.typ(u.Elem())}
+	case *types.Map:
+		return &Type{Kind: Map, Key: b.typ(u.Key()), Elem: b.typ(u.Elem())}
+	case *types.Struct:
+		return &Type{Kind: Struct, Fields: b.fields(u)}
+	case *types.Interface:
+		return &Type{Kind: Interface}
+	case *types.Pointer:
+		return b.typ(u)
+	}
+	// Channels, functions and unsafe pointers cannot be encoded; the
+	// contract loader rejects them at the top level, so they only reach
+	// here nested in other types and are kept as opaque names.
+	return &Type{Kind: Opaque, Name: types.TypeString(t, nil)}
+}
+
+// fields lists the fields of st that gob encodes: exported ones that are
+// not channels or functions.
+func (b *sourceBuilder) fields(st *types.Struct) []*Field {
+	var fields []*Field
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		switch f.Type().Underlying().(type) {
+		case *types.Chan, *types.Signature:
+			continue
+		}
+		fields = append(fields, &Field{Name: f.Name(), Type: b.typ(f.Type())})
+	}
+	return fields
+}
+
+// selfEncoding reports whether gob encodes values of t through one of
+// their own marshaling methods rather than by structure.
+func selfEncoding(t *types.Named) bool {
+	mset := types.NewMethodSet(types.NewPointer(t))
+	for _, name := range []string{"GobEncode", "MarshalBinary", "MarshalText"} {
+		if mset.Lookup(nil, name) != nil {
+			return true
+		}
+	}
+	return false
+}
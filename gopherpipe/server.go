@@ -1,15 +1,23 @@
 package gopherpipe
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/anthony/gopher-pipe/internal/codec"
+	"github.com/anthony/gopher-pipe/internal/connlimit"
+	"github.com/anthony/gopher-pipe/internal/connmux"
 	"github.com/anthony/gopher-pipe/internal/tcplite"
 )
 
@@ -19,24 +27,113 @@ import (
 type Server struct {
 	addr     string
 	mu       sync.RWMutex
-	services map[string]interface{}
+	services map[string]*service
+
+	panicHandler  PanicHandler
+	httpHandler   http.Handler
+	tlsConfig     *tls.Config
+	authenticator Authenticator
+	authorizer    Authorizer
+	connLimits    ConnLimits
+	frameLimits   tcplite.Limits
+	keepalive     KeepaliveParams
+	pingPolicy    *KeepaliveEnforcement
+}
+
+// PanicHandler is invoked when a service method panics. It receives the
+// service and method names, the recovered value and the goroutine stack at
+// the point of the panic. The call itself is always failed with Internal.
+type PanicHandler func(service, method string, recovered interface{}, stack []byte)
+
+// ServerOption configures optional Server behaviour in NewServer.
+type ServerOption func(*Server)
+
+// WithPanicHandler replaces the default panic handler, which logs the
+// panic and its stack trace, so panics can be reported elsewhere (for
+// example to a crash collector).
+func WithPanicHandler(h PanicHandler) ServerOption {
+	return func(s *Server) {
+		s.panicHandler = h
+	}
+}
+
+// WithHTTPHandler serves HTTP requests arriving on the server's port with
+// h, alongside TCP_LITE. Connections are told apart by their first byte,
+// so health probes, metrics and debug pages need no second listener.
+func WithHTTPHandler(h http.Handler) ServerOption {
+	return func(s *Server) {
+		s.httpHandler = h
+	}
 }
 
 // NewServer creates a new Server listening on the supplied address.
 // The server automatically registers the Envelope type with gob so tests
 // and examples can rely on stable serialization.
-func NewServer(addr string) *Server {
+func NewServer(addr string, opts ...ServerOption) *Server {
 	// register envelope type
 	gob.Register(Envelope{})
-	return &Server{addr: addr, services: make(map[string]interface{})}
+	s := &Server{addr: addr, services: make(map[string]*service), panicHandler: logPanic}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// logPanic is the default PanicHandler.
+func logPanic(service, method string, recovered interface{}, stack []byte) {
+	log.Printf("panic in %s/%s: %v\n%s", service, method, recovered, stack)
+}
+
+// Register adds a service implementation under a logical name. Every
+// exported method of impl becomes callable; their signatures are validated
+// here and an error lists any method the dispatcher cannot serve.
+func (s *Server) Register(name string, impl interface{}) error {
+	svc, err := newService(name, impl)
+	if err != nil {
+		return err
+	}
+	return s.addService(svc)
+}
+
+// RegisterService registers impl under the name of the contract interface
+// I (for example "ChatService" for chat.ChatService). Only the methods
+// declared by I are callable remotely; helper methods on the concrete type
+// stay private.
+func RegisterService[I any](s *Server, impl I) error {
+	iface := reflect.TypeOf((*I)(nil)).Elem()
+	return RegisterServiceAs(s, iface.Name(), impl)
+}
+
+// RegisterServiceAs is RegisterService with an explicit service name, for
+// anonymous contract interfaces or when two versions of a contract are
+// served side by side.
+func RegisterServiceAs[I any](s *Server, name string, impl I) error {
+	iface := reflect.TypeOf((*I)(nil)).Elem()
+	if iface.Kind() != reflect.Interface {
+		return fmt.Errorf("gopherpipe: register %s: %s is not an interface type", name, iface)
+	}
+	if name == "" {
+		return fmt.Errorf("gopherpipe: register: %s has no name, use RegisterServiceAs", iface)
+	}
+	if reflect.ValueOf(&impl).Elem().IsNil() {
+		return fmt.Errorf("gopherpipe: register %s: nil implementation", name)
+	}
+	svc, err := buildService(name, impl, iface)
+	if err != nil {
+		return err
+	}
+	return s.addService(svc)
 }
 
-// Register adds a service implementation under a logical name. Example
-// code calls methods on the registered implementation via reflection.
-func (s *Server) Register(name string, impl interface{}) {
+// addService installs svc in the registry, rejecting duplicate names.
+func (s *Server) addService(svc *service) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.services[name] = impl
+	if _, ok := s.services[svc.name]; ok {
+		return fmt.Errorf("gopherpipe: service %s already registered", svc.name)
+	}
+	s.services[svc.name] = svc
+	return nil
 }
 
 // Serve begins listening for TCP connections and handles incoming frames
@@ -46,9 +143,32 @@ func (s *Server) Serve() error {
 	if err != nil {
 		return err
 	}
+	return s.ServeListener(ln)
+}
+
+// ServeListener handles connections accepted from ln. It returns once the
+// listener is closed, which lets tests run a server on an ephemeral port.
+func (s *Server) ServeListener(ln net.Listener) error {
+	if l := s.connLimits; l.MaxConns > 0 || l.MaxConnsPerIP > 0 {
+		ln = connlimit.New(ln, l.MaxConns, l.MaxConnsPerIP)
+	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	if s.httpHandler != nil {
+		mux := connmux.New(ln)
+		mux.PeekTimeout = s.connLimits.IdleTimeout
+		mux.HandshakeTimeout = handshakeTimeout
+		go func() { _ = mux.Serve() }()
+		go func() { _ = s.connLimits.httpServer(s.httpHandler).Serve(mux.HTTP()) }()
+		ln = mux.Frames()
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Println("accept error:", err)
 			continue
 		}
@@ -56,93 +176,391 @@ func (s *Server) Serve() error {
 	}
 }
 
+// serverConn is the per-connection state shared by the calls running on
+// it. Calls are served concurrently, so frame writes are serialised and
+// ctx is cancelled when the connection goes away.
+type serverConn struct {
+	conn   net.Conn
+	frames *tcplite.Conn
+	wmu    sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// running holds the cancel functions of the calls in progress, so a
+	// cancel frame from the caller can stop them, and inbound the
+	// client-to-server streams of the client-streaming and bidirectional
+	// ones until the caller ends them or the call returns.
+	mu      sync.Mutex
+	running map[uint64]context.CancelFunc
+	inbound map[uint64]*inboundStream
+}
+
+// track records the cancel function and inbound stream, if any, of a call
+// until its dispatch ends.
+func (sc *serverConn) track(id uint64, cancel context.CancelFunc, in *inboundStream) {
+	sc.mu.Lock()
+	sc.running[id] = cancel
+	if in != nil {
+		sc.inbound[id] = in
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *serverConn) untrack(id uint64) {
+	sc.mu.Lock()
+	delete(sc.running, id)
+	delete(sc.inbound, id)
+	sc.mu.Unlock()
+}
+
+// cancelCall stops the call id if it is still running.
+func (sc *serverConn) cancelCall(id uint64) {
+	sc.mu.Lock()
+	cancel := sc.running[id]
+	delete(sc.inbound, id)
+	sc.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// calls returns the number of calls in progress.
+func (sc *serverConn) calls() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.running)
+}
+
+// stream returns the inbound stream of the call id, or nil.
+func (sc *serverConn) stream(id uint64) *inboundStream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.inbound[id]
+}
+
+func (sc *serverConn) endStream(id uint64) {
+	sc.mu.Lock()
+	delete(sc.inbound, id)
+	sc.mu.Unlock()
+}
+
+// maxInboundQueue is the number of stream messages queued for a call
+// before the call fails with ResourceExhausted.
+const maxInboundQueue = 1024
+
+// inboundStream queues the messages a caller streams to a running call.
+// The connection's read loop queues them without blocking, so a handler
+// that stops reading cannot stall the other calls on the connection; a
+// goroutine forwards them to ch. A queued message keeps the frame payload
+// it arrived in, and so its share of the server's PayloadBudget, until
+// the handler takes it.
+type inboundStream struct {
+	ch      chan Envelope
+	ctx     context.Context
+	release func(payload []byte)
+
+	mu      sync.Mutex
+	queue   []inboundMessage
+	wake    chan struct{} // signalled when queue grows
+	stopped bool          // set once forward has returned
+}
+
+// inboundMessage is a queued stream message and the payload it was
+// decoded from.
+type inboundMessage struct {
+	env     Envelope
+	payload []byte
+}
+
+func newInboundStream(ctx context.Context, ch chan Envelope, release func([]byte)) *inboundStream {
+	in := &inboundStream{ch: ch, ctx: ctx, release: release, wake: make(chan struct{}, 1)}
+	go in.forward()
+	return in
+}
+
+// deliver queues one inbound stream envelope for the call, taking over
+// payload. It reports false, leaving payload to the caller, if the queue
+// is full.
+func (in *inboundStream) deliver(env Envelope, payload []byte) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.stopped {
+		// nobody is left to read it
+		in.release(payload)
+		return true
+	}
+	if len(in.queue) >= maxInboundQueue {
+		return false
+	}
+	in.queue = append(in.queue, inboundMessage{env, payload})
+	select {
+	case in.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next dequeues the oldest message, if any.
+func (in *inboundStream) next() (inboundMessage, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.queue) == 0 {
+		return inboundMessage{}, false
+	}
+	m := in.queue[0]
+	in.queue[0] = inboundMessage{}
+	in.queue = in.queue[1:]
+	return m, true
+}
+
+// forward hands the queued messages to ch, which is unbuffered so a
+// message is only released once the handler has received it, and closes
+// ch when the caller ends its stream. It stops when the call's context is
+// done, releasing whatever is still queued.
+func (in *inboundStream) forward() {
+	defer in.stop()
+	for {
+		m, ok := in.next()
+		if !ok {
+			select {
+			case <-in.wake:
+				continue
+			case <-in.ctx.Done():
+				return
+			}
+		}
+		if m.env.EndStream {
+			in.release(m.payload)
+			close(in.ch)
+			return
+		}
+		select {
+		case in.ch <- m.env:
+			in.release(m.payload)
+		case <-in.ctx.Done():
+			in.release(m.payload)
+			return
+		}
+	}
+}
+
+// stop releases the queued messages and drops those delivered later.
+func (in *inboundStream) stop() {
+	in.mu.Lock()
+	queue := in.queue
+	in.queue = nil
+	in.stopped = true
+	in.mu.Unlock()
+	for _, m := range queue {
+		in.release(m.payload)
+	}
+}
+
+// write encodes env and sends it in a frame of the given type.
+func (sc *serverConn) write(ftype byte, env Envelope) error {
+	b, err := codec.Encode(env)
+	if err != nil {
+		return err
+	}
+	return sc.writeFrame(ftype, b)
+}
+
+// writeFrame sends a frame of the given type.
+func (sc *serverConn) writeFrame(ftype byte, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return tcplite.WriteFrame(sc.conn, ftype, payload)
+}
+
+// writeError sends err to the caller as an error envelope matching req's
+// CallID. Errors that are not a *Status are reported as Unknown.
+func (sc *serverConn) writeError(req Envelope, err error) error {
+	st := StatusOf(err)
+	env := Envelope{RPCType: req.RPCType, ServiceName: req.ServiceName, MethodName: req.MethodName, CallID: req.CallID, Code: st.Code, Message: st.Message}
+	return sc.write(tcplite.FrameTypeError, env)
+}
+
 // handleConn reads frames from a single connection and dispatches requests
 // to registered services. It's invoked in a goroutine per accepted
-// connection.
+// connection; each call is then served in its own goroutine.
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
+	peer, err := serverPeer(conn)
+	if err != nil {
+		log.Println("tls handshake error:", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), peerKey{}, peer))
+	frames := tcplite.NewConn(conn, s.frameLimits)
+	sc := &serverConn{conn: conn, frames: frames, ctx: ctx, cancel: cancel, running: make(map[uint64]context.CancelFunc), inbound: make(map[uint64]*inboundStream)}
+	defer cancel()
+	peer.ka = newKeepalive(s.keepalive, frames.Busy, func(p []byte) error {
+		return sc.writeFrame(tcplite.FrameTypeHeartbeat, p)
+	}, func() {
+		log.Printf("keepalive timeout, closing connection from %v", peer.Addr)
+		conn.Close()
+	})
+	if s.keepalive.Time > 0 {
+		go peer.ka.run(ctx.Done())
+	}
+	var pings *pingEnforcer
+	if s.pingPolicy != nil {
+		pings = &pingEnforcer{policy: *s.pingPolicy}
+	}
 	for {
-		ftype, payload, err := tcplite.ReadFrame(conn)
+		ftype, payload, err := frames.ReadFrame()
 		if err != nil {
-			log.Println("read frame error:", err)
+			switch {
+			case errors.Is(err, tcplite.ErrIdleTimeout):
+				// tell the client the connection was not lost
+				_ = sc.writeFrame(tcplite.FrameTypeClose, nil)
+			case !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed):
+				log.Println("read frame error:", err)
+			}
 			return
 		}
+		peer.ka.received()
+		if ftype == tcplite.FrameTypeHeartbeat {
+			kind, ok := peer.ka.heartbeat(payload)
+			frames.Release(payload)
+			if ok && kind == tcplite.HeartbeatPing && pings != nil && !pings.allow(frames.Busy()) {
+				log.Printf("closing connection from %v: too many pings", peer.Addr)
+				_ = sc.writeFrame(tcplite.FrameTypeClose, []byte("too many pings"))
+				return
+			}
+			continue
+		}
+		if ftype == tcplite.FrameTypeCancel {
+			if id, ok := tcplite.DecodeCancel(payload); ok {
+				sc.cancelCall(id)
+			}
+			frames.Release(payload)
+			continue
+		}
 		if ftype != tcplite.FrameTypeData {
+			frames.Release(payload)
 			continue
 		}
 		var env Envelope
-		if err := codec.Decode(payload, &env); err != nil {
+		err = codec.Decode(payload, &env)
+		if err != nil {
+			frames.Release(payload)
 			log.Println("decode envelope:", err)
-			_ = tcplite.WriteFrame(conn, tcplite.FrameTypeError, []byte(err.Error()))
+			_ = sc.writeError(Envelope{}, Errorf(InvalidArgument, "decode envelope: %v", err))
 			continue
 		}
-		// naive: look up service and reflect-call method name if possible
-		s.mu.RLock()
-		impl := s.services[env.ServiceName]
-		s.mu.RUnlock()
-		if impl == nil {
-			_ = tcplite.WriteFrame(conn, tcplite.FrameTypeError, []byte("service not found"))
+		if env.StreamMessage {
+			// a queued message holds on to its payload, see inboundStream
+			in := sc.stream(env.CallID)
+			switch {
+			case in == nil:
+				// the call has returned
+				frames.Release(payload)
+			case env.EndStream:
+				in.deliver(env, payload)
+				sc.endStream(env.CallID)
+			case !in.deliver(env, payload):
+				frames.Release(payload)
+				sc.cancelCall(env.CallID)
+				_ = sc.writeError(env, Errorf(ResourceExhausted, "%s/%s: more than %d stream messages queued", env.ServiceName, env.MethodName, maxInboundQueue))
+			}
 			continue
 		}
-		// For the prototype we expect a unary call where method takes (in) and returns (out, error)
-		// We'll use reflection to call the method
-		respEnv, err := s.handleUnaryCall(impl, env)
-		if err != nil {
-			_ = tcplite.WriteFrame(conn, tcplite.FrameTypeError, []byte(err.Error()))
+		frames.Release(payload)
+		if limit := s.connLimits.MaxConcurrentCalls; limit > 0 && sc.calls() >= limit {
+			_ = sc.writeError(env, Errorf(ResourceExhausted, "%s/%s: more than %d concurrent calls on the connection", env.ServiceName, env.MethodName, limit))
 			continue
 		}
-		if err := tcplite.WriteFrame(conn, tcplite.FrameTypeData, respEnv); err != nil {
-			log.Println("write reply error:", err)
-			return
+		callCtx, callCancel := callContext(sc.ctx, env)
+		call := &ServerCall{ctx: callCtx, sc: sc, env: env}
+		var in *inboundStream
+		if env.RPCType == ClientStream || env.RPCType == BiDi {
+			call.input = make(chan Envelope)
+			in = newInboundStream(callCtx, call.input, frames.Release)
 		}
+		frames.Begin()
+		sc.track(env.CallID, callCancel, in)
+		go s.dispatch(callCtx, callCancel, call)
 	}
 }
 
-// handleUnaryCall performs reflection-based invocation of a simple unary
-// method (one argument, returns (T, error)). It decodes the incoming
-// argument, calls the method, and re-encodes the return value into a new
-// Envelope payload.
-func (s *Server) handleUnaryCall(impl interface{}, env Envelope) ([]byte, error) {
-	// Reflection-based invocation for simple signatures.
-	mv := reflect.ValueOf(impl)
-	method := mv.MethodByName(env.MethodName)
-	if !method.IsValid() {
-		return nil, fmt.Errorf("method %s not found", env.MethodName)
+// lookup resolves the dispatch table entry addressed by env and checks
+// the caller asked for the method's RPC type.
+func (s *Server) lookup(env Envelope) (*methodDesc, error) {
+	s.mu.RLock()
+	svc := s.services[env.ServiceName]
+	s.mu.RUnlock()
+	if svc == nil {
+		return nil, Errorf(NotFound, "service %s not found", env.ServiceName)
 	}
-	mtype := method.Type()
-	if mtype.NumIn() != 1 {
-		return nil, errors.New("only single-arg unary methods supported in prototype")
+	method := svc.methods[env.MethodName]
+	if method == nil {
+		return nil, Errorf(Unimplemented, "method %s/%s not found", env.ServiceName, env.MethodName)
 	}
-	if mtype.NumOut() != 2 {
-		return nil, errors.New("method must return (T, error)")
+	if env.RPCType != method.rpcType {
+		return nil, Errorf(FailedPrecondition, "method %s/%s has RPC type %d, called as %d", env.ServiceName, env.MethodName, method.rpcType, env.RPCType)
 	}
+	return method, nil
+}
 
-	// prepare argument value of required type
-	argType := mtype.In(0)
-	argPtr := reflect.New(argType)
-	// decode body into argPtr.Interface()
-	if err := codec.Decode(env.Body, argPtr.Interface()); err != nil {
-		return nil, err
+// callContext derives the context of a call from its connection's,
+// applying the caller's timeout and metadata.
+func callContext(parent context.Context, env Envelope) (context.Context, context.CancelFunc) {
+	if env.Metadata != nil {
+		parent = context.WithValue(parent, incomingKey{}, env.Metadata)
 	}
-	args := []reflect.Value{argPtr.Elem()}
-	// call method
-	results := method.Call(args)
-	// result value and error
-	resVal := results[0].Interface()
-	var callErr error
-	if !results[1].IsNil() {
-		callErr = results[1].Interface().(error)
+	if env.Timeout > 0 {
+		return context.WithTimeout(parent, time.Duration(env.Timeout))
 	}
-	if callErr != nil {
-		return nil, callErr
+	return context.WithCancel(parent)
+}
+
+// dispatch authenticates and authorizes a single call, runs its method
+// handler and then completes the call: a unary reply, the end of a
+// stream, or an error envelope. The method is only looked up once the
+// call is authorized, so callers without access cannot probe which
+// services and methods exist.
+func (s *Server) dispatch(ctx context.Context, cancel context.CancelFunc, call *ServerCall) {
+	defer call.sc.frames.End()
+	defer call.sc.untrack(call.env.CallID)
+	defer cancel()
+	ctx, err := s.authenticate(ctx, call)
+	if err == nil {
+		err = s.authorize(ctx, call)
 	}
-	// encode response body
-	outb, err := codec.Encode(resVal)
-	if err != nil {
-		return nil, err
+	var method *methodDesc
+	if err == nil {
+		method, err = s.lookup(call.env)
+	}
+	if err == nil {
+		err = s.runHandler(ctx, method, call)
+	}
+	if err == nil {
+		err = call.RecvErr()
 	}
-	respEnv := Envelope{RPCType: Unary, ServiceName: env.ServiceName, MethodName: env.MethodName, CallID: env.CallID, Body: outb}
-	return codec.Encode(respEnv)
+	if err == nil {
+		err = call.finish()
+	}
+	if err != nil && ctx.Err() == nil {
+		if err := call.sc.writeError(call.env, err); err != nil {
+			log.Println("write error reply:", err)
+		}
+	}
+}
+
+// runHandler invokes the method handler and converts a panic inside the
+// service implementation into an Internal status, so one misbehaving call
+// cannot take down the server or the caller's connection.
+func (s *Server) runHandler(ctx context.Context, method *methodDesc, call *ServerCall) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if s.panicHandler != nil {
+				s.panicHandler(call.env.ServiceName, call.env.MethodName, r, debug.Stack())
+			}
+			err = Errorf(Internal, "panic in %s/%s: %v", call.env.ServiceName, call.env.MethodName, r)
+		}
+	}()
+	return method.handler(ctx, call)
 }
 
 func init() {
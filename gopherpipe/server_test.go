@@ -0,0 +1,159 @@
+package gopherpipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type panicky struct{}
+
+func (p *panicky) Boom(in string) (string, error) {
+	panic("boom: " + in)
+}
+
+func (p *panicky) Echo(in string) (string, error) {
+	return in, nil
+}
+
+// startTestServer serves srv on an ephemeral local port and returns a
+// connected client. Both are torn down when the test finishes.
+func startTestServer(t *testing.T, srv *Server) *Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = srv.ServeListener(ln) }()
+	c, err := Dial(ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		ln.Close()
+	})
+	return c
+}
+
+// TestPanicRecovered verifies a panicking method is reported as Internal
+// to the caller, handed to the panic handler and leaves the connection
+// usable for later calls.
+func TestPanicRecovered(t *testing.T) {
+	type panicInfo struct {
+		recovered interface{}
+		stack     []byte
+	}
+	panics := make(chan panicInfo, 1)
+	srv := NewServer("", WithPanicHandler(func(service, method string, recovered interface{}, stack []byte) {
+		panics <- panicInfo{recovered, stack}
+	}))
+	if err := srv.Register("P", &panicky{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	c := startTestServer(t, srv)
+
+	var out string
+	err := c.CallUnary("P", "Boom", "x", &out)
+	if CodeOf(err) != Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+	got := <-panics
+	if got.recovered != "boom: x" || !strings.Contains(string(got.stack), "Boom") {
+		t.Fatalf("panic handler not invoked correctly: %v", got.recovered)
+	}
+
+	if err := c.CallUnary("P", "Echo", "still alive", &out); err != nil {
+		t.Fatalf("call after panic: %v", err)
+	}
+	if out != "still alive" {
+		t.Fatalf("unexpected echo: %q", out)
+	}
+}
+
+// TestUnknownServiceStatus verifies dispatch failures carry a status code.
+func TestUnknownServiceStatus(t *testing.T) {
+	c := startTestServer(t, NewServer(""))
+	var out string
+	if err := c.CallUnary("Missing", "Echo", "x", &out); CodeOf(err) != NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+type board struct {
+	moves []string
+}
+
+func (b *board) Move(from, to string) error {
+	if from == to {
+		return Errorf(InvalidArgument, "no-op move %s", from)
+	}
+	b.moves = append(b.moves, from+"-"+to)
+	return nil
+}
+
+func (b *board) Count() (int, error) { return len(b.moves), nil }
+
+func (b *board) Last(ctx context.Context) (string, int, error) {
+	if ctx == nil {
+		return "", 0, Errorf(Internal, "missing context")
+	}
+	return b.moves[len(b.moves)-1], len(b.moves), nil
+}
+
+// TestMultiArgumentDispatch covers zero-argument, multi-argument,
+// error-only and multi-result methods as well as a leading context
+// parameter that is not sent over the wire.
+func TestMultiArgumentDispatch(t *testing.T) {
+	srv := NewServer("")
+	if err := srv.Register("Board", &board{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	c := startTestServer(t, srv)
+	ctx := context.Background()
+
+	if err := c.Call(ctx, "Board", "Move", []interface{}{"e2", "e4"}); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if err := c.Call(ctx, "Board", "Move", []interface{}{"e4", "e4"}); CodeOf(err) != InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	var n int
+	if err := c.Call(ctx, "Board", "Count", nil, &n); err != nil || n != 1 {
+		t.Fatalf("count: %d %v", n, err)
+	}
+	var last string
+	if err := c.Call(ctx, "Board", "Last", nil, &last, &n); err != nil || last != "e2-e4" || n != 1 {
+		t.Fatalf("last: %q %d %v", last, n, err)
+	}
+}
+
+// TestHTTPHandlerSamePort verifies WithHTTPHandler serves HTTP on the
+// server's listener while RPCs keep working.
+func TestHTTPHandlerSamePort(t *testing.T) {
+	srv := NewServer("", WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok "+r.URL.Path)
+	})))
+	if err := srv.Register("Board", &board{}); err != nil {
+		t.Fatal(err)
+	}
+	c := startTestServer(t, srv)
+
+	resp, err := http.Get("http://" + c.backends[0].sc.addr + "/metrics")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok /metrics" {
+		t.Fatalf("body = %q", body)
+	}
+	var n int
+	if err := c.Call(context.Background(), "Board", "Count", nil, &n); err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package gopherpipe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is a small, gRPC-compatible status code carried in error replies so
+// callers can react to a failure class without parsing message strings.
+type Code uint32
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = map[Code]string{
+	OK:                 "OK",
+	Canceled:           "CANCELED",
+	Unknown:            "UNKNOWN",
+	InvalidArgument:    "INVALID_ARGUMENT",
+	DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	NotFound:           "NOT_FOUND",
+	AlreadyExists:      "ALREADY_EXISTS",
+	PermissionDenied:   "PERMISSION_DENIED",
+	ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	FailedPrecondition: "FAILED_PRECONDITION",
+	Aborted:            "ABORTED",
+	OutOfRange:         "OUT_OF_RANGE",
+	Unimplemented:      "UNIMPLEMENTED",
+	Internal:           "INTERNAL",
+	Unavailable:        "UNAVAILABLE",
+	DataLoss:           "DATA_LOSS",
+	Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// String returns the canonical upper-case name of the code.
+func (c Code) String() string {
+	if n, ok := codeNames[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("CODE(%d)", uint32(c))
+}
+
+// Status is the error type returned by the client for failed calls and
+// produced by the server when a handler fails. Handlers may return a
+// *Status themselves to choose the code sent to the caller.
+type Status struct {
+	Code    Code
+	Message string
+}
+
+// Error implements the error interface.
+func (s *Status) Error() string {
+	return fmt.Sprintf("gopherpipe: %s: %s", s.Code, s.Message)
+}
+
+// Errorf builds a *Status error with the given code and formatted message.
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// StatusOf converts err into a *Status. A nil error maps to OK and errors
+// that do not wrap a *Status map to Unknown with the error text preserved.
+func StatusOf(err error) *Status {
+	if err == nil {
+		return &Status{Code: OK}
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st
+	}
+	return &Status{Code: Unknown, Message: err.Error()}
+}
+
+// CodeOf returns the status code carried by err (see StatusOf).
+func CodeOf(err error) Code {
+	return StatusOf(err).Code
+}
@@ -0,0 +1,250 @@
+package gopherpipe
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of a client's connection to a server.
+type ConnState int32
+
+// Connection states. A connection starts READY after Dial. When it is
+// lost it goes IDLE, and the next call reconnects it (CONNECTING). A
+// failed attempt leaves it in TRANSIENT_FAILURE until the backoff delay
+// has passed and the next attempt starts. Close moves it to SHUTDOWN for
+// good.
+const (
+	StateIdle ConnState = iota
+	StateConnecting
+	StateReady
+	StateTransientFailure
+	StateShutdown
+)
+
+var connStateNames = map[ConnState]string{
+	StateIdle:             "IDLE",
+	StateConnecting:       "CONNECTING",
+	StateReady:            "READY",
+	StateTransientFailure: "TRANSIENT_FAILURE",
+	StateShutdown:         "SHUTDOWN",
+}
+
+func (s ConnState) String() string {
+	if n, ok := connStateNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("STATE(%d)", int32(s))
+}
+
+// BackoffConfig spaces out reconnection attempts. The delay after the
+// n-th consecutive failure is BaseDelay * Multiplier^n, capped at
+// MaxDelay and randomised by ±Jitter so clients that lost the same
+// server do not reconnect in lockstep. Zero fields take the defaults of
+// DefaultBackoff.
+type BackoffConfig struct {
+	BaseDelay  time.Duration
+	Multiplier float64
+	Jitter     float64
+	MaxDelay   time.Duration
+	// ConnectTimeout bounds a single attempt, TLS handshake included.
+	ConnectTimeout time.Duration
+}
+
+// DefaultBackoff is the backoff used unless WithBackoff says otherwise.
+var DefaultBackoff = BackoffConfig{
+	BaseDelay:      time.Second,
+	Multiplier:     1.6,
+	Jitter:         0.2,
+	MaxDelay:       2 * time.Minute,
+	ConnectTimeout: 20 * time.Second,
+}
+
+// withDefaults fills in the zero fields of b from DefaultBackoff.
+func (b BackoffConfig) withDefaults() BackoffConfig {
+	if b.BaseDelay <= 0 {
+		b.BaseDelay = DefaultBackoff.BaseDelay
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	if b.Jitter <= 0 {
+		b.Jitter = DefaultBackoff.Jitter
+	}
+	if b.MaxDelay <= 0 {
+		b.MaxDelay = DefaultBackoff.MaxDelay
+	}
+	if b.ConnectTimeout <= 0 {
+		b.ConnectTimeout = DefaultBackoff.ConnectTimeout
+	}
+	return b
+}
+
+// delay returns the time to wait after retries consecutive failures.
+func (b BackoffConfig) delay(retries int) time.Duration {
+	d := float64(b.BaseDelay)
+	for i := 0; i < retries && d < float64(b.MaxDelay); i++ {
+		d *= b.Multiplier
+	}
+	if d > float64(b.MaxDelay) {
+		d = float64(b.MaxDelay)
+	}
+	d *= 1 + b.Jitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+// WithBackoff replaces DefaultBackoff for the client's reconnections.
+func WithBackoff(b BackoffConfig) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.backoff = b
+	}
+}
+
+// WithWaitForReady makes calls wait for a connection through failed
+// connection attempts, until their context is done, instead of failing
+// with Unavailable while the client is in TRANSIENT_FAILURE.
+func WithWaitForReady() DialOption {
+	return func(cfg *dialConfig) {
+		cfg.waitForReady = true
+	}
+}
+
+// errClientClosed fails the calls of a client after Close.
+var errClientClosed = &Status{Code: Canceled, Message: "client closed"}
+
+// subConn is a client's connection to one address. It dials a transport,
+// replaces it when it fails and reports its state changes to onState,
+// which is called with sc.mu held and must not call back into sc.
+type subConn struct {
+	addr      string
+	dial      func(ctx context.Context) (net.Conn, error)
+	backoff   BackoffConfig
+	keepalive KeepaliveParams
+	onState   func(state ConnState, t *transport, err error)
+
+	mu    sync.Mutex
+	state ConnState
+	t     *transport    // the connection while READY
+	err   error         // why the last connection or attempt failed
+	done  chan struct{} // closed on shutdown
+}
+
+func newSubConn(addr string, dial func(ctx context.Context) (net.Conn, error), cfg *dialConfig, onState func(ConnState, *transport, error)) *subConn {
+	return &subConn{
+		addr:      addr,
+		dial:      dial,
+		backoff:   cfg.backoff.withDefaults(),
+		keepalive: cfg.keepalive,
+		onState:   onState,
+		state:     StateIdle,
+		done:      make(chan struct{}),
+	}
+}
+
+// setState moves to state and reports it. sc.mu must be held.
+func (sc *subConn) setState(state ConnState) {
+	if sc.state == state {
+		return
+	}
+	sc.state = state
+	sc.onState(state, sc.t, sc.err)
+}
+
+// connect starts reconnecting if the connection is IDLE.
+func (sc *subConn) connect() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.state != StateIdle {
+		return
+	}
+	sc.setState(StateConnecting)
+	go sc.reconnect()
+}
+
+// reconnect makes connection attempts, waiting out the backoff delay
+// after each failure, until one succeeds or the client is closed.
+func (sc *subConn) reconnect() {
+	for retries := 0; sc.attempt() != nil; retries++ {
+		timer := time.NewTimer(sc.backoff.delay(retries))
+		select {
+		case <-timer.C:
+		case <-sc.done:
+			timer.Stop()
+			return
+		}
+		sc.mu.Lock()
+		if sc.state != StateTransientFailure {
+			sc.mu.Unlock()
+			return
+		}
+		sc.setState(StateConnecting)
+		sc.mu.Unlock()
+	}
+}
+
+// attempt dials once, moving to READY or TRANSIENT_FAILURE.
+func (sc *subConn) attempt() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sc.backoff.ConnectTimeout)
+	defer cancel()
+	conn, err := sc.dial(ctx)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.state == StateShutdown {
+		if conn != nil {
+			conn.Close()
+		}
+		return errClientClosed
+	}
+	if err != nil {
+		sc.err = err
+		sc.setState(StateTransientFailure)
+		return err
+	}
+	t := newTransport(conn, sc.keepalive)
+	sc.t, sc.err = t, nil
+	sc.setState(StateReady)
+	go sc.monitor(t)
+	return nil
+}
+
+// monitor waits for t to fail and moves the connection to IDLE.
+func (sc *subConn) monitor(t *transport) {
+	<-t.closed
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.t != t {
+		return
+	}
+	sc.t, sc.err = nil, t.failed()
+	if sc.state != StateShutdown {
+		sc.setState(StateIdle)
+	}
+}
+
+// lastError returns why the last connection or connection attempt
+// failed, or nil.
+func (sc *subConn) lastError() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.err
+}
+
+// close shuts the connection down for good, failing calls in flight.
+func (sc *subConn) close() {
+	sc.mu.Lock()
+	if sc.state == StateShutdown {
+		sc.mu.Unlock()
+		return
+	}
+	t := sc.t
+	sc.t = nil
+	sc.setState(StateShutdown)
+	close(sc.done)
+	sc.mu.Unlock()
+	if t != nil {
+		t.close(errClientClosed)
+	}
+}
@@ -0,0 +1,169 @@
+package gopherpipe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitState waits for c to reach want.
+func waitState(t *testing.T, c *Client, want ConnState) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := range c.WatchState(ctx) {
+		if state == want {
+			return
+		}
+	}
+	t.Fatalf("state %v, want %v", c.State(), want)
+}
+
+// flakyDialer dials a real address but can be taken down, refusing new
+// connections and dropping the current one.
+type flakyDialer struct {
+	down     atomic.Bool
+	attempts atomic.Int32
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (d *flakyDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.attempts.Add(1)
+	if d.down.Load() {
+		return nil, errors.New("network down")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+	return conn, err
+}
+
+// setDown takes the network down or brings it back up.
+func (d *flakyDialer) setDown(down bool) {
+	d.down.Store(down)
+	if down {
+		d.mu.Lock()
+		d.conn.Close()
+		d.mu.Unlock()
+	}
+}
+
+var fastBackoff = BackoffConfig{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+
+// TestReconnect verifies a client reconnects after losing its connection
+// and reports the state changes on the way.
+func TestReconnect(t *testing.T) {
+	addr := serveLimited(t, NewServer(""))
+	d := &flakyDialer{}
+	c, err := Dial(addr, WithDialer(d.dial), WithBackoff(fastBackoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := c.WatchState(ctx)
+	if s := <-states; s != StateReady {
+		t.Fatalf("state after Dial = %v", s)
+	}
+
+	d.setDown(true)
+	d.setDown(false)
+	if s := <-states; s != StateIdle {
+		t.Fatalf("state after drop = %v", s)
+	}
+	sleep := func() error {
+		return c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)})
+	}
+	if err := sleep(); err != nil {
+		t.Fatalf("call after drop: %v", err)
+	}
+	for _, want := range []ConnState{StateConnecting, StateReady} {
+		if s := <-states; s != want {
+			t.Fatalf("state = %v, want %v", s, want)
+		}
+	}
+
+	// without WaitForReady a call fails once an attempt has failed
+	d.setDown(true)
+	waitState(t, c, StateIdle)
+	if err := sleep(); CodeOf(err) != Unavailable || !strings.Contains(err.Error(), "network down") {
+		t.Fatalf("call while down: %v", err)
+	}
+	waitState(t, c, StateTransientFailure)
+	before := d.attempts.Load()
+	time.Sleep(200 * time.Millisecond)
+	if n := d.attempts.Load() - before; n < 2 {
+		t.Errorf("%d attempts while down", n)
+	}
+	d.setDown(false)
+	waitState(t, c, StateReady)
+	if err := sleep(); err != nil {
+		t.Fatalf("call after recovery: %v", err)
+	}
+}
+
+// TestWaitForReady verifies calls wait through failed attempts until the
+// server is reachable again.
+func TestWaitForReady(t *testing.T) {
+	addr := serveLimited(t, NewServer(""))
+	d := &flakyDialer{}
+	c, err := Dial(addr, WithDialer(d.dial), WithBackoff(fastBackoff), WithWaitForReady())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	d.setDown(true)
+	waitState(t, c, StateIdle)
+	time.AfterFunc(200*time.Millisecond, func() { d.setDown(false) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Call(ctx, "Sleeper", "Sleep", []interface{}{time.Duration(0)}); err != nil {
+		t.Fatalf("call waiting for ready: %v", err)
+	}
+
+	d.setDown(true)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Call(ctx, "Sleeper", "Sleep", []interface{}{time.Duration(0)}); CodeOf(err) != DeadlineExceeded {
+		t.Fatalf("call past its deadline while down: %v", err)
+	}
+}
+
+// TestCloseShutsDown verifies Close ends reconnection, watches and calls.
+func TestCloseShutsDown(t *testing.T) {
+	c := startTestServer(t, NewServer(""))
+	states := c.WatchState(context.Background())
+	c.Close()
+	var last ConnState
+	for s := range states {
+		last = s
+	}
+	if last != StateShutdown {
+		t.Fatalf("last state = %v", last)
+	}
+	if err := c.Call(context.Background(), "Sleeper", "Sleep", []interface{}{time.Duration(0)}); CodeOf(err) != Canceled {
+		t.Fatalf("call after Close: %v", err)
+	}
+}
+
+// TestBackoffDelay checks the delay grows, is capped and is jittered.
+func TestBackoffDelay(t *testing.T) {
+	b := BackoffConfig{BaseDelay: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.1, MaxDelay: time.Second}.withDefaults()
+	for retries, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := b.delay(retries); d < want*9/10 || d > want*11/10 {
+				t.Fatalf("delay(%d) = %v, want %v ±10%%", retries, d, want)
+			}
+		}
+	}
+}
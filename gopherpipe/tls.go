@@ -0,0 +1,207 @@
+package gopherpipe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake of a new connection, on both
+// the client and the server side.
+const handshakeTimeout = 10 * time.Second
+
+// WithTLSConfig serves TLS with cfg instead of plain TCP. cfg needs a
+// certificate (Certificates or GetCertificate, see CertReloader); set
+// ClientAuth to tls.RequireAndVerifyClientCert and ClientCAs for mutual
+// TLS. An HTTP handler on the same port is then served over HTTPS.
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+// WithClientTLSConfig makes Dial connect over TLS with cfg. An empty
+// ServerName defaults to the host of the dialled address; set
+// Certificates or GetClientCertificate for mutual TLS.
+func WithClientTLSConfig(cfg *tls.Config) DialOption {
+	return func(dc *dialConfig) {
+		dc.tlsConfig = cfg
+	}
+}
+
+// clientHandshake wraps conn in a TLS client connection to addr and
+// completes the handshake, so certificate errors surface from Dial.
+func clientHandshake(ctx context.Context, conn net.Conn, addr string, cfg *tls.Config) (net.Conn, error) {
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Peer describes the other end of a server connection.
+type Peer struct {
+	Addr net.Addr
+	// TLS is the state of a TLS connection, nil for plain TCP. With
+	// mutual TLS, TLS.PeerCertificates[0] is the verified client
+	// certificate.
+	TLS *tls.ConnectionState
+
+	ka *keepalive
+}
+
+// RTT returns the round-trip time to the client measured by the server's
+// last acknowledged keepalive ping, or 0 if none has been (see
+// WithKeepalive).
+func (p *Peer) RTT() time.Duration {
+	return p.ka.RTT()
+}
+
+// Certificate returns the peer's certificate, or nil when the connection
+// is not TLS or the client sent none. Its Subject, DNSNames, URIs and
+// EmailAddresses identify the client.
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return p.TLS.PeerCertificates[0]
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the call running with ctx.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// serverPeer completes the TLS handshake of conn, if it is one, and
+// describes its peer. Connections handed over by the HTTP multiplexer
+// wrap the TLS connection, which is reached through NetConn.
+func serverPeer(conn net.Conn) (*Peer, error) {
+	p := &Peer{Addr: conn.RemoteAddr()}
+	for c := conn; c != nil; {
+		if tc, ok := c.(*tls.Conn); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+			defer cancel()
+			if err := tc.HandshakeContext(ctx); err != nil {
+				return nil, err
+			}
+			st := tc.ConnectionState()
+			p.TLS = &st
+			break
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = u.NetConn()
+	}
+	return p, nil
+}
+
+// CertReloader serves a certificate and key from PEM files and reloads
+// them when either file changes, so certificates can be rotated without
+// restarting. Use GetCertificate in a server's tls.Config and
+// GetClientCertificate in a client's.
+type CertReloader struct {
+	certFile, keyFile string
+	// CheckInterval is the minimum time between two checks of the files'
+	// modification times; it defaults to one second.
+	CheckInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificate and key, failing if they are not
+// a valid pair.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, CheckInterval: time.Second}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. On error the previous certificate stays
+// in use.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+func (r *CertReloader) load() error {
+	certMod, err := modTime(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyMod, err := modTime(r.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// current returns the certificate, reloading it first if the files
+// changed since it was loaded.
+func (r *CertReloader) current() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.lastCheck) >= r.CheckInterval {
+		r.lastCheck = now
+		certMod, err1 := modTime(r.certFile)
+		keyMod, err2 := modTime(r.keyFile)
+		if err1 == nil && err2 == nil && (!certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)) {
+			// A failed reload, for example while only one of the files
+			// has been replaced, is retried at the next check.
+			if err := r.load(); err != nil {
+				log.Printf("gopherpipe: reload certificate %s: %v", r.certFile, err)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current()
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current()
+}
+
+func modTime(name string) (time.Time, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
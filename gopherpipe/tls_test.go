@@ -0,0 +1,275 @@
+package gopherpipe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is an in-memory certificate authority for TLS tests.
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool, serial: 1}
+}
+
+// issue returns a certificate for cn, valid for 127.0.0.1 and dnsNames,
+// as a tls.Certificate and PEM-encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames ...string) (cert tls.Certificate, certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, certPEM, keyPEM
+}
+
+type whoami struct{}
+
+// Who reports the common name and DNS names of the caller's certificate.
+func (whoami) Who(ctx context.Context) (string, []string, error) {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return "", nil, Errorf(Internal, "no peer")
+	}
+	cert := p.Certificate()
+	if cert == nil {
+		return "", nil, nil
+	}
+	return cert.Subject.CommonName, cert.DNSNames, nil
+}
+
+// serveTLS serves srv on a local port and returns its address.
+func serveTLS(t *testing.T, srv *Server) string {
+	t.Helper()
+	if err := srv.Register("Who", whoami{}); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.ServeListener(ln) }()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// TestMutualTLSPeerIdentity verifies handlers see the verified client
+// certificate and that clients without a trusted certificate, or not
+// speaking TLS at all, cannot call.
+func TestMutualTLSPeerIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, _, _ := ca.issue(t, "server")
+	clientCert, _, _ := ca.issue(t, "client-a", "a.clients.example")
+	addr := serveTLS(t, NewServer("", WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})))
+	ctx := context.Background()
+
+	c, err := Dial(addr, WithClientTLSConfig(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}}))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	var cn string
+	var names []string
+	if err := c.Call(ctx, "Who", "Who", nil, &cn, &names); err != nil {
+		t.Fatalf("Who: %v", err)
+	}
+	if cn != "client-a" || len(names) != 1 || names[0] != "a.clients.example" {
+		t.Fatalf("peer = %q %v", cn, names)
+	}
+
+	// Without a client certificate TLS 1.3 completes the client side of
+	// the handshake, so the rejection may only show on the first call.
+	if c, err := Dial(addr, WithClientTLSConfig(&tls.Config{RootCAs: ca.pool})); err == nil {
+		err = c.Call(ctx, "Who", "Who", nil, &cn, &names)
+		c.Close()
+		if err == nil {
+			t.Fatal("call without client certificate succeeded")
+		}
+	}
+	if c, err := Dial(addr); err == nil {
+		err = c.Call(ctx, "Who", "Who", nil, &cn, &names)
+		c.Close()
+		if err == nil {
+			t.Fatal("plain TCP call to TLS server succeeded")
+		}
+	}
+	other := newTestCA(t)
+	if _, err := Dial(addr, WithClientTLSConfig(&tls.Config{RootCAs: other.pool, Certificates: []tls.Certificate{clientCert}})); err == nil {
+		t.Fatal("dial trusting the wrong CA succeeded")
+	}
+}
+
+// TestTLSWithHTTPHandler verifies an HTTP handler on a TLS port is served
+// over HTTPS next to TLS-wrapped TCP_LITE.
+func TestTLSWithHTTPHandler(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, _, _ := ca.issue(t, "server")
+	addr := serveTLS(t, NewServer("",
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}),
+		WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		}))))
+
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}}
+	resp, err := hc.Get("https://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+
+	c, err := Dial(addr, WithClientTLSConfig(&tls.Config{RootCAs: ca.pool}))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	var cn string
+	var names []string
+	if err := c.Call(context.Background(), "Who", "Who", nil, &cn, &names); err != nil {
+		t.Fatalf("Who: %v", err)
+	}
+	if cn != "" {
+		t.Fatalf("peer without client certificate = %q", cn)
+	}
+}
+
+// TestCertReloader verifies a server picks up a certificate replaced on
+// disk without restarting.
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	write := func(cn string, mtime time.Time) {
+		_, certPEM, keyPEM := ca.issue(t, cn)
+		for name, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+			if err := os.WriteFile(name, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// Coarse file system timestamps could hide the change.
+			if err := os.Chtimes(name, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write("first", time.Now().Add(-time.Minute))
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.CheckInterval = 0
+	addr := serveTLS(t, NewServer("", WithTLSConfig(&tls.Config{GetCertificate: reloader.GetCertificate})))
+
+	serverName := func() string {
+		var cn string
+		c, err := Dial(addr, WithClientTLSConfig(&tls.Config{
+			RootCAs: ca.pool,
+			VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+				cert, err := x509.ParseCertificate(raw[0])
+				if err == nil {
+					cn = cert.Subject.CommonName
+				}
+				return err
+			},
+		}))
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		c.Close()
+		return cn
+	}
+	if cn := serverName(); cn != "first" {
+		t.Fatalf("server certificate = %q, want first", cn)
+	}
+	write("second", time.Now())
+	if cn := serverName(); cn != "second" {
+		t.Fatalf("server certificate after rotation = %q, want second", cn)
+	}
+
+	// A broken key keeps the previous certificate in use.
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "key") {
+		t.Fatalf("reload of broken key: %v", err)
+	}
+	if cn := serverName(); cn != "second" {
+		t.Fatalf("server certificate after failed reload = %q, want second", cn)
+	}
+}
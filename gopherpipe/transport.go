@@ -0,0 +1,355 @@
+package gopherpipe
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anthony/gopher-pipe/internal/codec"
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// transport is one connection of a Client. Calls are multiplexed over it:
+// a background reader routes each reply to the pending call with the
+// matching CallID, so unary calls and streams may run concurrently. A
+// transport is not reused once its connection fails; the client dials a
+// new one.
+type transport struct {
+	conn net.Conn
+
+	// wmu serialises frame writes.
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]*pendingCall
+	err     error         // set once the connection has failed
+	closed  chan struct{} // closed together with setting err
+	abort   error         // why the client closed the connection, if it did
+
+	ka *keepalive
+}
+
+// pendingCall is the routing entry for an in-flight call. Replies are
+// queued, up to limit, until the caller abandons the call by closing done,
+// or the transport fails the call alone by closing failed.
+type pendingCall struct {
+	mu    sync.Mutex
+	queue []reply
+	limit int
+	ready chan struct{} // signalled when a reply is queued
+
+	done  chan struct{}
+	once  sync.Once
+	ended int32 // set atomically once the server has finished the call
+
+	failed   chan struct{}
+	failOnce sync.Once
+	err      error // why the call failed, set before failed is closed
+}
+
+// reply is a single envelope routed to a pending call. err is set for
+// error frames and for connection failures.
+type reply struct {
+	env Envelope
+	err error
+}
+
+// newTransport starts serving calls over an established connection.
+func newTransport(conn net.Conn, ka KeepaliveParams) *transport {
+	t := &transport{conn: conn, pending: make(map[uint64]*pendingCall), closed: make(chan struct{})}
+	t.ka = newKeepalive(ka, t.busy, t.writeHeartbeat, func() {
+		t.close(&Status{Code: Unavailable, Message: "keepalive timeout"})
+	})
+	go t.readLoop()
+	if ka.Time > 0 {
+		go t.ka.run(t.closed)
+	}
+	return t
+}
+
+// close closes the connection, failing the calls in flight with err.
+func (t *transport) close(err error) {
+	t.mu.Lock()
+	if t.abort == nil {
+		t.abort = err
+	}
+	t.mu.Unlock()
+	t.conn.Close()
+}
+
+// busy reports whether calls are in flight.
+func (t *transport) busy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending) > 0
+}
+
+// writeHeartbeat sends a heartbeat frame.
+func (t *transport) writeHeartbeat(payload []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return tcplite.WriteFrame(t.conn, tcplite.FrameTypeHeartbeat, payload)
+}
+
+// readLoop reads frames until the connection fails and routes each reply
+// to its pending call. On failure, including a reply that cannot be
+// decoded, every pending call observes the error.
+func (t *transport) readLoop() {
+	for {
+		ftype, payload, err := tcplite.ReadFrame(t.conn)
+		if err != nil {
+			t.mu.Lock()
+			abort := t.abort
+			t.mu.Unlock()
+			if abort == nil {
+				abort = &Status{Code: Unavailable, Message: err.Error()}
+			}
+			t.fail(abort)
+			return
+		}
+		t.ka.received()
+		switch ftype {
+		case tcplite.FrameTypeData, tcplite.FrameTypeError:
+		case tcplite.FrameTypeHeartbeat:
+			t.ka.heartbeat(payload)
+			continue
+		case tcplite.FrameTypeClose:
+			msg := "connection closed by server"
+			if len(payload) > 0 {
+				msg += ": " + string(payload)
+			}
+			t.fail(&Status{Code: Unavailable, Message: msg})
+			t.conn.Close()
+			return
+		default:
+			continue
+		}
+		var env Envelope
+		if err := codec.Decode(payload, &env); err != nil {
+			// The reply cannot be routed to its call, which would wait
+			// forever, so the connection is no longer usable.
+			st := &Status{Code: Internal, Message: "undecodable reply: " + err.Error()}
+			if ftype == tcplite.FrameTypeError {
+				st.Message = "undecodable error reply: " + decodeErrorFrame(payload).Error()
+			}
+			t.fail(st)
+			t.conn.Close()
+			return
+		}
+		r := reply{env: env}
+		if ftype == tcplite.FrameTypeError {
+			r.err = &Status{Code: env.Code, Message: env.Message}
+		}
+		t.mu.Lock()
+		pc := t.pending[env.CallID]
+		t.mu.Unlock()
+		if pc == nil {
+			// reply to an abandoned call
+			continue
+		}
+		if r.err != nil || env.EndStream || env.RPCType == Unary || env.RPCType == ClientStream {
+			atomic.StoreInt32(&pc.ended, 1)
+		}
+		if !pc.deliver(r) {
+			// Waiting for a consumer that stopped reading would stall
+			// every call on the connection and its heartbeats, so the
+			// call is failed alone.
+			t.failCall(env.CallID, pc, Errorf(ResourceExhausted, "%s/%s: more than %d stream messages queued", env.ServiceName, env.MethodName, pc.limit))
+		}
+	}
+}
+
+// fail records the terminal connection error and wakes all pending calls.
+func (t *transport) fail(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.pending = make(map[uint64]*pendingCall)
+	close(t.closed)
+	t.mu.Unlock()
+}
+
+// failed returns the error the connection failed with, or nil.
+func (t *transport) failed() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// wait returns the next reply for pc. Replies queued before the call or
+// the connection failed are still delivered; after that the error is
+// returned.
+func (t *transport) wait(ctx context.Context, pc *pendingCall) reply {
+	for {
+		if r, ok := pc.next(); ok {
+			return r
+		}
+		select {
+		case <-pc.ready:
+		case <-ctx.Done():
+			return reply{err: contextStatus(ctx.Err())}
+		case <-pc.done:
+			return reply{err: &Status{Code: Canceled, Message: "call abandoned"}}
+		case <-pc.failed:
+			if r, ok := pc.next(); ok {
+				return r
+			}
+			return reply{err: pc.err}
+		case <-t.closed:
+			if r, ok := pc.next(); ok {
+				return r
+			}
+			return reply{err: t.err}
+		}
+	}
+}
+
+// start registers a pending call and writes its request envelope. limit
+// caps the replies queued for the caller; streams queue more than unary
+// calls.
+func (t *transport) start(env Envelope, limit int) (*pendingCall, error) {
+	pc := &pendingCall{limit: limit, ready: make(chan struct{}, 1), done: make(chan struct{}), failed: make(chan struct{})}
+	t.mu.Lock()
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return nil, err
+	}
+	t.pending[env.CallID] = pc
+	t.mu.Unlock()
+
+	if err := t.send(env); err != nil {
+		t.finish(env.CallID, pc)
+		return nil, err
+	}
+	return pc, nil
+}
+
+// send encodes env and writes it in a data frame.
+func (t *transport) send(env Envelope) error {
+	envb, err := codec.Encode(env)
+	if err != nil {
+		return err
+	}
+	t.wmu.Lock()
+	err = tcplite.WriteFrame(t.conn, tcplite.FrameTypeData, envb)
+	t.wmu.Unlock()
+	if err != nil {
+		return &Status{Code: Unavailable, Message: err.Error()}
+	}
+	return nil
+}
+
+// pumpInput forwards values received from input as stream messages of the
+// call described by req, and ends the stream once input is closed. It
+// stops early when the call finishes, ctx is done or the connection fails.
+// A value that cannot be encoded fails the call with Internal rather than
+// leave a gap in the stream.
+func (t *transport) pumpInput(ctx context.Context, req Envelope, pc *pendingCall, input reflect.Value) {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: input},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(pc.done)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.closed)},
+	}
+	msg := Envelope{RPCType: req.RPCType, ServiceName: req.ServiceName, MethodName: req.MethodName, CallID: req.CallID, StreamMessage: true}
+	for {
+		chosen, v, ok := reflect.Select(cases)
+		if chosen != 0 {
+			return
+		}
+		if !ok {
+			msg.Body = nil
+			msg.EndStream = true
+			_ = t.send(msg)
+			return
+		}
+		b, err := codec.Encode(v.Interface())
+		if err != nil {
+			t.failCall(req.CallID, pc, Errorf(Internal, "encode stream message: %v", err))
+			return
+		}
+		msg.Body = b
+		if err := t.send(msg); err != nil {
+			return
+		}
+	}
+}
+
+// finish removes a pending call from the routing table. A call the
+// server has not finished yet is cancelled with a cancel frame, so the
+// server stops working on it.
+func (t *transport) finish(id uint64, pc *pendingCall) {
+	t.mu.Lock()
+	live := t.pending[id] == pc && t.err == nil
+	if live {
+		delete(t.pending, id)
+	}
+	t.mu.Unlock()
+	pc.abandon()
+	if live && atomic.LoadInt32(&pc.ended) == 0 {
+		t.writeCancel(id)
+	}
+}
+
+// failCall fails a single call with err, leaving the other calls on the
+// connection running. Like finish, it removes the call from the routing
+// table and cancels it on the server unless the server has finished it.
+// The cancel frame is written in the background, since failCall may run
+// on the reader.
+func (t *transport) failCall(id uint64, pc *pendingCall, err error) {
+	t.mu.Lock()
+	live := t.pending[id] == pc && t.err == nil
+	if live {
+		delete(t.pending, id)
+	}
+	t.mu.Unlock()
+	pc.failOnce.Do(func() {
+		pc.err = err
+		close(pc.failed)
+	})
+	if live && atomic.LoadInt32(&pc.ended) == 0 {
+		go t.writeCancel(id)
+	}
+}
+
+// writeCancel asks the server to stop working on call id.
+func (t *transport) writeCancel(id uint64) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	_ = tcplite.WriteFrame(t.conn, tcplite.FrameTypeCancel, tcplite.EncodeCancel(id))
+}
+
+// deliver queues r for the caller, reporting false if limit replies are
+// already waiting.
+func (pc *pendingCall) deliver(r reply) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if len(pc.queue) >= pc.limit {
+		return false
+	}
+	pc.queue = append(pc.queue, r)
+	select {
+	case pc.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// next dequeues the oldest reply, if any.
+func (pc *pendingCall) next() (reply, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if len(pc.queue) == 0 {
+		return reply{}, false
+	}
+	r := pc.queue[0]
+	pc.queue[0] = reply{}
+	pc.queue = pc.queue[1:]
+	return r, true
+}
+
+func (pc *pendingCall) abandon() {
+	pc.once.Do(func() { close(pc.done) })
+}
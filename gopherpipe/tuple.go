@@ -0,0 +1,61 @@
+package gopherpipe
+
+import (
+	"fmt"
+
+	"github.com/anthony/gopher-pipe/internal/codec"
+)
+
+// Argument and result lists travel in Envelope.Body as a "tuple":
+//
+//   - no values: an empty body
+//   - one value: the value encoded on its own, so single-argument calls stay
+//     wire-compatible with earlier clients
+//   - several values: each value encoded separately and the resulting
+//     [][]byte encoded again
+//
+// Clients and servers must agree on the number of values, which both sides
+// derive from the method signature.
+
+// encodeTuple encodes vals using the tuple layout described above.
+func encodeTuple(vals []interface{}) ([]byte, error) {
+	switch len(vals) {
+	case 0:
+		return nil, nil
+	case 1:
+		return codec.Encode(vals[0])
+	}
+	parts := make([][]byte, len(vals))
+	for i, v := range vals {
+		b, err := codec.Encode(v)
+		if err != nil {
+			return nil, fmt.Errorf("encode value %d: %w", i, err)
+		}
+		parts[i] = b
+	}
+	return codec.Encode(parts)
+}
+
+// decodeTuple decodes body into ptrs, which must be pointers, using the
+// tuple layout described above.
+func decodeTuple(body []byte, ptrs []interface{}) error {
+	switch len(ptrs) {
+	case 0:
+		return nil
+	case 1:
+		return codec.Decode(body, ptrs[0])
+	}
+	var parts [][]byte
+	if err := codec.Decode(body, &parts); err != nil {
+		return err
+	}
+	if len(parts) != len(ptrs) {
+		return fmt.Errorf("expected %d values, got %d", len(ptrs), len(parts))
+	}
+	for i, p := range parts {
+		if err := codec.Decode(p, ptrs[i]); err != nil {
+			return fmt.Errorf("decode value %d: %w", i, err)
+		}
+	}
+	return nil
+}
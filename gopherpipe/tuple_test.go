@@ -0,0 +1,32 @@
+package gopherpipe
+
+import "testing"
+
+// TestTupleRoundTrip verifies argument lists of every length survive the
+// tuple encoding, and that a count mismatch is reported.
+func TestTupleRoundTrip(t *testing.T) {
+	b, err := encodeTuple([]interface{}{"a", 2, []string{"c"}})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var s string
+	var n int
+	var l []string
+	if err := decodeTuple(b, []interface{}{&s, &n, &l}); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if s != "a" || n != 2 || len(l) != 1 || l[0] != "c" {
+		t.Fatalf("mismatch: %q %d %v", s, n, l)
+	}
+	if err := decodeTuple(b, []interface{}{&s, &n}); err == nil {
+		t.Fatal("expected count mismatch error")
+	}
+
+	b, err = encodeTuple(nil)
+	if err != nil || len(b) != 0 {
+		t.Fatalf("empty tuple: %v %v", b, err)
+	}
+	if err := decodeTuple(b, nil); err != nil {
+		t.Fatalf("decode empty: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Package connlimit caps the number of connections a listener keeps open,
+// in total and per remote IP address. Connections over a limit are closed
+// as soon as they are accepted, so one client cannot exhaust the server's
+// file descriptors or goroutines.
+package connlimit
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// Listener is a net.Listener enforcing connection limits.
+type Listener struct {
+	net.Listener
+	max, maxPerIP int
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// New wraps ln. A max or maxPerIP of 0 disables that limit.
+func New(ln net.Listener, max, maxPerIP int) *Listener {
+	return &Listener{Listener: ln, max: max, maxPerIP: maxPerIP, perIP: make(map[string]int)}
+}
+
+// Accept returns the next connection within the limits, closing the ones
+// that are not.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := hostOf(conn.RemoteAddr())
+		if l.admit(ip) {
+			return &limitedConn{Conn: conn, l: l, ip: ip}, nil
+		}
+		log.Printf("connlimit: rejecting connection from %v: too many connections", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// admit counts a new connection from ip if the limits allow it.
+func (l *Listener) admit(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+func (l *Listener) done(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] == 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// Open returns the number of connections currently open.
+func (l *Listener) Open() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+func hostOf(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// limitedConn releases its slot when closed.
+type limitedConn struct {
+	net.Conn
+	l    *Listener
+	ip   string
+	once sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { c.l.done(c.ip) })
+	return c.Conn.Close()
+}
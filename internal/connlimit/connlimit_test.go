@@ -0,0 +1,66 @@
+package connlimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestLimits verifies connections over the total or per-IP limit are
+// closed on accept and that closing one frees its slot.
+func TestLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		max, maxPerIP int
+	}{{"total", 2, 0}, {"per IP", 0, 2}} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := New(ln, tc.max, tc.maxPerIP)
+		accepted := make(chan net.Conn, 4)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+		dial := func() net.Conn {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conn
+		}
+		// closed reports whether the server closed conn.
+		closed := func(conn net.Conn) bool {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, err := conn.Read(make([]byte, 1))
+			ne, ok := err.(net.Error)
+			return !(ok && ne.Timeout())
+		}
+
+		a, b := dial(), dial()
+		first := <-accepted
+		<-accepted
+		if c := dial(); !closed(c) {
+			t.Errorf("%s: third connection was not closed", tc.name)
+		}
+		if l.Open() != 2 {
+			t.Errorf("%s: open = %d, want 2", tc.name, l.Open())
+		}
+		first.Close()
+		c := dial()
+		<-accepted
+		if closed(c) {
+			t.Errorf("%s: connection after a close was rejected", tc.name)
+		}
+		a.Close()
+		b.Close()
+		c.Close()
+		ln.Close()
+	}
+}
@@ -0,0 +1,151 @@
+// Package connmux serves TCP_LITE and HTTP on the same port. It peeks at
+// the first byte of every accepted connection: a known TCP_LITE frame type
+// routes the connection to the frames listener, anything else (an HTTP
+// request line, a health probe) to the HTTP listener. The peeked byte is
+// replayed, so each side reads the connection from the start.
+package connmux
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// Mux splits one listener into a frames listener and an HTTP listener.
+type Mux struct {
+	// PeekTimeout, if set, closes connections that send nothing for this
+	// long after being accepted.
+	PeekTimeout time.Duration
+	// HandshakeTimeout, if set, bounds the TLS handshake of connections
+	// accepted from a TLS listener, which peeking would otherwise run
+	// without a deadline.
+	HandshakeTimeout time.Duration
+
+	root   net.Listener
+	frames *subListener
+	http   *subListener
+}
+
+// New returns a Mux over ln. Call Serve to start routing connections.
+func New(ln net.Listener) *Mux {
+	return &Mux{root: ln, frames: newSubListener(ln.Addr()), http: newSubListener(ln.Addr())}
+}
+
+// Frames returns the listener of TCP_LITE connections.
+func (m *Mux) Frames() net.Listener { return m.frames }
+
+// HTTP returns the listener of non-TCP_LITE connections.
+func (m *Mux) HTTP() net.Listener { return m.http }
+
+// Serve accepts connections from the root listener and routes them until
+// it is closed, then closes both sub-listeners. It returns nil when the
+// root listener was closed and the accept error otherwise.
+func (m *Mux) Serve() error {
+	defer m.frames.Close()
+	defer m.http.Close()
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go m.route(conn)
+	}
+}
+
+// route peeks at the first byte of conn and hands it to the matching
+// listener. Peeking blocks until the peer sends something, which is fine
+// since it happens on the connection's own goroutine. A TLS connection
+// completes its handshake first, so the byte peeked is plaintext.
+func (m *Mux) route(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok && m.HandshakeTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), m.HandshakeTimeout)
+		err := tc.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			return
+		}
+	}
+	if m.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(m.PeekTimeout))
+	}
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if m.PeekTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	pc := &peekedConn{Conn: conn, r: r}
+	if tcplite.IsFrameType(first[0]) {
+		m.frames.deliver(pc)
+	} else {
+		m.http.deliver(pc)
+	}
+}
+
+// peekedConn replays the bytes buffered while peeking before reading
+// from the connection itself.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// NetConn returns the routed connection, so callers can reach a wrapped
+// *tls.Conn for its connection state.
+func (c *peekedConn) NetConn() net.Conn { return c.Conn }
+
+// subListener is a net.Listener fed by a Mux.
+type subListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newSubListener(addr net.Addr) *subListener {
+	return &subListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// deliver hands conn to the next Accept, closing it if the listener is
+// closed first.
+func (l *subListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *subListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *subListener) Addr() net.Addr { return l.addr }
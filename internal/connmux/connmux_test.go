@@ -0,0 +1,93 @@
+package connmux
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// TestRoutesByFirstByte verifies frames and HTTP reach their own
+// listener with the peeked byte intact, and that closing the root
+// listener closes both.
+func TestRoutesByFirstByte(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(ln)
+	served := make(chan error, 1)
+	go func() { served <- m.Serve() }()
+
+	frameConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frameConn.Close()
+	if err := tcplite.WriteFrame(frameConn, tcplite.FrameTypeData, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := m.Frames().Accept()
+	if err != nil {
+		t.Fatalf("frames accept: %v", err)
+	}
+	ftype, payload, err := tcplite.ReadFrame(conn)
+	if err != nil || ftype != tcplite.FrameTypeData || string(payload) != "hi" {
+		t.Fatalf("ReadFrame = %d, %q, %v", ftype, payload, err)
+	}
+
+	httpConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpConn.Close()
+	if _, err := io.WriteString(httpConn, "GET / HTTP/1.1\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err = m.HTTP().Accept()
+	if err != nil {
+		t.Fatalf("http accept: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("request line = %q, %v", line, err)
+	}
+
+	ln.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	for _, sub := range []net.Listener{m.Frames(), m.HTTP()} {
+		if _, err := sub.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Accept after close: %v", err)
+		}
+	}
+}
+
+// TestHandshakeTimeout verifies a TLS connection that never completes its
+// handshake is closed after HandshakeTimeout, without a PeekTimeout.
+func TestHandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	m := New(tls.NewListener(ln, &tls.Config{}))
+	m.HandshakeTimeout = 50 * time.Millisecond
+	go func() { _ = m.Serve() }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,396 @@
+// Package contract loads GopherPipe service contracts — Go interfaces
+// marked with a //gopherpipe:service directive — from source using
+// go/types. The result describes every method in wire terms (context,
+// tuple arguments, stream parameter, results) and is shared by the code
+// generator and the schema tooling.
+package contract
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ServiceDirective marks an interface type as a GopherPipe service.
+const ServiceDirective = "//gopherpipe:service"
+
+// IdempotentDirective marks a service method as safe to call more than
+// once, which lets clients retry it.
+const IdempotentDirective = "//gopherpipe:idempotent"
+
+// ReadOnlyDirective marks a service method as free of side effects, which
+// lets clients hedge it. Read-only methods are idempotent too.
+const ReadOnlyDirective = "//gopherpipe:readonly"
+
+// Package is a type-checked Go package and the services it declares.
+type Package struct {
+	Name     string
+	Path     string
+	Types    *types.Package
+	Fset     *token.FileSet
+	Services []*Service
+}
+
+// Service is one contract interface.
+type Service struct {
+	Name    string
+	Iface   *types.Interface
+	Methods []*Method
+}
+
+// RPC types, matching gopherpipe.RPCType.
+const (
+	Unary        = "unary"
+	ClientStream = "client_stream"
+	ServerStream = "server_stream"
+	BiDi         = "bidi"
+)
+
+// Method describes a contract method in wire terms.
+type Method struct {
+	Name string
+	// RPCType is one of Unary, ClientStream, ServerStream or BiDi.
+	RPCType string
+	// HasContext reports a leading context.Context parameter.
+	HasContext bool
+	// Params are the non-context parameters in declaration order,
+	// including the stream parameter if there is one.
+	Params []*Param
+	// InIndex is the index in Params of the receive-only channel parameter
+	// fed by the caller, or -1.
+	InIndex int
+	// Results are the non-error results. For server-streaming and
+	// bidirectional methods it holds the single channel result.
+	Results []types.Type
+	// Idempotent reports the IdempotentDirective, or the ReadOnlyDirective
+	// which implies it.
+	Idempotent bool
+	// ReadOnly reports the ReadOnlyDirective.
+	ReadOnly bool
+}
+
+// Param is a named method parameter.
+type Param struct {
+	Name string
+	Type types.Type
+}
+
+// Args returns the parameters sent in the request tuple, which excludes
+// the stream parameter.
+func (m *Method) Args() []*Param {
+	var args []*Param
+	for i, p := range m.Params {
+		if i != m.InIndex {
+			args = append(args, p)
+		}
+	}
+	return args
+}
+
+// InElem returns the element type of the stream parameter, or nil.
+func (m *Method) InElem() types.Type {
+	if m.InIndex < 0 {
+		return nil
+	}
+	return m.Params[m.InIndex].Type.Underlying().(*types.Chan).Elem()
+}
+
+// OutElem returns the element type of the result stream, or nil.
+func (m *Method) OutElem() types.Type {
+	if m.RPCType != ServerStream && m.RPCType != BiDi {
+		return nil
+	}
+	return m.Results[0].Underlying().(*types.Chan).Elem()
+}
+
+var generatedRE = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// Load parses and type-checks the non-test Go files in dir that the go
+// tool would build for the current platform, honouring build constraints
+// and GOOS/GOARCH file name suffixes, and returns the services it
+// declares. Generated files are skipped so stale output never prevents
+// regeneration.
+func Load(dir string) (*Package, error) {
+	fset := token.NewFileSet()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	var files []*ast.File
+	for _, name := range matches {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, filepath.Base(name)); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if isGenerated(f) {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	path, err := importPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	tpkg, err := conf.Check(path, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &Package{Name: tpkg.Name(), Path: path, Types: tpkg, Fset: fset}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if !hasDirective(gd.Doc, ServiceDirective) && !hasDirective(ts.Doc, ServiceDirective) {
+					continue
+				}
+				svc, err := newService(tpkg, ts.Name.Name, methodDirectives(ts))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", fset.Position(ts.Pos()), err)
+				}
+				pkg.Services = append(pkg.Services, svc)
+			}
+		}
+	}
+	sort.Slice(pkg.Services, func(i, j int) bool { return pkg.Services[i].Name < pkg.Services[j].Name })
+	return pkg, nil
+}
+
+// methodDirectives returns the directives on the doc comments of the
+// methods declared in ts, by method name.
+func methodDirectives(ts *ast.TypeSpec) map[string]*ast.CommentGroup {
+	docs := make(map[string]*ast.CommentGroup)
+	iface, ok := ts.Type.(*ast.InterfaceType)
+	if !ok {
+		return docs
+	}
+	for _, field := range iface.Methods.List {
+		for _, n := range field.Names {
+			docs[n.Name] = field.Doc
+		}
+	}
+	return docs
+}
+
+// newService builds the method descriptions of the interface type name.
+// docs holds the doc comments of its methods.
+func newService(pkg *types.Package, name string, docs map[string]*ast.CommentGroup) (*Service, error) {
+	obj := pkg.Scope().Lookup(name)
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil, fmt.Errorf("%s is marked as a service but is not an interface", name)
+	}
+	svc := &Service{Name: name, Iface: iface}
+	var problems []string
+	for i := 0; i < iface.NumMethods(); i++ {
+		fn := iface.Method(i)
+		if !fn.Exported() {
+			continue
+		}
+		m, err := newMethod(fn)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%v)", fn.Name(), err))
+			continue
+		}
+		m.ReadOnly = hasDirective(docs[m.Name], ReadOnlyDirective)
+		m.Idempotent = m.ReadOnly || hasDirective(docs[m.Name], IdempotentDirective)
+		svc.Methods = append(svc.Methods, m)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("service %s: unsupported methods: %s", name, strings.Join(problems, ", "))
+	}
+	if len(svc.Methods) == 0 {
+		return nil, fmt.Errorf("service %s has no exported methods", name)
+	}
+	return svc, nil
+}
+
+// newMethod classifies a method signature. Its rules are those of the
+// runtime dispatcher, newMethodDesc in gopherpipe/dispatch.go, so a
+// contract genstub accepts registers at runtime and the reverse; keep the
+// two in step.
+func newMethod(fn *types.Func) (*Method, error) {
+	sig := fn.Type().(*types.Signature)
+	if sig.Variadic() {
+		return nil, fmt.Errorf("variadic methods are not supported")
+	}
+	m := &Method{Name: fn.Name(), RPCType: Unary, InIndex: -1}
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		p := params.At(i)
+		if i == 0 && isContext(p.Type()) {
+			m.HasContext = true
+			continue
+		}
+		if ch, ok := p.Type().Underlying().(*types.Chan); ok {
+			if m.InIndex >= 0 {
+				return nil, fmt.Errorf("at most one channel parameter is supported")
+			}
+			if ch.Dir() != types.RecvOnly {
+				return nil, fmt.Errorf("stream parameter %s must be receive-only", p.Type())
+			}
+			if err := checkWireType(ch.Elem()); err != nil {
+				return nil, fmt.Errorf("stream parameter element: %v", err)
+			}
+			m.InIndex = len(m.Params)
+			m.RPCType = ClientStream
+		} else if err := checkWireType(p.Type()); err != nil {
+			return nil, fmt.Errorf("parameter %d: %v", i, err)
+		}
+		m.Params = append(m.Params, &Param{Name: p.Name(), Type: p.Type()})
+	}
+	results := sig.Results()
+	if results.Len() == 0 || !isError(results.At(results.Len()-1).Type()) {
+		return nil, fmt.Errorf("last result must be error")
+	}
+	for i := 0; i < results.Len()-1; i++ {
+		t := results.At(i).Type()
+		if ch, ok := t.Underlying().(*types.Chan); ok {
+			if results.Len() != 2 || ch.Dir() != types.RecvOnly {
+				return nil, fmt.Errorf("stream result must be a receive-only channel returned with an error")
+			}
+			if err := checkWireType(ch.Elem()); err != nil {
+				return nil, fmt.Errorf("stream element: %v", err)
+			}
+			if m.RPCType == ClientStream {
+				m.RPCType = BiDi
+			} else {
+				m.RPCType = ServerStream
+			}
+		} else if err := checkWireType(t); err != nil {
+			return nil, fmt.Errorf("result %d: %v", i, err)
+		}
+		m.Results = append(m.Results, t)
+	}
+	if m.RPCType != Unary && !m.HasContext {
+		// without one the caller could never abandon the stream
+		return nil, fmt.Errorf("streaming methods must take a context.Context first")
+	}
+	return m, nil
+}
+
+// checkWireType rejects types that cannot be encoded.
+func checkWireType(t types.Type) error {
+	switch u := t.Underlying().(type) {
+	case *types.Signature:
+		return fmt.Errorf("func values cannot be sent over the wire")
+	case *types.Chan:
+		return fmt.Errorf("channels are only supported as stream parameters or results")
+	case *types.Basic:
+		if u.Kind() == types.UnsafePointer {
+			return fmt.Errorf("unsafe.Pointer cannot be sent over the wire")
+		}
+	}
+	if isContext(t) {
+		return fmt.Errorf("context.Context must be the first parameter")
+	}
+	return nil
+}
+
+func isContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// hasDirective reports whether a doc comment contains the directive on a
+// line of its own.
+func hasDirective(doc *ast.CommentGroup, directive string) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// isGenerated reports whether f carries the standard generated-code header.
+func isGenerated(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if generatedRE.MatchString(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importPath derives the import path of dir from the enclosing go.mod.
+func importPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for root := abs; ; {
+		data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+		if err == nil {
+			mod := modulePath(data)
+			if mod == "" {
+				return "", fmt.Errorf("no module directive in %s", filepath.Join(root, "go.mod"))
+			}
+			rel, err := filepath.Rel(root, abs)
+			if err != nil {
+				return "", err
+			}
+			if rel == "." {
+				return mod, nil
+			}
+			return mod + "/" + filepath.ToSlash(rel), nil
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", fmt.Errorf("%s is not inside a Go module", dir)
+		}
+		root = parent
+	}
+}
+
+// modulePath extracts the module path from go.mod contents.
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "module ") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "module ")), `"`)
+		}
+	}
+	return ""
+}
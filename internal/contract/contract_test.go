@@ -0,0 +1,192 @@
+package contract
+
+import (
+	"go/types"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/anthony/gopher-pipe/internal/contract/contracttest"
+)
+
+// load writes files, by name, as a module in a temporary directory and
+// loads it.
+func load(t *testing.T, files map[string]string) (*Package, error) {
+	t.Helper()
+	return Load(contracttest.WriteModule(t, files))
+}
+
+// TestMethodClassification verifies the RPC type, context and stream
+// parameter of every supported signature shape.
+func TestMethodClassification(t *testing.T) {
+	pkg, err := load(t, map[string]string{"svc.go": `package svc
+
+import "context"
+
+type Item struct{ Name string }
+
+//gopherpipe:service
+type Svc interface {
+	Unary(x int) (string, error)
+	UnaryCtx(ctx context.Context, a, b Item) error
+	Upload(ctx context.Context, name string, in <-chan Item) (int, error)
+	Watch(ctx context.Context, room string) (<-chan Item, error)
+	Chat(ctx context.Context, in <-chan string) (<-chan string, error)
+	unexported() error
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name       string
+		rpcType    string
+		hasContext bool
+		inIndex    int
+		params     int
+		args       int
+		results    int
+	}{
+		{"Unary", Unary, false, -1, 1, 1, 1},
+		{"UnaryCtx", Unary, true, -1, 2, 2, 0},
+		{"Upload", ClientStream, true, 1, 2, 1, 1},
+		{"Watch", ServerStream, true, -1, 1, 1, 1},
+		{"Chat", BiDi, true, 0, 1, 0, 1},
+	}
+	methods := make(map[string]*Method)
+	for _, m := range pkg.Services[0].Methods {
+		methods[m.Name] = m
+	}
+	if len(methods) != len(cases) {
+		t.Fatalf("got %d methods, want %d", len(methods), len(cases))
+	}
+	for _, tc := range cases {
+		m := methods[tc.name]
+		if m == nil {
+			t.Errorf("%s: missing", tc.name)
+			continue
+		}
+		if m.RPCType != tc.rpcType || m.HasContext != tc.hasContext || m.InIndex != tc.inIndex ||
+			len(m.Params) != tc.params || len(m.Args()) != tc.args || len(m.Results) != tc.results {
+			t.Errorf("%s: got %s ctx=%v in=%d params=%d args=%d results=%d", tc.name,
+				m.RPCType, m.HasContext, m.InIndex, len(m.Params), len(m.Args()), len(m.Results))
+		}
+		if (m.InElem() != nil) != (tc.inIndex >= 0) {
+			t.Errorf("%s: InElem = %v", tc.name, m.InElem())
+		}
+		streams := tc.rpcType == ServerStream || tc.rpcType == BiDi
+		if (m.OutElem() != nil) != streams {
+			t.Errorf("%s: OutElem = %v", tc.name, m.OutElem())
+		}
+	}
+}
+
+// TestRejectedSignatures verifies each unsupported signature is reported
+// with the reason. The runtime dispatcher is tested against the same
+// table in gopherpipe.
+func TestRejectedSignatures(t *testing.T) {
+	cases := []struct {
+		method string
+		want   string
+	}{
+		{"M(x int) int", "last result must be error"},
+		{"M(x int)", "last result must be error"},
+		{"M(f func()) error", "func values cannot be sent"},
+		{"M(xs ...int) error", "variadic methods are not supported"},
+		{"M(ctx context.Context, a, b <-chan int) error", "at most one channel parameter"},
+		{"M(ctx context.Context, in chan<- int) error", "must be receive-only"},
+		{"M(ctx context.Context, in chan int) error", "must be receive-only"},
+		{"M(ctx context.Context, in <-chan func()) error", "stream parameter element: func values cannot be sent"},
+		{"M(ctx context.Context) (chan int, error)", "stream result must be a receive-only channel"},
+		{"M(ctx context.Context) (<-chan func(), error)", "stream element: func values cannot be sent"},
+		{"M(ctx context.Context) (<-chan int, int, error)", "stream result must be a receive-only channel"},
+		{"M(x int, ctx context.Context) error", "context.Context must be the first parameter"},
+		{"M(p unsafe.Pointer) error", "unsafe.Pointer cannot be sent"},
+		{"M(in <-chan int) error", "streaming methods must take a context.Context first"},
+		{"M(x int) (<-chan int, error)", "streaming methods must take a context.Context first"},
+	}
+	for _, tc := range cases {
+		_, err := load(t, map[string]string{"svc.go": `package svc
+
+import (
+	"context"
+	"unsafe"
+)
+
+var _ context.Context
+var _ unsafe.Pointer
+
+//gopherpipe:service
+type Svc interface {
+	` + tc.method + `
+}
+`})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.method, err, tc.want)
+		}
+	}
+}
+
+// TestDirectives verifies the idempotent and read-only directives are
+// detected on a line of their own in a method's doc comment, and that
+// read-only implies idempotent.
+func TestDirectives(t *testing.T) {
+	cases := []struct {
+		doc                  string
+		idempotent, readOnly bool
+	}{
+		{"", false, false},
+		{"// Get returns the value.", false, false},
+		{"//gopherpipe:idempotent", true, false},
+		{"//gopherpipe:readonly", true, true},
+		{"// Get returns the value.\n\t//\n\t//gopherpipe:readonly", true, true},
+		{"//gopherpipe:idempotent\n\t//gopherpipe:readonly", true, true},
+		{"// Not //gopherpipe:idempotent, just mentioned.", false, false},
+		{"//gopherpipe:idempotently", false, false},
+		{"/* //gopherpipe:idempotent */", false, false},
+	}
+	for _, tc := range cases {
+		pkg, err := load(t, map[string]string{"svc.go": `package svc
+
+//gopherpipe:service
+type Svc interface {
+	` + tc.doc + `
+	Get(key string) (string, error)
+}
+`})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.doc, err)
+		}
+		m := pkg.Services[0].Methods[0]
+		if m.Idempotent != tc.idempotent || m.ReadOnly != tc.readOnly {
+			t.Errorf("%q: idempotent %v, read-only %v; want %v, %v", tc.doc, m.Idempotent, m.ReadOnly, tc.idempotent, tc.readOnly)
+		}
+	}
+}
+
+// TestLoadHonoursBuildConstraints verifies files excluded by build
+// constraints or file name suffixes are not type-checked with the rest.
+func TestLoadHonoursBuildConstraints(t *testing.T) {
+	if runtime.GOOS == "plan9" {
+		t.Skip("the excluded platform is this one")
+	}
+	pkg, err := load(t, map[string]string{
+		"svc.go": `package svc
+
+//gopherpipe:service
+type Svc interface {
+	Name() (string, error)
+}
+`,
+		"name_" + runtime.GOOS + ".go": "package svc\n\nconst name = \"this platform\"\n",
+		"name_plan9.go":                "package svc\n\nconst name = \"plan 9\"\n",
+		"name_never.go":                "//go:build never\n\npackage svc\n\nconst name = \"never\"\n",
+		"tool.go":                      "//go:build ignore\n\npackage main\n\nfunc main() {}\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := pkg.Types.Scope().Lookup("name").(*types.Const); !ok || c.Val().ExactString() != `"this platform"` {
+		t.Fatalf("name = %v", pkg.Types.Scope().Lookup("name"))
+	}
+}
@@ -0,0 +1,25 @@
+// Package contracttest writes contract sources to disk for the tests of
+// internal/contract and of the generators built on it.
+package contracttest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// WriteModule writes files, by name, as the module example.com/svc in a
+// temporary directory and returns the directory, ready for contract.Load.
+func WriteModule(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/svc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
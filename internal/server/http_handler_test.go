@@ -0,0 +1,53 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anthony/gopher-pipe/internal/codec"
+	"github.com/anthony/gopher-pipe/internal/message"
+	"github.com/anthony/gopher-pipe/internal/tcplite"
+)
+
+// TestHTTPHandlerSamePort verifies HTTP requests are served by the
+// configured handler while TCP_LITE keeps working on the same port.
+func TestHTTPHandlerSamePort(t *testing.T) {
+	addr := "127.0.0.1:9311"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	go func() { _ = ServeConfig(addr, Config{HTTPHandler: mux}) }()
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("GET /healthz = %d %q", resp.StatusCode, body)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	b, _ := codec.Encode(message.Message{ID: 7, Body: "framed"})
+	if err := tcplite.WriteFrame(conn, tcplite.FrameTypeData, b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, payload, err := tcplite.ReadFrame(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var echoed message.Message
+	if err := codec.Decode(payload, &echoed); err != nil || echoed.Body != "framed" {
+		t.Fatalf("echo = %+v, %v", echoed, err)
+	}
+}
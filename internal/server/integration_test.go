@@ -24,7 +24,7 @@ func startLocalServer(t *testing.T) (addr string, stop func()) {
 			if err != nil {
 				return
 			}
-			go handleConn(conn)
+			go handleConn(conn, tcplite.Limits{})
 		}
 	}()
 	return ln.Addr().String(), func() { ln.Close(); <-stopped }
@@ -4,10 +4,14 @@
 package server
 
 import (
+	"errors"
 	"log"
 	"net"
+	"net/http"
 
 	"github.com/anthony/gopher-pipe/internal/codec"
+	"github.com/anthony/gopher-pipe/internal/connlimit"
+	"github.com/anthony/gopher-pipe/internal/connmux"
 	"github.com/anthony/gopher-pipe/internal/message"
 	"github.com/anthony/gopher-pipe/internal/tcplite"
 )
@@ -17,28 +21,70 @@ import (
 // message.Message marshaled with the codec package; the server echoes the
 // same payload back in a data frame.
 func Serve(addr string) error {
+	return ServeConfig(addr, Config{})
+}
+
+// Config holds optional server settings.
+type Config struct {
+	// HTTPHandler, if set, serves HTTP requests arriving on the same port
+	// (health probes, metrics, debug pages). Connections are told apart by
+	// their first byte. Without a handler HTTP clients get a canned 400.
+	HTTPHandler http.Handler
+	// Limits bound the time and memory reading frames from a connection
+	// may take. Their timeouts bound the requests of HTTPHandler too.
+	Limits tcplite.Limits
+	// MaxConns and MaxConnsPerIP cap the number of open connections, in
+	// total and per remote IP address; 0 means no limit.
+	MaxConns      int
+	MaxConnsPerIP int
+}
+
+// ServeConfig is Serve with optional settings.
+func ServeConfig(addr string, cfg Config) error {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	if cfg.MaxConns > 0 || cfg.MaxConnsPerIP > 0 {
+		ln = connlimit.New(ln, cfg.MaxConns, cfg.MaxConnsPerIP)
+	}
+	if cfg.HTTPHandler != nil {
+		mux := connmux.New(ln)
+		mux.PeekTimeout = cfg.Limits.IdleTimeout
+		go func() { _ = mux.Serve() }()
+		srv := &http.Server{
+			Handler:           cfg.HTTPHandler,
+			ReadHeaderTimeout: cfg.Limits.HeaderTimeout,
+			IdleTimeout:       cfg.Limits.IdleTimeout,
+		}
+		if cfg.Limits.PayloadTimeout > 0 {
+			srv.ReadTimeout = cfg.Limits.HeaderTimeout + cfg.Limits.PayloadTimeout
+		}
+		go func() { _ = srv.Serve(mux.HTTP()) }()
+		ln = mux.Frames()
+	}
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			log.Println("accept error:", err)
 			continue
 		}
-		go handleConn(conn)
+		go handleConn(conn, cfg.Limits)
 	}
 }
 
 // handleConn drives the lifecycle for a single connection — it reads
 // frames, decodes/encodes messages and handles simple control frame types
 // such as close and heartbeat.
-func handleConn(conn net.Conn) {
+func handleConn(conn net.Conn, limits tcplite.Limits) {
 	defer conn.Close()
 	log.Println("client connected:", conn.RemoteAddr())
+	frames := tcplite.NewConn(conn, limits)
 	for {
-		ftype, payload, err := tcplite.ReadFrame(conn)
+		ftype, payload, err := frames.ReadFrame()
 		if err != nil {
 			// special-case: if the header looked like HTTP (invalid frame header), reply with a friendly HTTP 400
 			if tcplite.IsInvalidFrameHeader(err) {
@@ -52,6 +98,8 @@ func handleConn(conn net.Conn) {
 			log.Println("read frame error:", err)
 			return
 		}
+		// echoing is quick, so only receiving a payload counts against the budget
+		frames.Release(payload)
 		switch ftype {
 		case tcplite.FrameTypeData:
 			var msg message.Message
@@ -75,8 +123,14 @@ func handleConn(conn net.Conn) {
 		case tcplite.FrameTypeClose:
 			log.Println("client requested close")
 			return
+		case tcplite.FrameTypeCancel:
+			// replies are sent before the next frame is read, so there
+			// is never a call left to cancel
 		case tcplite.FrameTypeHeartbeat:
-			// ignore
+			// answer keepalive pings, ignore anything else
+			if kind, data, ok := tcplite.DecodeHeartbeat(payload); ok && kind == tcplite.HeartbeatPing {
+				_ = tcplite.WriteFrame(conn, tcplite.FrameTypeHeartbeat, tcplite.EncodeHeartbeat(tcplite.HeartbeatAck, data))
+			}
 		default:
 			log.Println("unknown frame type:", ftype)
 		}
@@ -0,0 +1,248 @@
+package tcplite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bound what reading frames from one connection may cost a server.
+// Zero fields disable the corresponding limit.
+type Limits struct {
+	// IdleTimeout closes a connection that has neither received a frame
+	// nor had work in progress (see Conn.Begin) for this long. Heartbeat
+	// frames do not count, so keepalive pings cannot hold a connection
+	// open.
+	IdleTimeout time.Duration
+	// HeaderTimeout is the time the rest of a frame header may take once
+	// its first byte has arrived.
+	HeaderTimeout time.Duration
+	// PayloadTimeout is the time a frame payload may take to arrive once
+	// its header has been read, including waiting for Budget.
+	PayloadTimeout time.Duration
+	// MaxFrameSize caps the payload length; it defaults to MaxFrameSize.
+	MaxFrameSize int
+	// Budget, shared by the connections of a server, caps the memory held
+	// by payloads being read or not yet released.
+	Budget *Budget
+}
+
+var (
+	// ErrIdleTimeout is returned by Conn.ReadFrame when the connection
+	// has been idle for longer than Limits.IdleTimeout.
+	ErrIdleTimeout = errors.New("tcplite: idle timeout")
+	// ErrBudgetExhausted is returned by Conn.ReadFrame when the payload
+	// budget had no room for a frame before its payload timeout.
+	ErrBudgetExhausted = errors.New("tcplite: payload memory budget exhausted")
+)
+
+// Conn reads frames from a network connection under Limits. ReadFrame
+// must be called from a single goroutine; Begin, End and Release may be
+// called from any.
+type Conn struct {
+	net.Conn
+	limits Limits
+	timed  bool // whether any timeout is set
+
+	active     int64 // work in progress, accessed atomically
+	lastActive int64 // UnixNano of the last non-heartbeat frame or End, accessed atomically
+}
+
+// NewConn returns conn reading frames under limits.
+func NewConn(conn net.Conn, limits Limits) *Conn {
+	if limits.MaxFrameSize <= 0 {
+		limits.MaxFrameSize = MaxFrameSize
+	}
+	timed := limits.IdleTimeout > 0 || limits.HeaderTimeout > 0 || limits.PayloadTimeout > 0
+	return &Conn{Conn: conn, limits: limits, timed: timed, lastActive: time.Now().UnixNano()}
+}
+
+// Begin marks the start of work on the connection, such as a call being
+// served, during which it is not idle.
+func (c *Conn) Begin() {
+	atomic.AddInt64(&c.active, 1)
+}
+
+// End marks the end of work started with Begin.
+func (c *Conn) End() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	atomic.AddInt64(&c.active, -1)
+}
+
+// Busy reports whether work started with Begin is in progress.
+func (c *Conn) Busy() bool {
+	return atomic.LoadInt64(&c.active) > 0
+}
+
+// ReadFrame reads the next frame. The payload counts against the budget
+// until it is passed to Release.
+func (c *Conn) ReadFrame() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if err := c.awaitFrame(header[:1]); err != nil {
+		return 0, nil, err
+	}
+	c.setDeadline(c.limits.HeaderTimeout)
+	if _, err := io.ReadFull(c.Conn, header[1:]); err != nil {
+		return 0, nil, fmt.Errorf("read frame header: %w", err)
+	}
+	ftype, length, err := parseHeader(header, c.limits.MaxFrameSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	c.setDeadline(c.limits.PayloadTimeout)
+	if b := c.limits.Budget; b != nil && !b.acquire(int64(length), c.limits.PayloadTimeout) {
+		return 0, nil, ErrBudgetExhausted
+	}
+	payload, err := readPayload(c.Conn, length)
+	if err != nil {
+		if b := c.limits.Budget; b != nil {
+			b.release(int64(length))
+		}
+		return 0, nil, fmt.Errorf("read frame payload: %w", err)
+	}
+	if ftype != FrameTypeHeartbeat {
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	}
+	return ftype, payload, nil
+}
+
+// Release returns the memory of a payload read by ReadFrame to the budget.
+func (c *Conn) Release(payload []byte) {
+	if c.limits.Budget != nil {
+		c.limits.Budget.release(int64(len(payload)))
+	}
+}
+
+// awaitFrame reads the first byte of the next frame into b, failing with
+// ErrIdleTimeout once the connection has been idle for longer than the
+// idle timeout. Waiting is not limited while work is in progress.
+func (c *Conn) awaitFrame(b []byte) error {
+	idle := c.limits.IdleTimeout
+	for {
+		var deadline time.Time
+		if idle > 0 {
+			deadline = time.Now().Add(idle)
+			if atomic.LoadInt64(&c.active) == 0 {
+				deadline = time.Unix(0, atomic.LoadInt64(&c.lastActive)).Add(idle)
+			}
+		}
+		if c.timed {
+			c.Conn.SetReadDeadline(deadline)
+		}
+		_, err := io.ReadFull(c.Conn, b)
+		var ne net.Error
+		if err == nil || idle <= 0 || !errors.As(err, &ne) || !ne.Timeout() {
+			return err
+		}
+		// Nothing has been read, so waiting can simply start over.
+		if atomic.LoadInt64(&c.active) == 0 && time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive))) >= idle {
+			return ErrIdleTimeout
+		}
+	}
+}
+
+// setDeadline limits the next reads to d, or lifts the limit if d is 0.
+func (c *Conn) setDeadline(d time.Duration) {
+	if d > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(d))
+	} else if c.timed {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// payloadChunk is the amount of a large payload allocated ahead of the
+// bytes actually received, so announcing a huge frame and then sending it
+// slowly does not make the server allocate it all at once.
+const payloadChunk = 64 << 10
+
+// readPayload reads length bytes from r.
+func readPayload(r io.Reader, length int) ([]byte, error) {
+	if length <= payloadChunk {
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+	payload := make([]byte, 0, payloadChunk)
+	for len(payload) < length {
+		if len(payload) == cap(payload) {
+			size := 2 * cap(payload)
+			if size > length {
+				size = length
+			}
+			grown := make([]byte, len(payload), size)
+			copy(grown, payload)
+			payload = grown
+		}
+		n, err := io.ReadFull(r, payload[len(payload):cap(payload)])
+		payload = payload[:len(payload)+n]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// Budget is a memory allowance shared by connections, in bytes.
+type Budget struct {
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+	wake chan struct{} // closed and replaced whenever memory is released
+}
+
+// NewBudget returns a budget of limit bytes.
+func NewBudget(limit int64) *Budget {
+	return &Budget{limit: limit, wake: make(chan struct{})}
+}
+
+// acquire reserves n bytes, waiting up to timeout (or forever if timeout
+// is 0) for other connections to release memory. It reports whether the
+// bytes were reserved.
+func (b *Budget) acquire(n int64, timeout time.Duration) bool {
+	if n > b.limit {
+		return false
+	}
+	var expired <-chan time.Time
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return true
+		}
+		wake := b.wake
+		b.mu.Unlock()
+		if expired == nil && timeout > 0 {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case <-wake:
+		case <-expired:
+			return false
+		}
+	}
+}
+
+func (b *Budget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	close(b.wake)
+	b.wake = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// Used returns the number of bytes currently reserved.
+func (b *Budget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,151 @@
+package tcplite
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipe returns a Conn reading from one end of an in-memory connection and
+// the other end to write to.
+func pipe(t *testing.T, limits Limits) (*Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return NewConn(server, limits), client
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// TestConnSlowFrames verifies a header or payload that trickles in for
+// longer than its timeout fails the read.
+func TestConnSlowFrames(t *testing.T) {
+	limits := Limits{HeaderTimeout: 50 * time.Millisecond, PayloadTimeout: 50 * time.Millisecond}
+
+	c, client := pipe(t, limits)
+	go client.Write([]byte{FrameTypeData, 0})
+	if _, _, err := c.ReadFrame(); !isTimeout(err) {
+		t.Fatalf("partial header: %v", err)
+	}
+
+	c, client = pipe(t, limits)
+	go func(client net.Conn) {
+		client.Write([]byte{FrameTypeData, 0, 0, 0, 10, 'a'})
+		time.Sleep(time.Second)
+		client.Write(make([]byte, 9))
+	}(client)
+	if _, _, err := c.ReadFrame(); !isTimeout(err) {
+		t.Fatalf("slow payload: %v", err)
+	}
+
+	c, client = pipe(t, Limits{MaxFrameSize: 4})
+	go client.Write([]byte{FrameTypeData, 0, 0, 0, 5})
+	if _, _, err := c.ReadFrame(); err == nil {
+		t.Fatal("frame over MaxFrameSize accepted")
+	}
+}
+
+// TestConnIdleTimeout verifies the idle timeout only runs while no work
+// is in progress.
+func TestConnIdleTimeout(t *testing.T) {
+	c, client := pipe(t, Limits{IdleTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	if _, _, err := c.ReadFrame(); err != ErrIdleTimeout {
+		t.Fatalf("idle read: %v", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("idle timeout after %v", d)
+	}
+
+	c, client = pipe(t, Limits{IdleTimeout: 50 * time.Millisecond})
+	c.Begin()
+	go func(client net.Conn) {
+		time.Sleep(150 * time.Millisecond)
+		WriteFrame(client, FrameTypeData, []byte("late"))
+	}(client)
+	if _, p, err := c.ReadFrame(); err != nil || string(p) != "late" {
+		t.Fatalf("read during work: %q %v", p, err)
+	}
+	c.End()
+	if _, _, err := c.ReadFrame(); err != ErrIdleTimeout {
+		t.Fatalf("idle read after work: %v", err)
+	}
+
+	// heartbeats alone do not keep the connection open
+	c, client = pipe(t, Limits{IdleTimeout: 100 * time.Millisecond})
+	go func(client net.Conn) {
+		for i := 0; i < 10; i++ {
+			if WriteFrame(client, FrameTypeHeartbeat, EncodeHeartbeat(HeartbeatPing, 0)) != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}(client)
+	for {
+		ftype, _, err := c.ReadFrame()
+		if err == ErrIdleTimeout {
+			break
+		}
+		if err != nil || ftype != FrameTypeHeartbeat {
+			t.Fatalf("read %d %v", ftype, err)
+		}
+	}
+}
+
+// TestConnLargePayload verifies a payload larger than the allocation
+// chunk is read completely.
+func TestConnLargePayload(t *testing.T) {
+	c, client := pipe(t, Limits{})
+	want := bytes.Repeat([]byte("0123456789"), 50000)
+	go WriteFrame(client, FrameTypeData, want)
+	_, got, err := c.ReadFrame()
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("read %d bytes, %v", len(got), err)
+	}
+}
+
+// TestConnBudget verifies payloads reserve the shared budget until
+// released and that readers wait for room up to the payload timeout.
+func TestConnBudget(t *testing.T) {
+	budget := NewBudget(10)
+	limits := Limits{PayloadTimeout: 100 * time.Millisecond, Budget: budget}
+	a, clientA := pipe(t, limits)
+	b, clientB := pipe(t, limits)
+
+	go WriteFrame(clientA, FrameTypeData, []byte("12345678"))
+	_, held, err := a.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.Used() != 8 {
+		t.Fatalf("used = %d, want 8", budget.Used())
+	}
+	go WriteFrame(clientB, FrameTypeData, []byte("abcd"))
+	if _, _, err := b.ReadFrame(); err != ErrBudgetExhausted {
+		t.Fatalf("read over budget: %v", err)
+	}
+
+	b, clientB = pipe(t, limits)
+	go WriteFrame(clientB, FrameTypeData, []byte("abcd"))
+	time.AfterFunc(20*time.Millisecond, func() { a.Release(held) })
+	if _, p, err := b.ReadFrame(); err != nil || string(p) != "abcd" {
+		t.Fatalf("read after release: %q %v", p, err)
+	}
+	if budget.Used() != 4 {
+		t.Fatalf("used = %d, want 4", budget.Used())
+	}
+
+	b, clientB = pipe(t, limits)
+	go WriteFrame(clientB, FrameTypeData, make([]byte, 11))
+	if _, _, err := b.ReadFrame(); err != ErrBudgetExhausted {
+		t.Fatalf("frame larger than the budget: %v", err)
+	}
+}
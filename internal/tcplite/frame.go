@@ -19,6 +19,9 @@ const (
 	FrameTypeHeartbeat byte = 0x02
 	FrameTypeError     byte = 0x03
 	FrameTypeClose     byte = 0x04
+	// FrameTypeCancel asks the peer to stop working on a call it no
+	// longer needs the result of; see EncodeCancel.
+	FrameTypeCancel byte = 0x07
 	// optional future frame types
 	FrameTypeServiceReg    byte = 0x05
 	FrameTypeServiceLookup byte = 0x06
@@ -38,6 +41,17 @@ func WriteFrame(w io.Writer, ftype byte, payload []byte) error {
 	return err
 }
 
+// IsFrameType reports whether b is a known frame type. Every TCP_LITE
+// stream starts with one, so a connection whose first byte is not a frame
+// type speaks another protocol (typically HTTP).
+func IsFrameType(b byte) bool {
+	switch b {
+	case FrameTypeData, FrameTypeHeartbeat, FrameTypeError, FrameTypeClose, FrameTypeCancel, FrameTypeServiceReg, FrameTypeServiceLookup:
+		return true
+	}
+	return false
+}
+
 // ReadFrame reads a single frame from r and returns the frame type and
 // payload. The function performs basic validation of frame types and caps
 // payload length with a sanity check to prevent large/allocation attacks.
@@ -46,25 +60,35 @@ func ReadFrame(r io.Reader) (byte, []byte, error) {
 	if _, err := io.ReadFull(r, header); err != nil {
 		return 0, nil, err
 	}
+	ftype, length, err := parseHeader(header, MaxFrameSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return ftype, payload, nil
+}
+
+// MaxFrameSize is the largest payload ReadFrame accepts.
+const MaxFrameSize = 10 << 20
+
+// parseHeader validates a frame header and returns the frame type and
+// payload length.
+func parseHeader(header []byte, max int) (byte, int, error) {
 	ftype := header[0]
 
 	// validate frame type before trusting length bytes — if someone connects with HTTP
 	// or another protocol we should reject early (the length bytes would otherwise look huge).
-	switch ftype {
-	case FrameTypeData, FrameTypeHeartbeat, FrameTypeError, FrameTypeClose, FrameTypeServiceReg, FrameTypeServiceLookup:
-		// valid
-	default:
-		return 0, nil, &InvalidFrameHeaderError{Header: append([]byte(nil), header...)}
+	if !IsFrameType(ftype) {
+		return 0, 0, &InvalidFrameHeaderError{Header: append([]byte(nil), header...)}
 	}
 	length := binary.BigEndian.Uint32(header[1:])
-	if length > 10<<20 { // 10MB sanity check
-		return 0, nil, fmt.Errorf("frame too large: %d (header=%x)", length, header)
+	if uint64(length) > uint64(max) {
+		return 0, 0, fmt.Errorf("frame too large: %d (header=%x)", length, header)
 	}
-	payload := make([]byte, length)
-	if _, err := io.ReadFull(r, payload); err != nil {
-		return 0, nil, err
-	}
-	return ftype, payload, nil
+	return ftype, int(length), nil
 }
 
 // InvalidFrameHeaderError indicates the first header bytes did not match a known TCP_LITE frame type
@@ -92,3 +116,45 @@ func IsInvalidFrameHeader(err error) bool {
 	var ie *InvalidFrameHeaderError
 	return errors.As(err, &ie)
 }
+
+// Heartbeat frame kinds. A heartbeat payload is the kind followed by 8
+// bytes of opaque data, which an ACK echoes back from its PING so the
+// sender can match the two (typically the time the PING was sent).
+const (
+	HeartbeatPing byte = 0x01
+	HeartbeatAck  byte = 0x02
+)
+
+// EncodeHeartbeat returns the payload of a heartbeat frame.
+func EncodeHeartbeat(kind byte, data uint64) []byte {
+	p := make([]byte, 9)
+	p[0] = kind
+	binary.BigEndian.PutUint64(p[1:], data)
+	return p
+}
+
+// DecodeHeartbeat parses a heartbeat payload. ok is false if p is not a
+// valid one.
+func DecodeHeartbeat(p []byte) (kind byte, data uint64, ok bool) {
+	if len(p) != 9 || (p[0] != HeartbeatPing && p[0] != HeartbeatAck) {
+		return 0, 0, false
+	}
+	return p[0], binary.BigEndian.Uint64(p[1:]), true
+}
+
+// EncodeCancel returns the payload of a cancel frame: the 8-byte ID of
+// the call to stop.
+func EncodeCancel(callID uint64) []byte {
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, callID)
+	return p
+}
+
+// DecodeCancel parses a cancel payload. ok is false if p is not a valid
+// one.
+func DecodeCancel(p []byte) (callID uint64, ok bool) {
+	if len(p) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(p), true
+}
@@ -41,3 +41,29 @@ func TestReadInvalidHeader(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+// TestHeartbeat verifies heartbeat payloads round-trip and malformed ones
+// are rejected.
+func TestHeartbeat(t *testing.T) {
+	kind, data, ok := DecodeHeartbeat(EncodeHeartbeat(HeartbeatAck, 42))
+	if !ok || kind != HeartbeatAck || data != 42 {
+		t.Fatalf("decoded %d %d %v", kind, data, ok)
+	}
+	for _, p := range [][]byte{nil, {HeartbeatPing}, append([]byte{0x7f}, make([]byte, 8)...)} {
+		if _, _, ok := DecodeHeartbeat(p); ok {
+			t.Errorf("DecodeHeartbeat(%x) accepted", p)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	if id, ok := DecodeCancel(EncodeCancel(7)); !ok || id != 7 {
+		t.Fatalf("decoded %d %v", id, ok)
+	}
+	if _, ok := DecodeCancel([]byte{1, 2}); ok {
+		t.Error("short cancel payload accepted")
+	}
+	if !IsFrameType(FrameTypeCancel) {
+		t.Error("cancel is not a frame type")
+	}
+}